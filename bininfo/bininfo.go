@@ -0,0 +1,177 @@
+// Package bininfo describes executables and shared libraries: their format,
+// architecture, dynamic linking, symbols and the build metadata Go and
+// cargo-auditable embed in them.
+package bininfo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime/debug"
+)
+
+// maxAuditableSize bounds the decompressed cargo-auditable data, which is a
+// few kilobytes for real binaries.
+const maxAuditableSize = 8 << 20
+
+// ErrUnknownFormat is returned for files that aren't ELF, PE or Mach-O.
+var ErrUnknownFormat = errors.New("not an ELF, PE or Mach-O binary")
+
+// Info describes a binary.
+type Info struct {
+	// Format is ELF, PE or Mach-O
+	Format string
+	// Arch is a GOARCH name where there is one
+	Arch string
+	// Architectures are all those in a universal Mach-O binary, the first of
+	// which the rest of Info describes
+	Architectures []string `json:",omitempty"`
+	Bits          int
+	// Type is executable, pie executable, shared library, bundle, object or
+	// core dump
+	Type        string
+	Interpreter string   `json:",omitempty"`
+	Libraries   []string `json:",omitempty"`
+	RunPath     []string `json:",omitempty"`
+	BuildID     string   `json:",omitempty"`
+	// Stripped is set if the binary has no local symbols
+	Stripped bool
+	// Debug is set if the binary has DWARF debug info
+	Debug bool
+	Go    *GoInfo   `json:",omitempty"`
+	Rust  *RustInfo `json:",omitempty"`
+}
+
+// GoInfo is the build info of a Go binary.
+type GoInfo struct {
+	GoVersion string
+	// Path is the main package's import path
+	Path     string
+	Main     GoModule
+	Deps     []GoModule        `json:",omitempty"`
+	VCS      *VCS              `json:",omitempty"`
+	Settings map[string]string `json:",omitempty"`
+}
+
+type GoModule struct {
+	Path    string
+	Version string
+	Sum     string    `json:",omitempty"`
+	Replace *GoModule `json:",omitempty"`
+}
+
+// VCS is the commit a Go binary was built from.
+type VCS struct {
+	System   string
+	Revision string
+	Time     string `json:",omitempty"`
+	Modified bool
+}
+
+// RustInfo is the dependency tree cargo-auditable embeds in Rust binaries.
+type RustInfo struct {
+	Packages []RustPackage
+}
+
+type RustPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	// Kind is empty for runtime dependencies, or build for build ones
+	Kind string `json:"kind,omitempty"`
+	Root bool   `json:"root,omitempty"`
+}
+
+// Read describes the binary in r. Parsers read little of a binary, so r can
+// fetch it lazily. Errors other than r's are about a malformed binary.
+func Read(r io.ReaderAt) (*Info, error) {
+	magic := make([]byte, 4)
+	_, err := r.ReadAt(magic, 0)
+	if err == io.EOF {
+		return nil, ErrUnknownFormat
+	} else if err != nil {
+		return nil, err
+	}
+
+	var info *Info
+	switch {
+	case bytes.Equal(magic, []byte("\x7fELF")):
+		info, err = readELF(r)
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		info, err = readPE(r)
+	case isMachO(magic):
+		info, err = readMachO(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Go = readGo(r)
+	return info, nil
+}
+
+// readGo returns nil for binaries that weren't built by Go.
+func readGo(r io.ReaderAt) *GoInfo {
+	bi, err := buildinfo.Read(r)
+	if err != nil {
+		return nil
+	}
+
+	info := &GoInfo{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Main:      goModule(&bi.Main),
+		Settings:  map[string]string{},
+	}
+	for _, dep := range bi.Deps {
+		info.Deps = append(info.Deps, goModule(dep))
+	}
+
+	for _, s := range bi.Settings {
+		info.Settings[s.Key] = s.Value
+	}
+	if system := info.Settings["vcs"]; system != "" {
+		info.VCS = &VCS{
+			System:   system,
+			Revision: info.Settings["vcs.revision"],
+			Time:     info.Settings["vcs.time"],
+			Modified: info.Settings["vcs.modified"] == "true",
+		}
+	}
+
+	return info
+}
+
+func goModule(m *debug.Module) GoModule {
+	gm := GoModule{Path: m.Path, Version: m.Version, Sum: m.Sum}
+	if m.Replace != nil {
+		replace := goModule(m.Replace)
+		gm.Replace = &replace
+	}
+	return gm
+}
+
+// readRust returns nil if section, the contents of the .dep-v0 section,
+// isn't cargo-auditable data. It's optional, so a malformed one doesn't make
+// the binary malformed.
+func readRust(section io.Reader) *RustInfo {
+	zr, err := zlib.NewReader(section)
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	info := &RustInfo{}
+	err = json.NewDecoder(io.LimitReader(zr, maxAuditableSize)).Decode(&struct {
+		Packages *[]RustPackage `json:"packages"`
+	}{&info.Packages})
+	if err != nil || len(info.Packages) == 0 {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,77 @@
+package bininfo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary is only ELF on linux")
+	}
+
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	f, err := os.Open(exe)
+	require.NoError(t, err)
+	defer f.Close()
+
+	info, err := Read(f)
+	require.NoError(t, err)
+	require.Equal(t, "ELF", info.Format)
+	require.Equal(t, runtime.GOARCH, info.Arch)
+	require.Equal(t, 64, info.Bits)
+	require.Nil(t, info.Rust)
+
+	require.NotNil(t, info.Go)
+	require.Equal(t, runtime.Version(), info.Go.GoVersion)
+	require.Equal(t, "browseimage/bininfo.test", info.Go.Path)
+	deps := []string{}
+	for _, dep := range info.Go.Deps {
+		deps = append(deps, dep.Path)
+	}
+	require.Contains(t, deps, "github.com/stretchr/testify")
+}
+
+func TestReadUnknownFormat(t *testing.T) {
+	for _, contents := range []string{
+		"",
+		"#!/bin/sh\necho hello\n",
+		// a Java class file
+		"\xca\xfe\xba\xbe\x00\x00\x00\x41\x00\x1d",
+	} {
+		_, err := Read(strings.NewReader(contents))
+		require.ErrorIs(t, err, ErrUnknownFormat)
+	}
+
+	// a truncated DOS header
+	_, err := Read(strings.NewReader("MZ\x90\x00"))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestReadRust(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	_, err := zw.Write([]byte(`{"packages":[
+		{"name":"hello","version":"0.1.0","source":"local","dependencies":[1],"root":true},
+		{"name":"serde","version":"1.0.197","source":"crates.io"},
+		{"name":"cc","version":"1.0.90","source":"crates.io","kind":"build"}
+	]}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	require.Equal(t, &RustInfo{Packages: []RustPackage{
+		{Name: "hello", Version: "0.1.0", Source: "local", Root: true},
+		{Name: "serde", Version: "1.0.197", Source: "crates.io"},
+		{Name: "cc", Version: "1.0.90", Source: "crates.io", Kind: "build"},
+	}}, readRust(buf))
+
+	require.Nil(t, readRust(strings.NewReader("not zlib")))
+}
@@ -0,0 +1,143 @@
+package bininfo
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func readELF(r io.ReaderAt) (*Info, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ELF: %w", err)
+	}
+
+	info := &Info{
+		Format:   "ELF",
+		Arch:     elfArch(f),
+		Bits:     32,
+		Stripped: f.Section(".symtab") == nil,
+		Debug:    f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil,
+	}
+	if f.Class == elf.ELFCLASS64 {
+		info.Bits = 64
+	}
+
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := io.ReadAll(io.LimitReader(p.Open(), 4096))
+		if err != nil {
+			return nil, fmt.Errorf("reading interpreter: %w", err)
+		}
+		info.Interpreter = strings.TrimRight(string(interp), "\x00")
+	}
+
+	switch f.Type {
+	case elf.ET_EXEC:
+		info.Type = "executable"
+	case elf.ET_DYN:
+		// static PIEs have no interpreter, but are flagged
+		flags, _ := f.DynValue(elf.DT_FLAGS_1)
+		if info.Interpreter != "" || len(flags) > 0 && flags[0]&uint64(elf.DF_1_PIE) != 0 {
+			info.Type = "pie executable"
+		} else {
+			info.Type = "shared library"
+		}
+	case elf.ET_REL:
+		info.Type = "object"
+	case elf.ET_CORE:
+		info.Type = "core dump"
+	default:
+		info.Type = f.Type.String()
+	}
+
+	info.Libraries, err = f.ImportedLibraries()
+	if err != nil {
+		return nil, fmt.Errorf("reading shared libraries: %w", err)
+	}
+
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		paths, err := f.DynString(tag)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", tag, err)
+		}
+		for _, p := range paths {
+			info.RunPath = append(info.RunPath, strings.Split(p, ":")...)
+		}
+	}
+
+	if s := f.Section(".note.gnu.build-id"); s != nil {
+		note, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("reading build ID: %w", err)
+		}
+		info.BuildID = gnuBuildID(f, note)
+	}
+
+	if s := f.Section(".dep-v0"); s != nil {
+		info.Rust = readRust(s.Open())
+	}
+
+	return info, nil
+}
+
+// gnuBuildID is the descriptor of a GNU build ID note, in hex.
+func gnuBuildID(f *elf.File, note []byte) string {
+	if len(note) < 12 {
+		return ""
+	}
+	nameSize := int(f.ByteOrder.Uint32(note))
+	descSize := int(f.ByteOrder.Uint32(note[4:]))
+
+	// the name is padded to 4 bytes
+	start := 12 + (nameSize+3)&^3
+	if start+descSize > len(note) {
+		return ""
+	}
+	return hex.EncodeToString(note[start : start+descSize])
+}
+
+// elfArch names the architecture as GOARCH does, or as ELF does for those
+// Go doesn't support.
+func elfArch(f *elf.File) string {
+	le := f.Data == elf.ELFDATA2LSB
+	is64 := f.Class == elf.ELFCLASS64
+
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_386:
+		return "386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_PPC64:
+		if le {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_RISCV:
+		if is64 {
+			return "riscv64"
+		}
+	case elf.EM_LOONGARCH:
+		return "loong64"
+	case elf.EM_MIPS:
+		arch := "mips"
+		if is64 {
+			arch = "mips64"
+		}
+		if le {
+			arch += "le"
+		}
+		return arch
+	}
+	return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+}
@@ -0,0 +1,150 @@
+package bininfo
+
+import (
+	"bytes"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	loadCmdDylinker = 0xe
+	loadCmdUUID     = 0x1b
+
+	// the symbol type bits of nlist entries
+	symbolExternal = 0x01
+)
+
+func isMachO(magic []byte) bool {
+	be := binary.BigEndian.Uint32(magic)
+	le := binary.LittleEndian.Uint32(magic)
+	for _, m := range []uint32{macho.Magic32, macho.Magic64} {
+		if be == m || le == m {
+			return true
+		}
+	}
+	return be == macho.MagicFat
+}
+
+func readMachO(r io.ReaderAt) (*Info, error) {
+	magic := make([]byte, 4)
+	_, err := r.ReadAt(magic, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(magic) == macho.MagicFat {
+		fat, err := macho.NewFatFile(r)
+		if err != nil {
+			// Java class files start with the same magic number
+			return nil, fmt.Errorf("%w: %v", ErrUnknownFormat, err)
+		}
+
+		info, err := describeMachO(fat.Arches[0].File)
+		if err != nil {
+			return nil, err
+		}
+		for _, arch := range fat.Arches {
+			info.Architectures = append(info.Architectures, machOArch(arch.Cpu))
+		}
+		return info, nil
+	}
+
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Mach-O: %w", err)
+	}
+	return describeMachO(f)
+}
+
+func describeMachO(f *macho.File) (*Info, error) {
+	info := &Info{
+		Format:   "Mach-O",
+		Arch:     machOArch(f.Cpu),
+		Bits:     32,
+		Stripped: true,
+		Debug:    f.Section("__debug_info") != nil || f.Section("__zdebug_info") != nil,
+	}
+	if f.Magic == macho.Magic64 {
+		info.Bits = 64
+	}
+
+	switch f.Type {
+	case macho.TypeExec:
+		info.Type = "executable"
+	case macho.TypeDylib:
+		info.Type = "shared library"
+	case macho.TypeBundle:
+		info.Type = "bundle"
+	case macho.TypeObj:
+		info.Type = "object"
+	default:
+		info.Type = f.Type.String()
+	}
+
+	var err error
+	info.Libraries, err = f.ImportedLibraries()
+	if err != nil {
+		return nil, fmt.Errorf("reading shared libraries: %w", err)
+	}
+
+	for _, l := range f.Loads {
+		if rpath, ok := l.(*macho.Rpath); ok {
+			info.RunPath = append(info.RunPath, rpath.Path)
+			continue
+		}
+
+		raw := l.Raw()
+		if len(raw) < 12 {
+			continue
+		}
+		switch f.ByteOrder.Uint32(raw) {
+		case loadCmdDylinker:
+			// the offset of the path within the command
+			offset := f.ByteOrder.Uint32(raw[8:])
+			if int(offset) < len(raw) {
+				path, _, _ := bytes.Cut(raw[offset:], []byte{0})
+				info.Interpreter = string(path)
+			}
+		case loadCmdUUID:
+			if len(raw) >= 24 {
+				u := raw[8:24]
+				info.BuildID = fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+			}
+		}
+	}
+
+	if f.Symtab != nil {
+		for _, sym := range f.Symtab.Syms {
+			if sym.Type&symbolExternal == 0 {
+				info.Stripped = false
+				break
+			}
+		}
+	}
+
+	if s := f.Section("__dep_v0"); s != nil {
+		info.Rust = readRust(s.Open())
+	}
+
+	return info, nil
+}
+
+func machOArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuPpc64:
+		return "ppc64"
+	case macho.CpuPpc:
+		return "ppc"
+	}
+	return cpu.String()
+}
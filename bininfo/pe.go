@@ -0,0 +1,73 @@
+package bininfo
+
+import (
+	"debug/pe"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func readPE(r io.ReaderAt) (*Info, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing PE: %w", err)
+	}
+
+	info := &Info{
+		Format: "PE",
+		// symbols are usually in a separate PDB, so only those built by
+		// MinGW or Go have any
+		Stripped: f.NumberOfSymbols == 0,
+		Debug:    f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil,
+	}
+
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		info.Arch = "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		info.Arch = "386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		info.Arch = "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT, pe.IMAGE_FILE_MACHINE_ARM:
+		info.Arch = "arm"
+	default:
+		info.Arch = fmt.Sprintf("0x%x", f.Machine)
+	}
+
+	switch f.OptionalHeader.(type) {
+	case *pe.OptionalHeader64:
+		info.Bits = 64
+	default:
+		info.Bits = 32
+	}
+
+	switch {
+	case f.Characteristics&pe.IMAGE_FILE_DLL != 0:
+		info.Type = "shared library"
+	case f.Characteristics&pe.IMAGE_FILE_EXECUTABLE_IMAGE != 0:
+		info.Type = "executable"
+	default:
+		info.Type = "object"
+	}
+
+	// ImportedLibraries isn't implemented for PE, but the DLLs are in the
+	// imported symbols, as function:dll
+	symbols, err := f.ImportedSymbols()
+	if err != nil {
+		return nil, fmt.Errorf("reading imports: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, sym := range symbols {
+		_, dll, ok := strings.Cut(sym, ":")
+		if ok && !seen[strings.ToLower(dll)] {
+			seen[strings.ToLower(dll)] = true
+			info.Libraries = append(info.Libraries, dll)
+		}
+	}
+
+	if s := f.Section(".dep-v0"); s != nil {
+		info.Rust = readRust(s.Open())
+	}
+
+	return info, nil
+}
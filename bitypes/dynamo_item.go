@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"strings"
 	"time"
 )
@@ -23,6 +24,17 @@ func (d *ImageInfoKey) Key() map[string]types.AttributeValue {
 	return m
 }
 
+// ImageInfoVersion is stored as "v" on image items. It is bumped whenever the
+// indexes an image is served from change format, so that images indexed
+// before the change are indexed again.
+//
+//   - 1 (or no version): gzip indexes written by gztool, and an image index
+//     of every entry in one gzipped JSON stream, read with S3 Select
+//   - 2: native gzip and zstd indexes (see targzi.GzIndex), and an image
+//     index sorted by parent directory in range-searchable blocks (see
+//     sortedindex), with file digests and directory rollups
+const ImageInfoVersion = 2
+
 type ImageInfoStatus string
 
 const (
@@ -42,6 +54,18 @@ type ImageInfoItem struct {
 	Status      ImageInfoStatus
 	Manifest    json.RawMessage
 	RawConfig   json.RawMessage
+	// Efficiency is set once indexing succeeds
+	Efficiency *Efficiency `json:",omitempty"`
+	// Secrets is set once the optional secret scan finishes
+	Secrets *Secrets `json:",omitempty"`
+	// Vulns is set once /api/vulns has matched the image's packages
+	Vulns *Vulns `json:",omitempty"`
+	// Index and Platform are set for images indexed as a platform of an
+	// image index
+	Index    string       `json:",omitempty"`
+	Platform *v1.Platform `json:",omitempty"`
+	// Version is the ImageInfoVersion the image was indexed with
+	Version int `json:",omitempty"`
 }
 
 func (d *ImageInfoItem) DynamoItem() map[string]types.AttributeValue {
@@ -55,9 +79,23 @@ func (d *ImageInfoItem) DynamoItem() map[string]types.AttributeValue {
 		"Manifest":    d.Manifest,
 		"RawConfig":   d.RawConfig,
 		"ttl":         time.Now().Add(90 * 24 * time.Hour).Unix(),
-		"v":           1,
+		"v":           d.Version,
 	})
 
+	if d.Efficiency != nil {
+		m["Efficiency"], _ = attributevalue.Marshal(d.Efficiency)
+	}
+	if d.Secrets != nil {
+		m["Secrets"], _ = attributevalue.Marshal(d.Secrets)
+	}
+	if d.Vulns != nil {
+		m["Vulns"], _ = attributevalue.Marshal(d.Vulns)
+	}
+	if d.Index != "" {
+		m["Index"], _ = attributevalue.Marshal(d.Index)
+		m["Platform"], _ = attributevalue.Marshal(d.Platform)
+	}
+
 	for k, v := range d.Key() {
 		m[k] = v
 	}
@@ -95,6 +133,46 @@ func (d *ImageInfoItem) UnmarshalDynamoDBAttributeValue(value types.AttributeVal
 	d.Status = ImageInfoStatus(mss["Status"].(string))
 	d.TotalSize = int64(mss["TotalSize"].(float64))
 	d.Duration = time.Duration(mss["Duration"].(float64))
+	if v, ok := mss["v"].(float64); ok {
+		d.Version = int(v)
+	}
+
+	// images indexed before efficiency was measured don't have it
+	if av, ok := value.(*types.AttributeValueMemberM); ok && av.Value["Efficiency"] != nil {
+		d.Efficiency = &Efficiency{}
+		err = attributevalue.Unmarshal(av.Value["Efficiency"], d.Efficiency)
+		if err != nil {
+			return fmt.Errorf("unmarshalling efficiency: %w", err)
+		}
+	}
+
+	// and only images that were scanned have secrets
+	if av, ok := value.(*types.AttributeValueMemberM); ok && av.Value["Secrets"] != nil {
+		d.Secrets = &Secrets{}
+		err = attributevalue.Unmarshal(av.Value["Secrets"], d.Secrets)
+		if err != nil {
+			return fmt.Errorf("unmarshalling secrets: %w", err)
+		}
+	}
+
+	// nor have vulnerabilities until they're asked for
+	if av, ok := value.(*types.AttributeValueMemberM); ok && av.Value["Vulns"] != nil {
+		d.Vulns = &Vulns{}
+		err = attributevalue.Unmarshal(av.Value["Vulns"], d.Vulns)
+		if err != nil {
+			return fmt.Errorf("unmarshalling vulns: %w", err)
+		}
+	}
+
+	if index, ok := mss["Index"].(string); ok {
+		d.Index = index
+		if av, ok := value.(*types.AttributeValueMemberM); ok && av.Value["Platform"] != nil {
+			err = attributevalue.Unmarshal(av.Value["Platform"], &d.Platform)
+			if err != nil {
+				return fmt.Errorf("unmarshalling platform: %w", err)
+			}
+		}
+	}
 
 	retrievedStr := mss["Retrieved"].(string)
 	d.Retrieved, err = time.Parse(time.RFC3339Nano, retrievedStr)
@@ -0,0 +1,45 @@
+package bitypes
+
+// Efficiency is how much of what an image ships ends up in its final
+// filesystem, in the spirit of dive.
+type Efficiency struct {
+	// TotalBytes is the size of the regular files in every layer
+	TotalBytes int64
+	// WastedBytes is the size of the files that later layers overwrote or
+	// deleted
+	WastedBytes int64
+	// Score is the share of TotalBytes that isn't wasted
+	Score      float64
+	TopWasted  []WastedPath
+	Duplicates []DuplicateFile
+	// LayerBytes is the size of the regular files each layer ships, by
+	// layer digest
+	LayerBytes map[string]int64 `json:",omitempty"`
+}
+
+// WastedPath is a path that more than one layer shipped, or that a later
+// layer deleted.
+type WastedPath struct {
+	Path string
+	// Count is how many layers shipped the path
+	Count       int
+	WastedBytes int64
+}
+
+// DuplicateFile is the same contents shipped by more than one layer, at the
+// same path or not.
+type DuplicateFile struct {
+	Digest string
+	Size   int64
+	Count  int
+	// Copies lists the first few of the Count copies
+	Copies []FileCopy
+	// WastedBytes is the size of a copy in every layer but one; copies within
+	// the same layer aren't counted
+	WastedBytes int64
+}
+
+type FileCopy struct {
+	Layer string
+	Path  string
+}
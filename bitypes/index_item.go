@@ -0,0 +1,94 @@
+package bitypes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// IndexInfoKey is a multi-platform image index. Indexes share the partition
+// of their repository with its images.
+type IndexInfoKey struct {
+	Repo   string
+	Digest string
+}
+
+func (d *IndexInfoKey) Key() map[string]types.AttributeValue {
+	m, _ := attributevalue.MarshalMap(map[string]any{
+		"pk": fmt.Sprintf("image#%s", d.Repo),
+		"sk": fmt.Sprintf("index#%s", d.Digest),
+	})
+
+	return m
+}
+
+// IndexInfoItem records the platform images of an index, each of which is
+// indexed as an image of its own.
+type IndexInfoItem struct {
+	IndexInfoKey
+	MediaType string
+	Manifests []IndexManifest
+	Retrieved time.Time
+}
+
+type IndexManifest struct {
+	Digest    string
+	MediaType string
+	Platform  *v1.Platform `json:",omitempty"`
+	// Attestation is set for the provenance and SBOM manifests BuildKit
+	// adds, which aren't images anyone runs
+	Attestation bool `json:",omitempty"`
+}
+
+func (d *IndexInfoItem) DynamoItem() map[string]types.AttributeValue {
+	m, _ := attributevalue.MarshalMap(map[string]any{
+		"MediaType": d.MediaType,
+		"Manifests": d.Manifests,
+		"Retrieved": d.Retrieved.Format(time.RFC3339Nano),
+		"ttl":       time.Now().Add(90 * 24 * time.Hour).Unix(),
+		"v":         1,
+	})
+
+	for k, v := range d.Key() {
+		m[k] = v
+	}
+
+	return m
+}
+
+func (d *IndexInfoItem) UnmarshalDynamoDBAttributeValue(value types.AttributeValue) error {
+	fields := struct {
+		PK        string `dynamodbav:"pk"`
+		SK        string `dynamodbav:"sk"`
+		MediaType string
+		Manifests []IndexManifest
+		Retrieved string
+	}{}
+	err := attributevalue.Unmarshal(value, &fields)
+	if err != nil {
+		return fmt.Errorf("unmarshalling index info: %w", err)
+	}
+
+	var ok bool
+	d.Repo, ok = strings.CutPrefix(fields.PK, "image#")
+	if !ok {
+		return fmt.Errorf("incorrect format for pk")
+	}
+	d.Digest, ok = strings.CutPrefix(fields.SK, "index#")
+	if !ok {
+		return fmt.Errorf("incorrect format for sk")
+	}
+
+	d.MediaType = fields.MediaType
+	d.Manifests = fields.Manifests
+	d.Retrieved, err = time.Parse(time.RFC3339Nano, fields.Retrieved)
+	if err != nil {
+		return fmt.Errorf("parsing retrieval timestamp: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+package bitypes
+
+// Secrets summarizes what the secret scan found in an image, so clients can
+// flag it without fetching every finding.
+type Secrets struct {
+	// Files is how many files have findings, and Findings how many there
+	// are across them
+	Files    int
+	Findings int
+	// Rules counts findings by the ID of the rule that found them
+	Rules map[string]int `json:",omitempty"`
+	// Scanned and Skipped count the candidate files that were read, and
+	// those that weren't, being binary or beyond the scan's limits
+	Scanned   int
+	Skipped   int
+	Truncated bool `json:",omitempty"`
+}
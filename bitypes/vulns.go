@@ -0,0 +1,18 @@
+package bitypes
+
+import "time"
+
+// Vulns summarizes the advisories matched to an image's packages, so clients
+// can flag it without fetching every match.
+type Vulns struct {
+	// Generated is when the packages were matched, against advisories that
+	// were current then
+	Generated time.Time
+	// Checked is how many packages were matched against advisories, and
+	// Unsupported how many are of ecosystems there are no advisories for
+	Checked     int
+	Unsupported int
+	Matches     int
+	// Severities counts matches by severity level
+	Severities map[string]int `json:",omitempty"`
+}
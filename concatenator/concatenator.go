@@ -4,25 +4,22 @@ import (
 	"browseimage/bitypes"
 	"browseimage/layerreader"
 	"browseimage/logging"
+	"browseimage/storage"
 	"browseimage/targzi"
-	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"sort"
-	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/klauspost/compress/gzip"
 )
 
 func main() {
@@ -35,12 +32,10 @@ func main() {
 		panic(fmt.Sprintf("%+v", err))
 	}
 
-	api := s3.NewFromConfig(cfg)
-
 	c := &concatenator{
-		uploader:   manager.NewUploader(api),
-		downloader: manager.NewDownloader(api),
-		bucket:     os.Getenv("BUCKET"),
+		storage:  storage.NewS3(s3.NewFromConfig(cfg), os.Getenv("BUCKET")),
+		dynamodb: dynamodb.NewFromConfig(cfg),
+		table:    os.Getenv("TABLE"),
 	}
 	lambda.Start(logging.Middleware(c.handle))
 }
@@ -56,110 +51,76 @@ type concatenatorOutput struct {
 }
 
 type concatenator struct {
-	uploader   *manager.Uploader
-	downloader *manager.Downloader
-	bucket     string
-}
-
-type entryWithLayer struct {
-	targzi.Entry
-	Layer string
+	storage  storage.Storage
+	dynamodb *dynamodb.Client
+	table    string
 }
 
 func (ll *concatenator) handle(ctx context.Context, input *concatenatorInput) (*concatenatorOutput, error) {
 	ctx = logging.WithRequestPayload(ctx, input)
 	slog.InfoContext(ctx, "handling concatenator request")
 
-	fileMap := map[string]*entryWithLayer{}
+	merger := layerreader.NewMerger()
 
 	for _, hash := range input.Layers {
-		w := manager.NewWriteAtBuffer(nil)
-		_, err := ll.downloader.Download(ctx, w, &s3.GetObjectInput{
-			Bucket: &ll.bucket,
-			Key:    aws.String(fmt.Sprintf("layers/%s/files.json.gz", hash)),
-		})
+		body, err := ll.storage.Get(ctx, storage.FileIndexKey(hash.String()))
 		if err != nil {
 			return nil, fmt.Errorf("downloading layer files index: %w", err)
 		}
 
-		gzr, err := gzip.NewReader(bytes.NewReader(w.Bytes()))
+		err = targzi.ReadFileIndex(body, func(e *targzi.Entry) error {
+			merger.Add(ctx, hash.String(), *e)
+			return nil
+		})
+		body.Close()
 		if err != nil {
-			return nil, fmt.Errorf("creating gzip reader: %w", err)
-		}
-
-		scan := bufio.NewScanner(gzr)
-		for scan.Scan() {
-			e := targzi.Entry{}
-			err = json.Unmarshal(scan.Bytes(), &e)
-			if err != nil {
-				return nil, fmt.Errorf("unmarshalling entry: %w", err)
-			}
-
-			base := filepath.Base(e.Hdr.Name)
-			if base == layerreader.WhiteoutOpaqueDir {
-				deletes := []string{}
-				deletedDir := strings.TrimSuffix(e.Hdr.Name, layerreader.WhiteoutOpaqueDir)
-				slog.DebugContext(ctx, "processing opaque whiteout directory", "dir", deletedDir)
-				for key, entry := range fileMap {
-					// Only delete entries from previous layers, not from the current layer
-					if strings.HasPrefix(key, deletedDir) && entry.Layer != hash.String() {
-						deletes = append(deletes, key)
-					}
-				}
-				for _, del := range deletes {
-					delete(fileMap, del)
-					slog.DebugContext(ctx, "deleting recursively", "path", del)
-				}
-			} else if strings.HasPrefix(base, layerreader.WhiteoutPrefix) {
-				name := strings.TrimPrefix(base, layerreader.WhiteoutPrefix)
-				dir := filepath.Dir(e.Hdr.Name)
-				fullPath := filepath.Join(dir, name)
-				// Only delete if it's from a previous layer
-				if existing, ok := fileMap[fullPath]; ok && existing.Layer != hash.String() {
-					delete(fileMap, fullPath)
-					slog.DebugContext(ctx, "deleting whiteout file", "path", fullPath)
-				}
-			} else {
-				fileMap[e.Hdr.Name] = &entryWithLayer{Entry: e, Layer: hash.String()}
-			}
+			return nil, fmt.Errorf("reading layer files index: %w", err)
 		}
 	}
 
-	arr := make([]*entryWithLayer, 0, len(fileMap))
-	for _, e := range fileMap {
-		e := e
-		arr = append(arr, e)
+	// the history goes first, so any image with an index has one
+	buf := &bytes.Buffer{}
+	err := merger.WriteHistory(buf)
+	if err != nil {
+		return nil, fmt.Errorf("writing history index: %w", err)
 	}
 
-	sort.Slice(arr, func(i, j int) bool {
-		return arr[i].Hdr.Name < arr[j].Hdr.Name
-	})
+	_, err = ll.storage.Put(ctx, storage.HistoryIndexKey(input.Key.Repo, input.Key.Digest), buf)
+	if err != nil {
+		return nil, fmt.Errorf("uploading history index: %w", err)
+	}
 
-	buf := &bytes.Buffer{}
-	gzw := gzip.NewWriter(buf)
+	buf = &bytes.Buffer{}
+	err = merger.Write(buf)
+	if err != nil {
+		return nil, fmt.Errorf("writing combined index: %w", err)
+	}
 
-	for _, e := range arr {
-		j, _ := json.Marshal(e)
-		gzw.Write(j)
-		gzw.Write([]byte{'\n'})
+	key := storage.ImageIndexKey(input.Key.Repo, input.Key.Digest)
+	versionId, err := ll.storage.Put(ctx, key, buf)
+	if err != nil {
+		return nil, fmt.Errorf("uploading combined index: %w", err)
 	}
 
-	err := gzw.Close()
+	efficiency, err := attributevalue.Marshal(merger.Efficiency())
 	if err != nil {
-		return nil, fmt.Errorf("closing gzip writer: %w", err)
+		return nil, fmt.Errorf("marshalling efficiency: %w", err)
 	}
 
-	upload, err := ll.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: &ll.bucket,
-		Key:    aws.String(fmt.Sprintf("images/%s/%s/index.json.gz", input.Key.Repo, input.Key.Digest)),
-		Body:   buf,
+	_, err = ll.dynamodb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &ll.table,
+		Key:              input.Key.Key(),
+		UpdateExpression: aws.String("SET Efficiency = :Efficiency"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":Efficiency": efficiency,
+		},
 	})
 	if err != nil {
-		return nil, fmt.Errorf("uploading combined index: %w", err)
+		return nil, fmt.Errorf("updating image efficiency in dynamo: %w", err)
 	}
 
 	return &concatenatorOutput{
-		Key:       *upload.Key,
-		VersionId: *upload.VersionID,
+		Key:       key,
+		VersionId: versionId,
 	}, nil
 }
@@ -0,0 +1,320 @@
+// Package extract reads files of an indexed image straight from its layer
+// blobs in the registry, fetching only the compressed spans, uncompressed
+// bytes or eStargz chunks that cover them.
+package extract
+
+import (
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// DownloadGzIndex fetches a layer's gzip index to a temporary file, which the
+// caller should remove.
+func DownloadGzIndex(ctx context.Context, s storage.Storage, layer string) (string, error) {
+	body, err := s.Get(ctx, storage.GzIndexKey(layer))
+	if err != nil {
+		return "", fmt.Errorf("getting gzip index: %w", err)
+	}
+	defer body.Close()
+
+	indexFile, err := os.CreateTemp("", "gzi*")
+	if err != nil {
+		return "", fmt.Errorf("creating gzip index file: %w", err)
+	}
+
+	_, err = io.Copy(indexFile, body)
+	if err == nil {
+		err = indexFile.Close()
+	}
+	if err != nil {
+		indexFile.Close()
+		os.Remove(indexFile.Name())
+		return "", fmt.Errorf("downloading gzip index: %w", err)
+	}
+
+	return indexFile.Name(), nil
+}
+
+// Indexes downloads the gzip index of each layer at most once, for reading
+// many files.
+type Indexes struct {
+	Storage storage.Storage
+
+	mu      sync.Mutex
+	indexes map[string]*layerIndex
+}
+
+type layerIndex struct {
+	once  sync.Once
+	path  string
+	spans []targzi.IndexSpan
+	err   error
+}
+
+func (li *Indexes) get(ctx context.Context, layer string) (*layerIndex, error) {
+	li.mu.Lock()
+	if li.indexes == nil {
+		li.indexes = map[string]*layerIndex{}
+	}
+	index := li.indexes[layer]
+	if index == nil {
+		index = &layerIndex{}
+		li.indexes[layer] = index
+	}
+	li.mu.Unlock()
+
+	index.once.Do(func() {
+		index.path, index.err = DownloadGzIndex(ctx, li.Storage, layer)
+		if index.err != nil {
+			return
+		}
+
+		index.spans, index.err = targzi.Spans(index.path)
+		if index.err != nil {
+			index.err = fmt.Errorf("reading spans: %w", index.err)
+		}
+	})
+
+	return index, index.err
+}
+
+// Close removes the downloaded indexes.
+func (li *Indexes) Close() {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+
+	for _, index := range li.indexes {
+		if index.path != "" {
+			os.Remove(index.path)
+		}
+	}
+}
+
+// File fetches and decompresses byte ranges of a single file from its layer
+// blob in the registry.
+type File struct {
+	ctx       context.Context
+	client    *auth.Client
+	blobURL   string
+	indexPath string
+	spans     []targzi.IndexSpan
+	entry     layerreader.EntryWithLayer
+}
+
+// NewFile prepares to read entry from its layer blob in repo. ctx must carry
+// the pull scope for repo.
+func NewFile(ctx context.Context, client *auth.Client, repo name.Repository, entry layerreader.EntryWithLayer, indexes *Indexes) (*File, error) {
+	f := &File{
+		ctx:     ctx,
+		client:  client,
+		blobURL: fmt.Sprintf("https://%s/v2/%s/blobs/%s", repo.RegistryStr(), repo.RepositoryStr(), entry.Layer),
+		entry:   entry,
+	}
+
+	// uncompressed and eStargz layers are read straight from the blob,
+	// without an index
+	if !entry.Uncompressed && len(entry.Chunks) == 0 && entry.Hdr.Size > 0 {
+		if len(entry.Spans) == 0 {
+			return nil, fmt.Errorf("entry has no spans")
+		}
+
+		index, err := indexes.get(ctx, entry.Layer)
+		if err != nil {
+			return nil, err
+		}
+		f.indexPath = index.path
+		f.spans = index.spans
+	}
+
+	return f, nil
+}
+
+// Reader returns a function that reads ranges of any file in repo, for
+// callers that read many.
+func Reader(client *auth.Client, repo name.Repository, indexes *Indexes) layerreader.FileReader {
+	return func(ctx context.Context, e *layerreader.EntryWithLayer, offset, length int64) (io.ReadCloser, error) {
+		f, err := NewFile(ctx, client, repo, *e, indexes)
+		if err != nil {
+			return nil, err
+		}
+		return f.Open(offset, length)
+	}
+}
+
+// Extraction streams one range of a file, and closing it also closes the
+// registry response it is reading from.
+type Extraction struct {
+	io.Reader
+	extracted *targzi.Extraction // nil for uncompressed layers
+	body      io.Closer
+}
+
+func (e *Extraction) Close() error {
+	if e.extracted != nil {
+		e.extracted.Close()
+	}
+	return e.body.Close()
+}
+
+// Open reads length bytes of the file from start.
+func (f *File) Open(start, length int64) (*Extraction, error) {
+	switch {
+	case length == 0:
+		return &Extraction{Reader: strings.NewReader(""), body: io.NopCloser(nil)}, nil
+	case f.entry.Uncompressed:
+		return f.openUncompressed(start, length)
+	case len(f.entry.Chunks) > 0:
+		return f.openChunks(start, length)
+	}
+
+	offset := f.entry.Offset + int(start)
+	from, to := targzi.SpanRange(f.spans, offset, int(length))
+
+	rangeHdr := fmt.Sprintf("bytes=%d-", from-1)
+	if to > 0 {
+		rangeHdr += fmt.Sprintf("%d", to)
+	}
+
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.blobURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.Header.Set("Range", rangeHdr)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching layer blob: %w", err)
+	}
+
+	err = skipTo(resp, int64(from-1))
+	if err != nil {
+		return nil, err
+	}
+
+	extracted, err := targzi.Extract(f.ctx, resp.Body, f.indexPath, from, offset, int(length))
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("extracting: %w", err)
+	}
+
+	return &Extraction{Reader: extracted, extracted: extracted, body: resp.Body}, nil
+}
+
+// skipTo checks the response to a request for a range starting at offset,
+// and skips to offset itself if the registry sent the whole blob. The body is
+// closed on error.
+func skipTo(resp *http.Response, offset int64) error {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return nil
+	case http.StatusOK:
+		// the registry ignored the range, so skip to it ourselves
+		_, err := io.CopyN(io.Discard, resp.Body, offset)
+		if err != nil {
+			resp.Body.Close()
+			return fmt.Errorf("seeking to range: %w", err)
+		}
+		return nil
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("fetching layer blob: unexpected status %d", resp.StatusCode)
+	}
+}
+
+// openUncompressed fetches exactly the requested bytes of a file in an
+// uncompressed layer, where tar offsets are also blob offsets.
+func (f *File) openUncompressed(start, length int64) (*Extraction, error) {
+	offset := int64(f.entry.Offset) + start
+
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.blobURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching layer blob: %w", err)
+	}
+
+	err = skipTo(resp, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &exactReader{r: io.LimitReader(resp.Body, length), remaining: length}
+	return &Extraction{Reader: body, body: resp.Body}, nil
+}
+
+// openChunks fetches and decompresses the eStargz chunks covering the
+// requested bytes of a file.
+func (f *File) openChunks(start, length int64) (*Extraction, error) {
+	chunks := targzi.ChunkRange(f.entry.Chunks, start, length)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks for range %d+%d", start, length)
+	}
+	last := chunks[len(chunks)-1]
+
+	req, err := http.NewRequestWithContext(f.ctx, "GET", f.blobURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", chunks[0].Compressed, last.Compressed+last.CompressedSize-1))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching layer blob: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching layer blob: unexpected status %d", resp.StatusCode)
+	}
+
+	r, err := targzi.OpenChunks(resp.Body, chunks, start, length)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("extracting: %w", err)
+	}
+
+	return &Extraction{Reader: r, body: multiCloser{r, resp.Body}}, nil
+}
+
+// multiCloser closes each of its closers in order.
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var errs []error
+	for _, c := range m {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// exactReader turns a short read into io.ErrUnexpectedEOF, the same way
+// targzi.Extraction does for compressed layers.
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.remaining -= int64(n)
+	if err == io.EOF && e.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
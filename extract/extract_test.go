@@ -0,0 +1,30 @@
+package extract
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSkipTo(t *testing.T) {
+	resp := func(status int) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("0123456789"))}
+	}
+
+	r := resp(http.StatusPartialContent)
+	require.NoError(t, skipTo(r, 4))
+	b, _ := io.ReadAll(r.Body)
+	require.Equal(t, "0123456789", string(b))
+
+	r = resp(http.StatusOK)
+	require.NoError(t, skipTo(r, 4))
+	b, _ = io.ReadAll(r.Body)
+	require.Equal(t, "456789", string(b))
+
+	require.Error(t, skipTo(resp(http.StatusOK), 20))
+	require.Error(t, skipTo(resp(http.StatusNotFound), 0))
+	require.Error(t, skipTo(resp(http.StatusUnauthorized), 0))
+}
@@ -40,6 +40,37 @@ type inputPayload struct {
 }
 
 func (h *handler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	r, err := newRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	h.handler.ServeHTTP(w, r)
+
+	res := w.Result()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+
+	resHeaders := map[string]string{}
+	for key, vals := range res.Header {
+		resHeaders[key] = vals[0]
+	}
+
+	b64 := base64.StdEncoding.EncodeToString(resBody)
+	output := events.APIGatewayV2HTTPResponse{
+		StatusCode:      res.StatusCode,
+		Headers:         resHeaders,
+		Body:            b64,
+		IsBase64Encoded: true,
+	}
+
+	return json.Marshal(output)
+}
+
+func newRequest(ctx context.Context, payload []byte) (*http.Request, error) {
 	if lc, ok := lambdacontext.FromContext(ctx); ok {
 		ctx = slogctx.Prepend(ctx, "requestId", lc.AwsRequestID)
 	}
@@ -68,28 +99,6 @@ func (h *handler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
 	u := fmt.Sprintf("https://%s%s?%s", headers.Get("Host"), input.RawPath, input.RawQueryString)
 
 	r := httptest.NewRequest(input.RequestContext.HTTP.Method, u, body)
-	r = r.WithContext(context.WithValue(ctx, requestContextKey, &input.RequestContext))
-	w := httptest.NewRecorder()
-	h.handler.ServeHTTP(w, r)
-
-	res := w.Result()
-	resBody, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response body: %w", err)
-	}
-
-	resHeaders := map[string]string{}
-	for key, vals := range res.Header {
-		resHeaders[key] = vals[0]
-	}
-
-	b64 := base64.StdEncoding.EncodeToString(resBody)
-	output := events.APIGatewayV2HTTPResponse{
-		StatusCode:      res.StatusCode,
-		Headers:         resHeaders,
-		Body:            b64,
-		IsBase64Encoded: true,
-	}
-
-	return json.Marshal(output)
+	r.Header = headers
+	return r.WithContext(context.WithValue(ctx, requestContextKey, &input.RequestContext)), nil
 }
@@ -0,0 +1,94 @@
+package handlehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// WrapStreamingHandler adapts h to a Lambda function URL configured with
+// InvokeMode RESPONSE_STREAM. Unlike WrapHandler, the response body is not
+// buffered: status and headers are sent as soon as h writes them and the body
+// is piped through as it is written.
+func WrapStreamingHandler(h http.Handler) func(context.Context, json.RawMessage) (*events.LambdaFunctionURLStreamingResponse, error) {
+	return func(ctx context.Context, payload json.RawMessage) (*events.LambdaFunctionURLStreamingResponse, error) {
+		r, err := newRequest(ctx, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		pr, pw := io.Pipe()
+		w := &streamingResponseWriter{
+			header: http.Header{},
+			pw:     pw,
+			ready:  make(chan struct{}),
+		}
+		panicked := make(chan any, 1)
+
+		go func() {
+			defer func() {
+				if rerr := recover(); rerr != nil {
+					slog.ErrorContext(ctx, "streaming handler panicked", "error", rerr)
+					pw.CloseWithError(fmt.Errorf("handler panicked: %v", rerr))
+					panicked <- rerr
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+				pw.Close()
+			}()
+
+			h.ServeHTTP(w, r)
+		}()
+
+		select {
+		case <-w.ready:
+		case rerr := <-panicked:
+			return nil, fmt.Errorf("handler panicked: %v", rerr)
+		}
+
+		return &events.LambdaFunctionURLStreamingResponse{
+			StatusCode: w.status,
+			Headers:    w.sentHeaders,
+			Body:       pr,
+		}, nil
+	}
+}
+
+type streamingResponseWriter struct {
+	header      http.Header
+	sentHeaders map[string]string
+	status      int
+	pw          *io.PipeWriter
+	ready       chan struct{}
+	once        sync.Once
+}
+
+func (s *streamingResponseWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *streamingResponseWriter) WriteHeader(statusCode int) {
+	s.once.Do(func() {
+		s.status = statusCode
+		s.sentHeaders = map[string]string{}
+		for key, vals := range s.header {
+			s.sentHeaders[key] = vals[0]
+		}
+		close(s.ready)
+	})
+}
+
+func (s *streamingResponseWriter) Write(p []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return s.pw.Write(p)
+}
+
+// Flush is a no-op: every Write is handed to the Lambda runtime immediately.
+func (s *streamingResponseWriter) Flush() {}
@@ -0,0 +1,109 @@
+package layerreader
+
+import (
+	"sort"
+	"strings"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Change is a path that differs between two images.
+type Change struct {
+	Path   string
+	Kind   ChangeKind
+	Fields []string        `json:",omitempty"` // that differ, for modified paths
+	From   *EntryWithLayer `json:",omitempty"`
+	To     *EntryWithLayer `json:",omitempty"`
+}
+
+// SortByName orders entries by name, which is the order Diff needs.
+func SortByName(entries []*EntryWithLayer) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Hdr.Name < entries[j].Hdr.Name
+	})
+}
+
+// Diff calls fn for every path that was added, removed or modified between
+// two images, in order of path. Both sets of entries must be sorted by name.
+func Diff(from, to []*EntryWithLayer, fn func(c *Change) error) error {
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		var c *Change
+		switch {
+		case j == len(to) || (i < len(from) && from[i].Hdr.Name < to[j].Hdr.Name):
+			c = &Change{Path: from[i].Hdr.Name, Kind: ChangeRemoved, From: from[i]}
+			i++
+		case i == len(from) || to[j].Hdr.Name < from[i].Hdr.Name:
+			c = &Change{Path: to[j].Hdr.Name, Kind: ChangeAdded, To: to[j]}
+			j++
+		default:
+			fields := ChangedFields(from[i], to[j])
+			if len(fields) > 0 {
+				c = &Change{Path: to[j].Hdr.Name, Kind: ChangeModified, Fields: fields, From: from[i], To: to[j]}
+			}
+			i++
+			j++
+		}
+
+		if c == nil {
+			continue
+		}
+
+		err := fn(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ChangedFields lists what differs between two entries for the same path.
+func ChangedFields(from, to *EntryWithLayer) []string {
+	a, b := &from.Hdr, &to.Hdr
+
+	fields := []string{}
+	if a.Typeflag != b.Typeflag {
+		fields = append(fields, "type")
+	}
+	if a.Size != b.Size {
+		fields = append(fields, "size")
+	}
+	if a.Mode != b.Mode {
+		fields = append(fields, "mode")
+	}
+	if !a.ModTime.Equal(b.ModTime) {
+		fields = append(fields, "mtime")
+	}
+	if a.Uid != b.Uid || a.Gid != b.Gid || a.Uname != b.Uname || a.Gname != b.Gname {
+		fields = append(fields, "owner")
+	}
+	if a.Linkname != b.Linkname {
+		fields = append(fields, "linkname")
+	}
+	// indexes from before digests were recorded have none
+	if from.Digest != "" && to.Digest != "" && from.Digest != to.Digest {
+		fields = append(fields, "content")
+	}
+
+	return fields
+}
+
+// HasPathPrefix reports whether name is within prefix, which is either a
+// directory or a path to match exactly.
+func HasPathPrefix(name, prefix string) bool {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+
+	name = strings.TrimPrefix(name, "/")
+	dir := strings.TrimSuffix(prefix, "/")
+	return strings.TrimSuffix(name, "/") == dir || strings.HasPrefix(name, dir+"/")
+}
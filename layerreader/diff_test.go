@@ -0,0 +1,92 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/targzi"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func entry(name string, size int64, modify func(hdr *tar.Header)) *EntryWithLayer {
+	e := &EntryWithLayer{Entry: targzi.Entry{Hdr: tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Unix(1700000000, 0),
+	}}}
+	if modify != nil {
+		modify(&e.Hdr)
+	}
+	return e
+}
+
+func TestDiff(t *testing.T) {
+	from := []*EntryWithLayer{
+		entry("bin/sh", 10, nil),
+		entry("etc/group", 5, nil),
+		entry("etc/hosts", 20, nil),
+		entry("etc/passwd", 30, nil),
+		entry("usr/lib/libc.so", 40, nil),
+		entry("var/log/old", 50, nil),
+	}
+	to := []*EntryWithLayer{
+		entry("bin/bash", 15, nil),
+		entry("bin/sh", 10, func(hdr *tar.Header) {
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Size = 0
+			hdr.Linkname = "bash"
+		}),
+		entry("etc/group", 5, nil),
+		entry("etc/hosts", 20, nil),
+		entry("etc/passwd", 30, func(hdr *tar.Header) {
+			hdr.Mode = 0o600
+			hdr.Uid = 1000
+			hdr.ModTime = hdr.ModTime.Add(time.Hour)
+		}),
+		entry("usr/lib/libc.so", 41, nil),
+	}
+	from[2].Digest = "sha256:aaaa" // etc/hosts
+	to[3].Digest = "sha256:bbbb"
+	// only one side has a digest, as if indexed before they were recorded
+	from[1].Digest = "sha256:cccc" // etc/group
+	SortByName(from)
+	SortByName(to)
+
+	changes := []*Change{}
+	err := Diff(from, to, func(c *Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	require.NoError(t, err)
+
+	summary := map[string][]string{}
+	order := []string{}
+	for _, c := range changes {
+		order = append(order, c.Path)
+		summary[c.Path] = append([]string{string(c.Kind)}, c.Fields...)
+	}
+
+	require.Equal(t, []string{"bin/bash", "bin/sh", "etc/hosts", "etc/passwd", "usr/lib/libc.so", "var/log/old"}, order)
+	require.Equal(t, map[string][]string{
+		"bin/bash":        {"added"},
+		"bin/sh":          {"modified", "type", "size", "linkname"},
+		"etc/hosts":       {"modified", "content"},
+		"etc/passwd":      {"modified", "mode", "mtime", "owner"},
+		"usr/lib/libc.so": {"modified", "size"},
+		"var/log/old":     {"removed"},
+	}, summary)
+}
+
+func TestHasPathPrefix(t *testing.T) {
+	require.True(t, HasPathPrefix("etc/passwd", ""))
+	require.True(t, HasPathPrefix("etc/passwd", "/"))
+	require.True(t, HasPathPrefix("etc/passwd", "etc"))
+	require.True(t, HasPathPrefix("etc/passwd", "/etc/"))
+	require.True(t, HasPathPrefix("etc/", "etc"))
+	require.True(t, HasPathPrefix("etc/passwd", "etc/passwd"))
+	require.False(t, HasPathPrefix("etcetera/passwd", "etc"))
+	require.False(t, HasPathPrefix("etc", "etc/passwd"))
+}
@@ -0,0 +1,110 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/bitypes"
+	"browseimage/targzi"
+	"sort"
+)
+
+// maxEfficiencyPaths limits the paths and duplicates in an Efficiency to the
+// worst offenders.
+const maxEfficiencyPaths = 20
+
+// ship counts a file a layer added to the image.
+func (m *Merger) ship(layer string, e targzi.Entry) {
+	if layer != m.lastLayer {
+		_, m.repeated = m.layerBytes[layer]
+		m.lastLayer = layer
+		if !m.repeated {
+			m.layerBytes[layer] = 0
+		}
+	}
+
+	if e.Hdr.Typeflag != tar.TypeReg {
+		return
+	}
+	m.totalBytes += e.Hdr.Size
+
+	// a repeated layer has the same files every time
+	if !m.repeated {
+		m.layerBytes[layer] += e.Hdr.Size
+	}
+
+	if e.Digest != "" && e.Hdr.Size > 0 {
+		m.copies[e.Digest] = append(m.copies[e.Digest], bitypes.FileCopy{Layer: layer, Path: e.Hdr.Name})
+		m.sizes[e.Digest] = e.Hdr.Size
+	}
+}
+
+// waste counts a file a later layer overwrote or deleted.
+func (m *Merger) waste(e *EntryWithLayer) {
+	if e.Hdr.Typeflag != tar.TypeReg {
+		return
+	}
+	m.wasted[e.Hdr.Name] += e.Hdr.Size
+}
+
+// Efficiency measures the space wasted by the layers added so far.
+func (m *Merger) Efficiency() *bitypes.Efficiency {
+	eff := &bitypes.Efficiency{
+		TotalBytes: m.totalBytes,
+		TopWasted:  []bitypes.WastedPath{},
+		Duplicates: []bitypes.DuplicateFile{},
+		LayerBytes: m.layerBytes,
+	}
+
+	for name, wasted := range m.wasted {
+		eff.WastedBytes += wasted
+		if wasted == 0 {
+			continue
+		}
+
+		count := 0
+		for _, step := range m.history[name] {
+			if step.Action == HistoryCreated || step.Action == HistoryOverwritten {
+				count++
+			}
+		}
+		eff.TopWasted = append(eff.TopWasted, bitypes.WastedPath{Path: name, Count: count, WastedBytes: wasted})
+	}
+
+	for digest, copies := range m.copies {
+		layers := map[string]bool{}
+		for _, c := range copies {
+			layers[c.Layer] = true
+		}
+		if len(layers) < 2 {
+			continue
+		}
+
+		size := m.sizes[digest]
+		eff.Duplicates = append(eff.Duplicates, bitypes.DuplicateFile{
+			Digest:      digest,
+			Size:        size,
+			Count:       len(copies),
+			Copies:      copies[:min(len(copies), maxEfficiencyPaths)],
+			WastedBytes: size * int64(len(layers)-1),
+		})
+	}
+
+	eff.Score = 1
+	if eff.TotalBytes > 0 {
+		eff.Score = 1 - float64(eff.WastedBytes)/float64(eff.TotalBytes)
+	}
+
+	// ties are broken by name, so the same image always gets the same stats
+	sort.Slice(eff.TopWasted, func(i, j int) bool {
+		a, b := eff.TopWasted[i], eff.TopWasted[j]
+		return a.WastedBytes > b.WastedBytes || a.WastedBytes == b.WastedBytes && a.Path < b.Path
+	})
+	eff.TopWasted = eff.TopWasted[:min(len(eff.TopWasted), maxEfficiencyPaths)]
+
+	sort.Slice(eff.Duplicates, func(i, j int) bool {
+		a, b := eff.Duplicates[i], eff.Duplicates[j]
+		return a.WastedBytes > b.WastedBytes || a.WastedBytes == b.WastedBytes && a.Digest < b.Digest
+	})
+	eff.Duplicates = eff.Duplicates[:min(len(eff.Duplicates), maxEfficiencyPaths)]
+
+	return eff
+}
@@ -0,0 +1,93 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/bitypes"
+	"browseimage/targzi"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEfficiency(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string, size int64, digest string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: size}, Parent: targzi.Parent(name), Digest: digest}
+	}
+
+	m := NewMerger()
+	m.Add(ctx, "sha256:1", targzi.Entry{Hdr: tar.Header{Name: "var/cache/", Typeflag: tar.TypeDir}})
+	m.Add(ctx, "sha256:1", e("var/cache/big", 1000, "sha256:big"))
+	m.Add(ctx, "sha256:1", e("etc/config", 100, "sha256:config1"))
+	m.Add(ctx, "sha256:1", e("usr/bin/tool", 50, "sha256:tool"))
+	m.Add(ctx, "sha256:2", e("etc/config", 120, "sha256:config2"))
+	m.Add(ctx, "sha256:2", e("opt/tool", 50, "sha256:tool"))
+	m.Add(ctx, "sha256:2", targzi.Entry{Hdr: tar.Header{Name: "tmp/build/", Typeflag: tar.TypeDir}})
+	m.Add(ctx, "sha256:2", e("tmp/build/big", 400, "sha256:build"))
+	m.Add(ctx, "sha256:3", e("var/cache/.wh.big", 0, ""))
+	m.Add(ctx, "sha256:3", e("etc/config", 130, "sha256:config3"))
+	m.Add(ctx, "sha256:3", e("tmp/.wh.build", 0, ""))
+
+	eff := m.Efficiency()
+	require.EqualValues(t, 1000+100+50+120+50+400+130, eff.TotalBytes)
+	require.EqualValues(t, 1000+400+100+120, eff.WastedBytes)
+	require.InDelta(t, 1-1620.0/1850.0, eff.Score, 1e-9)
+	require.Equal(t, []bitypes.WastedPath{
+		{Path: "var/cache/big", Count: 1, WastedBytes: 1000},
+		{Path: "tmp/build/big", Count: 1, WastedBytes: 400},
+		{Path: "etc/config", Count: 3, WastedBytes: 220},
+	}, eff.TopWasted)
+	require.Equal(t, []bitypes.DuplicateFile{{
+		Digest:      "sha256:tool",
+		Size:        50,
+		Count:       2,
+		Copies:      []bitypes.FileCopy{{Layer: "sha256:1", Path: "usr/bin/tool"}, {Layer: "sha256:2", Path: "opt/tool"}},
+		WastedBytes: 50,
+	}}, eff.Duplicates)
+
+	for _, entry := range m.Entries() {
+		require.NotContains(t, entry.Hdr.Name, "tmp/build")
+	}
+
+	require.Equal(t, 1.0, NewMerger().Efficiency().Score)
+}
+
+func TestLayerBytes(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string, size int64) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: size}, Parent: targzi.Parent(name)}
+	}
+
+	m := NewMerger()
+	m.Add(ctx, "sha256:1", targzi.Entry{Hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir}})
+	m.Add(ctx, "sha256:1", e("etc/config", 100))
+	m.Add(ctx, "sha256:1", e("usr/bin/tool", 50))
+	m.Add(ctx, "sha256:2", e("etc/config", 120))
+	m.Add(ctx, "sha256:3", targzi.Entry{Hdr: tar.Header{Name: "tmp/", Typeflag: tar.TypeDir}})
+	// the same layer again, whose files were already counted
+	m.Add(ctx, "sha256:2", e("etc/config", 120))
+
+	require.Equal(t, map[string]int64{"sha256:1": 150, "sha256:2": 120, "sha256:3": 0}, m.Efficiency().LayerBytes)
+}
+
+func TestDuplicatesInOneLayer(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 50}, Parent: targzi.Parent(name), Digest: "sha256:tool"}
+	}
+
+	m := NewMerger()
+	m.Add(ctx, "sha256:1", e("usr/bin/tool"))
+	m.Add(ctx, "sha256:1", e("usr/local/bin/tool"))
+	require.Empty(t, m.Efficiency().Duplicates)
+
+	m.Add(ctx, "sha256:2", e("opt/tool"))
+	dups := m.Efficiency().Duplicates
+	require.Len(t, dups, 1)
+	require.Equal(t, 3, dups[0].Count)
+	require.EqualValues(t, 50, dups[0].WastedBytes)
+}
@@ -0,0 +1,97 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/sortedindex"
+	"browseimage/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// HistoryAction is what a layer did to a path.
+type HistoryAction string
+
+const (
+	HistoryCreated     HistoryAction = "created"
+	HistoryOverwritten HistoryAction = "overwritten"
+	// HistoryWhiteout is a whiteout of the path itself
+	HistoryWhiteout HistoryAction = "whiteout"
+	// HistoryOpaque is an opaque whiteout of a directory the path is in
+	HistoryOpaque HistoryAction = "opaque"
+)
+
+// HistoryStep is one layer touching a path. Hdr is the path's new header, or
+// for deletions the header of the whiteout.
+type HistoryStep struct {
+	Layer  string
+	Action HistoryAction
+	Hdr    tar.Header
+}
+
+// PathHistory is every step of a path, from the bottom of the image up.
+type PathHistory struct {
+	Name  string
+	Steps []HistoryStep
+}
+
+func (m *Merger) record(name, layer string, action HistoryAction, hdr tar.Header) {
+	m.history[name] = append(m.history[name], HistoryStep{Layer: layer, Action: action, Hdr: hdr})
+}
+
+// WriteHistory writes the history of every path any layer touched, which is
+// a sortedindex of PathHistory keyed by name.
+func (m *Merger) WriteHistory(w io.Writer) error {
+	names := make([]string, 0, len(m.history))
+	for name := range m.history {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sw := sortedindex.NewWriter(w)
+	for _, name := range names {
+		j, _ := json.Marshal(PathHistory{Name: name, Steps: m.history[name]})
+		err := sw.Add(name, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// LookupHistory returns the history of name, with or without the trailing
+// slash of a directory, from the history index at key. It returns nil if no
+// layer touched name.
+func LookupHistory(ctx context.Context, s storage.Storage, key, name string) (*PathHistory, error) {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "/"), "/")
+
+	index, err := sortedindex.Open(ctx, func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return s.GetRange(ctx, key, offset, length)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening history index: %w", err)
+	}
+
+	var found *PathHistory
+	err = index.Scan(ctx, name, name+"/\x00", func(record []byte) error {
+		h := &PathHistory{}
+		err := json.Unmarshal(record, h)
+		if err != nil {
+			return fmt.Errorf("unmarshalling history: %w", err)
+		}
+
+		if strings.TrimSuffix(h.Name, "/") == name {
+			found = h
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
@@ -0,0 +1,76 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string, typeflag byte) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: typeflag}, Parent: targzi.Parent(name)}
+	}
+
+	m := NewMerger()
+	for _, layer := range []struct {
+		digest  string
+		entries []targzi.Entry
+	}{
+		{"sha256:1", []targzi.Entry{e("etc/", tar.TypeDir), e("etc/passwd", tar.TypeReg), e("var/cache/apt/pkgcache.bin", tar.TypeReg)}},
+		{"sha256:2", []targzi.Entry{e("etc/passwd", tar.TypeReg), e("var/cache/apt/.wh..wh..opq", tar.TypeReg), e("tmp/build/", tar.TypeDir), e("tmp/build/out", tar.TypeReg)}},
+		{"sha256:3", []targzi.Entry{e("etc/.wh.passwd", tar.TypeReg), e("tmp/.wh.build", tar.TypeReg)}},
+		{"sha256:4", []targzi.Entry{e("etc/passwd", tar.TypeReg)}},
+	} {
+		for _, entry := range layer.entries {
+			m.Add(ctx, layer.digest, entry)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.WriteHistory(buf))
+	s := storage.NewLocal(t.TempDir())
+	_, err := s.Put(ctx, "history.json.gz", buf)
+	require.NoError(t, err)
+
+	summary := func(name string) []string {
+		h, err := LookupHistory(ctx, s, "history.json.gz", name)
+		require.NoError(t, err)
+		if h == nil {
+			return nil
+		}
+
+		steps := []string{}
+		for _, step := range h.Steps {
+			steps = append(steps, step.Layer+" "+string(step.Action)+" "+step.Hdr.Name)
+		}
+		return steps
+	}
+
+	require.Equal(t, []string{
+		"sha256:1 created etc/passwd",
+		"sha256:2 overwritten etc/passwd",
+		"sha256:3 whiteout etc/.wh.passwd",
+		"sha256:4 created etc/passwd",
+	}, summary("/etc/passwd"))
+	require.Equal(t, []string{
+		"sha256:1 created var/cache/apt/pkgcache.bin",
+		"sha256:2 opaque var/cache/apt/.wh..wh..opq",
+	}, summary("var/cache/apt/pkgcache.bin"))
+	require.Equal(t, []string{"sha256:1 created etc/"}, summary("etc"))
+	require.Equal(t, []string{
+		"sha256:2 created tmp/build/",
+		"sha256:3 whiteout tmp/.wh.build",
+	}, summary("tmp/build"))
+	require.Equal(t, []string{
+		"sha256:2 created tmp/build/out",
+		"sha256:3 whiteout tmp/.wh.build",
+	}, summary("tmp/build/out"))
+	require.Nil(t, summary("etc/shadow"))
+}
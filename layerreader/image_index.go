@@ -0,0 +1,167 @@
+package layerreader
+
+import (
+	"browseimage/sortedindex"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// indexKey orders image index entries so that the children of a directory
+// are adjacent.
+func indexKey(parent, name string) string {
+	return parent + "\x00" + name
+}
+
+func sortByIndexKey(entries []*EntryWithLayer) {
+	sort.Slice(entries, func(i, j int) bool {
+		return indexKey(entries[i].Parent, entries[i].Hdr.Name) < indexKey(entries[j].Parent, entries[j].Hdr.Name)
+	})
+}
+
+// errStopScan ends a ScanImageIndex early.
+var errStopScan = errors.New("stop scan")
+
+// ScanImageIndex calls fn for the entries of an image index in index order,
+// which keeps the children of each directory together, starting after the
+// entry named after, or at the start if after is empty. fn returns false to
+// stop, so a scan only reads as much of the index as it needs.
+func ScanImageIndex(ctx context.Context, s storage.Storage, key, after string, fn func(e *EntryWithLayer) bool) error {
+	from := ""
+	if after != "" {
+		from = indexKey(targzi.Parent(after), after)
+	}
+	visit := func(e *EntryWithLayer) error {
+		if indexKey(e.Parent, e.Hdr.Name) <= from {
+			return nil
+		}
+		if !fn(e) {
+			return errStopScan
+		}
+		return nil
+	}
+
+	index, err := sortedindex.Open(ctx, func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return s.GetRange(ctx, key, offset, length)
+	})
+	if errors.Is(err, sortedindex.ErrNotSorted) {
+		// indexes written before the sorted format are in no order
+		var entries []*EntryWithLayer
+		entries, err = LoadImageIndex(ctx, s, key, "")
+		if err != nil {
+			return err
+		}
+		sortByIndexKey(entries)
+
+		for _, e := range entries {
+			err = visit(e)
+			if err != nil {
+				break
+			}
+		}
+	} else if err != nil {
+		return fmt.Errorf("opening image index: %w", err)
+	} else {
+		err = index.Scan(ctx, from, "\xff", func(record []byte) error {
+			e := &EntryWithLayer{}
+			err := json.Unmarshal(record, e)
+			if err != nil {
+				return fmt.Errorf("unmarshalling entry: %w", err)
+			}
+			return visit(e)
+		})
+	}
+
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
+// ListImageIndex returns the entries of an image index whose parent is dir.
+func ListImageIndex(ctx context.Context, s storage.Storage, key, dir string) ([]EntryWithLayer, error) {
+	return queryImageIndex(ctx, s, key, indexKey(dir, ""), indexKey(dir, "\xff"), func(e *EntryWithLayer) bool {
+		return e.Parent == dir
+	})
+}
+
+// LookupImageIndex returns the entries of an image index named name, of
+// which there should be at most one.
+func LookupImageIndex(ctx context.Context, s storage.Storage, key, name string) ([]EntryWithLayer, error) {
+	from := indexKey(targzi.Parent(name), name)
+	return queryImageIndex(ctx, s, key, from, from+"\x00", func(e *EntryWithLayer) bool {
+		return e.Hdr.Name == name
+	})
+}
+
+func queryImageIndex(ctx context.Context, s storage.Storage, key, from, to string, match func(e *EntryWithLayer) bool) ([]EntryWithLayer, error) {
+	entries := []EntryWithLayer{}
+	collect := func(e *EntryWithLayer) error {
+		if match(e) {
+			entries = append(entries, *e)
+		}
+		return nil
+	}
+
+	index, err := sortedindex.Open(ctx, func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return s.GetRange(ctx, key, offset, length)
+	})
+	if errors.Is(err, sortedindex.ErrNotSorted) {
+		// indexes written before the sorted format have to be read in full
+		body, err := s.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		err = ReadImageIndex(body, collect)
+		if err != nil {
+			return nil, err
+		}
+		return entries, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening image index: %w", err)
+	}
+
+	err = index.Scan(ctx, from, to, func(record []byte) error {
+		e := &EntryWithLayer{}
+		err := json.Unmarshal(record, e)
+		if err != nil {
+			return fmt.Errorf("unmarshalling entry: %w", err)
+		}
+		return collect(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// LoadImageIndex reads the entries of an image index within prefix, which
+// may be empty for all of them.
+func LoadImageIndex(ctx context.Context, s storage.Storage, key, prefix string) ([]*EntryWithLayer, error) {
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	entries := []*EntryWithLayer{}
+	err = ReadImageIndex(body, func(e *EntryWithLayer) error {
+		if HasPathPrefix(e.Hdr.Name, prefix) {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
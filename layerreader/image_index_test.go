@@ -0,0 +1,53 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanImageIndex(t *testing.T) {
+	ctx := context.Background()
+
+	file := func(name string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1}, Parent: targzi.Parent(name)}
+	}
+
+	m := NewMerger()
+	for _, name := range []string{"etc/passwd", "etc/ssl/cert.pem", "etc/hosts", "bin/sh", "etc/ssl/key.pem"} {
+		m.Add(ctx, "sha256:layer", file(name))
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	s := storage.NewLocal(t.TempDir())
+	_, err := s.Put(ctx, "index.json.gz", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	scan := func(after string, limit int) []string {
+		names := []string{}
+		err := ScanImageIndex(ctx, s, "index.json.gz", after, func(e *EntryWithLayer) bool {
+			if e.Hdr.Typeflag != tar.TypeReg {
+				return true
+			}
+			names = append(names, e.Hdr.Name)
+			return len(names) < limit
+		})
+		require.NoError(t, err)
+		return names
+	}
+
+	// the children of each directory are together
+	require.Equal(t, []string{"bin/sh", "etc/hosts", "etc/passwd", "etc/ssl/cert.pem", "etc/ssl/key.pem"}, scan("", 10))
+	require.Equal(t, []string{"bin/sh", "etc/hosts"}, scan("", 2))
+	require.Equal(t, []string{"etc/passwd", "etc/ssl/cert.pem"}, scan("etc/hosts", 2))
+	require.Equal(t, []string{}, scan("etc/ssl/key.pem", 2))
+
+	err = ScanImageIndex(ctx, s, "missing.json.gz", "", func(e *EntryWithLayer) bool { return true })
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
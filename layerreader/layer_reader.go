@@ -6,6 +6,7 @@ import (
 	"browseimage/targzi"
 	"context"
 	"github.com/google/go-containerregistry/pkg/v1"
+	"io"
 	"sort"
 	"strings"
 	"time"
@@ -17,8 +18,18 @@ const WhiteoutOpaqueDir = ".wh..wh..opq"
 type EntryWithLayer struct {
 	targzi.Entry
 	Layer string
+	// TotalSize and Descendants roll up everything beneath a directory in
+	// the merged filesystem: the bytes of its regular files and the count of
+	// all its entries. They are zero in indexes written before they were
+	// recorded.
+	TotalSize   int64 `json:",omitempty"`
+	Descendants int64 `json:",omitempty"`
 }
 
+// FileReader reads length bytes from offset of the regular file e, for code
+// that reads many files of an image.
+type FileReader func(ctx context.Context, e *EntryWithLayer, offset, length int64) (io.ReadCloser, error)
+
 type LayerReader interface {
 	ReadLayer(ctx context.Context, key *bitypes.ImageInfoKey, layer v1.Layer) ([]MyTarHeader, error)
 }
@@ -0,0 +1,52 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/targzi"
+	"path/filepath"
+	"strings"
+)
+
+// LayerEntry is an entry of a single layer, annotated with what later layers
+// did to it in the image's final filesystem.
+type LayerEntry struct {
+	EntryWithLayer
+	// Whiteout marks the entries that delete files of earlier layers
+	Whiteout bool `json:",omitempty"`
+	// Shadowed is set when a later layer replaces the entry with one of the
+	// same name
+	Shadowed bool `json:",omitempty"`
+	// Deleted is set when a later layer removed the entry, with a whiteout
+	// or by replacing a directory it is in
+	Deleted bool `json:",omitempty"`
+}
+
+// AnnotateLayer compares entries of layer with merged, the entries with the
+// same parents in the image index.
+func AnnotateLayer(layer string, entries []targzi.Entry, merged []EntryWithLayer) []*LayerEntry {
+	final := map[string]*EntryWithLayer{}
+	for i := range merged {
+		final[merged[i].Hdr.Name] = &merged[i]
+	}
+
+	annotated := make([]*LayerEntry, 0, len(entries))
+	for _, e := range entries {
+		le := &LayerEntry{EntryWithLayer: EntryWithLayer{Entry: e, Layer: layer}}
+		if strings.HasPrefix(filepath.Base(e.Hdr.Name), WhiteoutPrefix) {
+			le.Whiteout = true
+		} else if m := final[e.Hdr.Name]; m == nil {
+			le.Deleted = true
+		} else if m.Layer != layer && !redeclared(&e, &m.Entry) {
+			le.Shadowed = true
+		}
+		annotated = append(annotated, le)
+	}
+
+	return annotated
+}
+
+// redeclared reports whether a later layer only declared a directory again,
+// which leaves the earlier one's contents in place.
+func redeclared(e, later *targzi.Entry) bool {
+	return e.Hdr.Typeflag == tar.TypeDir && later.Hdr.Typeflag == tar.TypeDir
+}
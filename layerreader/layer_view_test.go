@@ -0,0 +1,67 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/targzi"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotateLayer(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg}, Parent: targzi.Parent(name)}
+	}
+
+	dir := targzi.Entry{Hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir}}
+
+	lower := []targzi.Entry{dir, e("etc/hosts"), e("etc/passwd"), e("etc/shadow"), e("etc/motd")}
+	upper := []targzi.Entry{dir, e("etc/passwd"), e("etc/.wh.shadow"), e("etc/issue")}
+
+	m := NewMerger()
+	for _, entry := range lower {
+		m.Add(ctx, "sha256:lower", entry)
+	}
+	for _, entry := range upper {
+		m.Add(ctx, "sha256:upper", entry)
+	}
+	merged := []EntryWithLayer{}
+	for _, entry := range m.Entries() {
+		merged = append(merged, *entry)
+	}
+
+	status := func(entries []*LayerEntry) map[string]string {
+		s := map[string]string{}
+		for _, le := range entries {
+			switch {
+			case le.Whiteout:
+				s[le.Hdr.Name] = "whiteout"
+			case le.Shadowed:
+				s[le.Hdr.Name] = "shadowed"
+			case le.Deleted:
+				s[le.Hdr.Name] = "deleted"
+			default:
+				s[le.Hdr.Name] = "visible"
+			}
+		}
+		return s
+	}
+
+	require.Equal(t, map[string]string{
+		"etc/":       "visible",
+		"etc/hosts":  "visible",
+		"etc/passwd": "shadowed",
+		"etc/shadow": "deleted",
+		"etc/motd":   "visible",
+	}, status(AnnotateLayer("sha256:lower", lower, merged)))
+
+	require.Equal(t, map[string]string{
+		"etc/":           "visible",
+		"etc/passwd":     "visible",
+		"etc/.wh.shadow": "whiteout",
+		"etc/issue":      "visible",
+	}, status(AnnotateLayer("sha256:upper", upper, merged)))
+}
@@ -3,10 +3,10 @@ package layerreader
 import (
 	"archive/tar"
 	"browseimage/bitypes"
+	"browseimage/targzi"
 	"context"
 	"fmt"
 	"github.com/google/go-containerregistry/pkg/v1"
-	"github.com/klauspost/compress/gzip"
 	"io"
 )
 
@@ -22,10 +22,11 @@ func (l Local) ReadLayer(ctx context.Context, key *bitypes.ImageInfoKey, layer v
 }
 
 func (l Local) ReadUncompressed(ctx context.Context, key *bitypes.ImageInfoKey, layer v1.Layer, uncompressed io.Reader) ([]MyTarHeader, error) {
-	r, err := gzip.NewReader(uncompressed)
+	r, err := targzi.Decompress(uncompressed)
 	if err != nil {
-		return nil, fmt.Errorf("initializing gzip reader: %w", err)
+		return nil, fmt.Errorf("initializing decompressor: %w", err)
 	}
+	defer r.Close()
 
 	headers := []MyTarHeader{}
 
@@ -0,0 +1,206 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/bitypes"
+	"browseimage/sortedindex"
+	"browseimage/targzi"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// Merger applies the file indexes of an image's layers in order, honouring
+// whiteouts, to produce the file index of the image's final filesystem.
+type Merger struct {
+	files   map[string]*EntryWithLayer
+	history map[string][]HistoryStep
+
+	// for Efficiency
+	totalBytes int64
+	wasted     map[string]int64
+	copies     map[string][]bitypes.FileCopy
+	sizes      map[string]int64
+	layerBytes map[string]int64
+	// lastLayer is the layer of the previous entry, and repeated is set if
+	// it's a layer the image already had further down
+	lastLayer string
+	repeated  bool
+}
+
+func NewMerger() *Merger {
+	return &Merger{
+		files:      map[string]*EntryWithLayer{},
+		history:    map[string][]HistoryStep{},
+		wasted:     map[string]int64{},
+		copies:     map[string][]bitypes.FileCopy{},
+		sizes:      map[string]int64{},
+		layerBytes: map[string]int64{},
+	}
+}
+
+// Add applies a single entry of layer. Entries must be added layer by layer,
+// from the bottom of the image up.
+func (m *Merger) Add(ctx context.Context, layer string, e targzi.Entry) {
+	base := filepath.Base(e.Hdr.Name)
+	if base == WhiteoutOpaqueDir {
+		deletes := []string{}
+		deletedDir := strings.TrimSuffix(e.Hdr.Name, WhiteoutOpaqueDir)
+		slog.DebugContext(ctx, "processing opaque whiteout directory", "dir", deletedDir)
+		for key, entry := range m.files {
+			// Only delete entries from previous layers, not from the current layer
+			if strings.HasPrefix(key, deletedDir) && entry.Layer != layer {
+				deletes = append(deletes, key)
+			}
+		}
+		for _, del := range deletes {
+			m.waste(m.files[del])
+			delete(m.files, del)
+			m.record(del, layer, HistoryOpaque, e.Hdr)
+			slog.DebugContext(ctx, "deleting recursively", "path", del)
+		}
+	} else if strings.HasPrefix(base, WhiteoutPrefix) {
+		name := strings.TrimPrefix(base, WhiteoutPrefix)
+		dir := filepath.Dir(e.Hdr.Name)
+		fullPath := filepath.Join(dir, name)
+		deletes := []string{fullPath}
+		if _, ok := m.files[fullPath]; !ok {
+			// directories are keyed with a trailing slash, and everything
+			// beneath them goes with them
+			deletes = deletes[:0]
+			for key := range m.files {
+				if strings.HasPrefix(key, fullPath+"/") {
+					deletes = append(deletes, key)
+				}
+			}
+		}
+		for _, del := range deletes {
+			// Only delete if it's from a previous layer
+			if existing, ok := m.files[del]; ok && existing.Layer != layer {
+				m.waste(existing)
+				delete(m.files, del)
+				m.record(existing.Hdr.Name, layer, HistoryWhiteout, e.Hdr)
+				slog.DebugContext(ctx, "deleting whiteout file", "path", del)
+			}
+		}
+	} else {
+		action := HistoryCreated
+		if existing, ok := m.files[e.Hdr.Name]; ok {
+			action = HistoryOverwritten
+			m.waste(existing)
+		}
+		m.record(e.Hdr.Name, layer, action, e.Hdr)
+		m.ship(layer, e)
+		m.files[e.Hdr.Name] = &EntryWithLayer{Entry: e, Layer: layer}
+	}
+}
+
+// Entries returns the merged entries, sorted by parent and then name, with
+// the rollups of directories filled in.
+func (m *Merger) Entries() []*EntryWithLayer {
+	m.rollup()
+
+	arr := make([]*EntryWithLayer, 0, len(m.files))
+	for _, e := range m.files {
+		arr = append(arr, e)
+	}
+
+	sortByIndexKey(arr)
+	return arr
+}
+
+// rollup totals the entries beneath each directory.
+func (m *Merger) rollup() {
+	type totals struct{ size, count int64 }
+	dirs := map[string]*totals{}
+
+	for name, e := range m.files {
+		if name == "/" {
+			continue
+		}
+
+		// every ancestor counts the entry, even those with no entry of
+		// their own
+		for dir := e.Parent; ; dir = targzi.Parent(dir) {
+			t := dirs[dir]
+			if t == nil {
+				t = &totals{}
+				dirs[dir] = t
+			}
+			t.count++
+			if e.Hdr.Typeflag == tar.TypeReg {
+				t.size += e.Hdr.Size
+			}
+
+			if dir == "/" {
+				break
+			}
+		}
+	}
+
+	for name, e := range m.files {
+		if e.Hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		e.TotalSize, e.Descendants = 0, 0
+		if t := dirs[name]; t != nil {
+			e.TotalSize, e.Descendants = t.size, t.count
+		}
+	}
+}
+
+// Write writes the merged entries as an image index, which is a sortedindex
+// of JSON entries.
+func (m *Merger) Write(w io.Writer) error {
+	sw := sortedindex.NewWriter(w)
+
+	for _, e := range m.Entries() {
+		j, _ := json.Marshal(e)
+		err := sw.Add(indexKey(e.Parent, e.Hdr.Name), j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sw.Close()
+}
+
+// ReadImageIndex calls fn for each entry of an image index written by
+// Merger.Write, or of the plain gzipped JSON lines indexes that predate it.
+func ReadImageIndex(r io.Reader, fn func(e *EntryWithLayer) error) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gunzipping image index: %w", err)
+	}
+	defer gzr.Close()
+
+	scan := bufio.NewScanner(gzr)
+	scan.Buffer(nil, 1<<20)
+	for scan.Scan() {
+		e := &EntryWithLayer{}
+		err = json.Unmarshal(scan.Bytes(), e)
+		if err != nil {
+			return fmt.Errorf("unmarshalling entry: %w", err)
+		}
+
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = scan.Err()
+	if err != nil {
+		return fmt.Errorf("reading image index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/targzi"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollup(t *testing.T) {
+	ctx := context.Background()
+
+	e := func(name string, typeflag byte, size int64) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: typeflag, Size: size}, Parent: targzi.Parent(name)}
+	}
+
+	m := NewMerger()
+	m.Add(ctx, "sha256:1", e("/", tar.TypeDir, 0))
+	m.Add(ctx, "sha256:1", e("usr/", tar.TypeDir, 0))
+	m.Add(ctx, "sha256:1", e("usr/share/", tar.TypeDir, 0))
+	m.Add(ctx, "sha256:1", e("usr/share/doc/", tar.TypeDir, 0))
+	m.Add(ctx, "sha256:1", e("usr/share/doc/README", tar.TypeReg, 100))
+	m.Add(ctx, "sha256:1", e("usr/share/zoneinfo/UTC", tar.TypeReg, 10)) // no entry for zoneinfo/
+	m.Add(ctx, "sha256:1", e("usr/share/old", tar.TypeReg, 1000))
+	m.Add(ctx, "sha256:1", e("usr/lib", tar.TypeSymlink, 0))
+	m.Add(ctx, "sha256:1", e("usr/share/man/", tar.TypeDir, 0))
+	m.Add(ctx, "sha256:1", e("usr/share/man/man1/ls.1", tar.TypeReg, 500))
+	m.Add(ctx, "sha256:2", e("usr/share/.wh.old", tar.TypeReg, 0))
+	m.Add(ctx, "sha256:2", e("usr/share/.wh.man", tar.TypeReg, 0))
+	m.Add(ctx, "sha256:2", e("usr/share/doc/README", tar.TypeReg, 200))
+
+	rollups := map[string][2]int64{}
+	for _, entry := range m.Entries() {
+		if entry.Hdr.Typeflag == tar.TypeDir {
+			rollups[entry.Hdr.Name] = [2]int64{entry.TotalSize, entry.Descendants}
+		}
+	}
+
+	require.Equal(t, map[string][2]int64{
+		"/":              {210, 6},
+		"usr/":           {210, 5},
+		"usr/share/":     {210, 3},
+		"usr/share/doc/": {200, 1},
+	}, rollups)
+}
@@ -0,0 +1,95 @@
+package layerreader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReaderAt reads a file in an image in aligned blocks, each fetched at most
+// once, for parsers that jump around a file but only look at small parts of
+// it.
+type ReaderAt struct {
+	ctx       context.Context
+	read      FileReader
+	e         *EntryWithLayer
+	blockSize int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte
+	err    error
+}
+
+func NewReaderAt(ctx context.Context, read FileReader, e *EntryWithLayer, blockSize int64) *ReaderAt {
+	return &ReaderAt{ctx: ctx, read: read, e: e, blockSize: blockSize, blocks: map[int64][]byte{}}
+}
+
+// Err is the first error reading the file, as parsers tend not to pass
+// them on.
+func (r *ReaderAt) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	size := r.e.Hdr.Size
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= size {
+			return n, io.EOF
+		}
+
+		block, err := r.block(pos / r.blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[pos%r.blockSize:])
+	}
+
+	return n, nil
+}
+
+func (r *ReaderAt) block(idx int64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b, ok := r.blocks[idx]; ok {
+		return b, nil
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	start := idx * r.blockSize
+	length := min(r.blockSize, r.e.Hdr.Size-start)
+	b, err := r.readRange(start, length)
+	if err != nil {
+		r.err = err
+		return nil, err
+	}
+
+	r.blocks[idx] = b
+	return b, nil
+}
+
+func (r *ReaderAt) readRange(offset, length int64) ([]byte, error) {
+	body, err := r.read(r.ctx, r.e, offset, length)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", r.e.Hdr.Name, err)
+	}
+	defer body.Close()
+
+	b := make([]byte, length)
+	_, err = io.ReadFull(body, b)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", r.e.Hdr.Name, err)
+	}
+	return b, nil
+}
@@ -28,7 +28,7 @@ type Put struct {
 }
 
 type RemoteOutput struct {
-	Gzi Put
+	Gzi *Put `json:",omitempty"` // nil for uncompressed layers
 	Tar Put
 }
 
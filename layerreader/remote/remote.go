@@ -4,6 +4,7 @@ import (
 	"browseimage/bitypes"
 	"browseimage/layerreader"
 	"browseimage/logging"
+	"browseimage/storage"
 	"browseimage/targzi"
 	"context"
 	"fmt"
@@ -13,7 +14,6 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"os"
-	"path/filepath"
 	"sync/atomic"
 	"time"
 
@@ -21,12 +21,14 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
 )
 
 func main() {
@@ -42,24 +44,54 @@ func main() {
 	}
 
 	d := downloader{
-		uploader: manager.NewUploader(s3.NewFromConfig(cfg)),
-		bucket:   os.Getenv("BUCKET"),
+		storage: storage.NewS3(s3.NewFromConfig(cfg), os.Getenv("BUCKET")),
 		dynamodb: dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
 			o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) {
 				o.MaxAttempts = 10
 			})
 		}),
 		table: os.Getenv("TABLE"),
+		http: &auth.Client{
+			Client:     &http.Client{Transport: transport{}},
+			Cache:      auth.DefaultCache,
+			Credential: keychainCredential,
+		},
 	}
 
 	lambda.Start(logging.Middleware(d.handle))
 }
 
 type downloader struct {
-	uploader *manager.Uploader
-	bucket   string
+	storage  storage.Storage
 	dynamodb *dynamodb.Client
 	table    string
+	http     *auth.Client
+}
+
+// keychainCredential gives range requests for blobs the same credentials
+// remote.Image finds in the usual Docker config.
+func keychainCredential(ctx context.Context, hostport string) (auth.Credential, error) {
+	reg, err := name.NewRegistry(hostport)
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("parsing registry: %w", err)
+	}
+
+	authenticator, err := authn.DefaultKeychain.Resolve(reg)
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("resolving credentials: %w", err)
+	}
+
+	cfg, err := authenticator.Authorization()
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("getting credentials: %w", err)
+	}
+
+	return auth.Credential{
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		RefreshToken: cfg.IdentityToken,
+		AccessToken:  cfg.RegistryToken,
+	}, nil
 }
 
 type transport struct{}
@@ -87,7 +119,7 @@ func (d *downloader) handle(ctx context.Context, input *layerreader.RemoteInput)
 		return nil, fmt.Errorf("parsing ref: %w", err)
 	}
 
-	img, err := remote.Image(ref, remote.WithTransport(transport{}), remote.WithContext(ctx))
+	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithTransport(transport{}), remote.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("getting image for ref: %w", err)
 	}
@@ -119,12 +151,16 @@ func (d *downloader) handle(ctx context.Context, input *layerreader.RemoteInput)
 		return nil, fmt.Errorf("putting initial layer progress metrics: %w", err)
 	}
 
-	raw, err := layer.Compressed()
+	mediaType, err := layer.MediaType()
 	if err != nil {
-		return nil, fmt.Errorf("getting layer reader: %w", err)
+		return nil, fmt.Errorf("getting layer media type: %w", err)
 	}
 
-	counter := &CountReader{Reader: raw}
+	// an empty compression makes BuildIndex sniff the blob instead
+	compression := targzi.CompressionFromMediaType(string(mediaType))
+	slog.InfoContext(ctx, "indexing layer", "mediaType", mediaType, "compression", compression)
+
+	counter := &CountReader{}
 	var fileCount int64 = 0
 
 	countctx, cancel := context.WithCancel(ctx)
@@ -136,66 +172,88 @@ func (d *downloader) handle(ctx context.Context, input *layerreader.RemoteInput)
 		return nil, fmt.Errorf("creating temp dir: %w", err)
 	}
 
-	index, err := targzi.BuildIndex(dir, counter, &fileCount)
+	manifest, err := img.Manifest()
 	if err != nil {
-		return nil, fmt.Errorf("building index: %w", err)
+		return nil, fmt.Errorf("getting manifest: %w", err)
+	}
+
+	// only layers annotated with their TOC are worth the requests it takes
+	// to look for one
+	stargz := false
+	for _, desc := range manifest.Layers {
+		if desc.Digest == input.Layer && desc.Annotations[estargz.TOCJSONDigestAnnotation] != "" {
+			stargz = true
+		}
+	}
+
+	var index *targzi.Index
+	if stargz && (compression == targzi.CompressionGzip || compression == "") {
+		// eStargz layers can be indexed from their TOC without downloading them
+		index, err = d.indexStargz(ctx, d.http, ref, input.Layer, totalSize, dir, &fileCount)
+		if err != nil {
+			slog.InfoContext(ctx, "layer is not eStargz, downloading it", "error", err)
+			index = nil
+		} else {
+			atomic.StoreInt64(&counter.count, totalSize)
+		}
+	}
+
+	if index == nil {
+		counter.Reader, err = layer.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("getting layer reader: %w", err)
+		}
+
+		index, err = targzi.BuildIndexWithCompression(dir, counter, compression, &fileCount)
+		if err != nil {
+			return nil, fmt.Errorf("building index: %w", err)
+		}
 	}
 
 	// TODO: there's a race condition here, but this should usually flush
 	// the "downloaded" progress to dynamodb before this lambda function returns
 	cancel()
 
-	prefix := fmt.Sprintf("layers/%s/", input.Layer)
+	output := &layerreader.RemoteOutput{}
 
-	gzf, err := os.Open(index.GzIndexPath)
-	if err != nil {
-		return nil, fmt.Errorf("opening gzip index file: %w", err)
-	}
-	defer gzf.Close()
-	gzfstat, err := gzf.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("getting gzip index file stats: %w", err)
+	// uncompressed layers have no gzip index to upload
+	if index.GzIndexPath != "" {
+		output.Gzi, err = d.upload(ctx, storage.GzIndexKey(input.Layer.String()), index.GzIndexPath)
+		if err != nil {
+			return nil, fmt.Errorf("uploading gzip index to S3: %w", err)
+		}
 	}
 
-	gziPut, err := d.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: &d.bucket,
-		Key:    aws.String(prefix + filepath.Base(index.GzIndexPath)),
-		Body:   gzf,
-	})
+	tarPut, err := d.upload(ctx, storage.FileIndexKey(input.Layer.String()), index.FileIndexPath)
 	if err != nil {
-		return nil, fmt.Errorf("uploading gzip index to S3: %w", err)
+		return nil, fmt.Errorf("uploading file index to S3: %w", err)
 	}
+	output.Tar = *tarPut
+
+	return output, nil
+}
 
-	ff, err := os.Open(index.FileIndexPath)
+func (d *downloader) upload(ctx context.Context, key, path string) (*layerreader.Put, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("opening file index: %w", err)
+		return nil, fmt.Errorf("opening file: %w", err)
 	}
-	defer ff.Close()
-	ffstat, err := ff.Stat()
+	defer f.Close()
+
+	stat, err := f.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("getting file index stats: %w", err)
+		return nil, fmt.Errorf("getting file stats: %w", err)
 	}
 
-	tarPut, err := d.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: &d.bucket,
-		Key:    aws.String(prefix + filepath.Base(index.FileIndexPath)),
-		Body:   ff,
-	})
+	versionId, err := d.storage.Put(ctx, key, f)
 	if err != nil {
-		return nil, fmt.Errorf("uploading file index to S3: %w", err)
+		return nil, err
 	}
 
-	return &layerreader.RemoteOutput{
-		Gzi: layerreader.Put{
-			Key:       *gziPut.Key,
-			VersionId: *gziPut.VersionID,
-			Size:      gzfstat.Size(),
-		},
-		Tar: layerreader.Put{
-			Key:       *tarPut.Key,
-			VersionId: *tarPut.VersionID,
-			Size:      ffstat.Size(),
-		},
+	return &layerreader.Put{
+		Key:       key,
+		VersionId: versionId,
+		Size:      stat.Size(),
 	}, nil
 }
 
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"browseimage/targzi"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// indexStargz indexes an eStargz layer from its footer and TOC alone, which
+// is usually a few KB of range requests rather than the whole blob. It fails
+// for any layer that isn't eStargz. client must be able to pull from ref.
+func (d *downloader) indexStargz(ctx context.Context, client *auth.Client, ref name.Reference, layer v1.Hash, size int64, dir string, fileCount *int64) (*targzi.Index, error) {
+	repo := ref.Context()
+	blob := &blobReaderAt{
+		ctx:    auth.WithScopes(ctx, ref.Scope("pull")),
+		client: client,
+		url:    fmt.Sprintf("https://%s/v2/%s/blobs/%s", repo.RegistryStr(), repo.RepositoryStr(), layer),
+	}
+
+	toc, tocOffset, err := targzi.ReadStargzTOC(io.NewSectionReader(blob, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("reading eStargz toc: %w", err)
+	}
+
+	return targzi.BuildStargzIndex(dir, toc, tocOffset, fileCount)
+}
+
+// blobReaderAt reads a registry blob with a range request per ReadAt.
+type blobReaderAt struct {
+	ctx    context.Context
+	client *auth.Client
+	url    string
+}
+
+func (b *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequestWithContext(b.ctx, "GET", b.url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("fetching blob range: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("fetching blob range: unexpected status %d", resp.StatusCode)
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,181 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// maxLinkHops is how many links Resolve follows before giving up, the same
+// limit as Linux.
+const maxLinkHops = 40
+
+var ErrLinkLoop = errors.New("too many levels of links")
+
+// Lookup returns the entry named name, with or without the trailing slash of
+// a directory, or nil if there isn't one.
+type Lookup func(ctx context.Context, name string) (*EntryWithLayer, error)
+
+// ImageIndexLookup looks up entries in the image index at key.
+func ImageIndexLookup(s storage.Storage, key string) Lookup {
+	return func(ctx context.Context, name string) (*EntryWithLayer, error) {
+		name = strings.TrimSuffix(name, "/")
+		parent := targzi.Parent(name)
+
+		// a directory's name sorts after anything else starting with its
+		// name, so the range also covers siblings like name.txt
+		entries, err := queryImageIndex(ctx, s, key, indexKey(parent, name), indexKey(parent, name+"/\x00"), func(e *EntryWithLayer) bool {
+			return strings.TrimSuffix(e.Hdr.Name, "/") == name
+		})
+		if err != nil || len(entries) == 0 {
+			return nil, err
+		}
+		return &entries[0], nil
+	}
+}
+
+// Resolve finds the entry at name in the merged filesystem, following
+// symlinks in any component of it and hard links at the end, so the result
+// is never a link. It returns an error wrapping fs.ErrNotExist if there's
+// nothing there, and ErrLinkLoop if the links go round in circles.
+func Resolve(ctx context.Context, lookup Lookup, name string) (*EntryWithLayer, error) {
+	cache := map[string]*EntryWithLayer{}
+	find := func(name string) (*EntryWithLayer, error) {
+		if name == "" {
+			name = "/"
+		}
+
+		e, ok := cache[name]
+		if ok {
+			return e, nil
+		}
+
+		e, err := lookup(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("looking up %s: %w", name, err)
+		}
+		cache[name] = e
+		return e, nil
+	}
+
+	hops := 0
+	follow := func() error {
+		hops++
+		if hops > maxLinkHops {
+			return fmt.Errorf("%s: %w", name, ErrLinkLoop)
+		}
+		return nil
+	}
+
+	resolved := ""              // without leading or trailing slashes
+	var current *EntryWithLayer // the entry at resolved, nil if it has none
+	rest := components(name)
+
+	for len(rest) > 0 {
+		component := rest[0]
+		rest = rest[1:]
+
+		if component == ".." {
+			// like the kernel, .. at the root stays there
+			resolved = strings.Trim(path.Dir("/"+resolved), "/")
+			current = nil
+			continue
+		}
+
+		if current != nil && current.Hdr.Typeflag != tar.TypeDir {
+			return nil, fmt.Errorf("%s: %s is not a directory: %w", name, resolved, fs.ErrNotExist)
+		}
+
+		candidate := path.Join(resolved, component)
+		e, err := find(candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case e == nil && len(rest) > 0:
+			// layers don't always include their parent directories, so a
+			// missing entry is only an error at the end
+			resolved = candidate
+			current = nil
+		case e == nil:
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		case e.Hdr.Typeflag == tar.TypeSymlink:
+			err = follow()
+			if err != nil {
+				return nil, err
+			}
+
+			// relative targets are relative to the directory the link is
+			// in, which is where resolved already points
+			if strings.HasPrefix(e.Hdr.Linkname, "/") {
+				resolved = ""
+				current = nil
+			}
+			rest = append(components(e.Hdr.Linkname), rest...)
+		case e.Hdr.Typeflag == tar.TypeLink:
+			// hard link targets are names from the root of the layer, and
+			// never go through symlinks
+			for e != nil && e.Hdr.Typeflag == tar.TypeLink {
+				err = follow()
+				if err != nil {
+					return nil, err
+				}
+
+				candidate = strings.Join(components(e.Hdr.Linkname), "/")
+				e, err = find(candidate)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if e == nil {
+				return nil, fmt.Errorf("%s: hard link target %s: %w", name, candidate, fs.ErrNotExist)
+			}
+
+			resolved = candidate
+			current = e
+		default:
+			resolved = candidate
+			current = e
+		}
+	}
+
+	if current != nil {
+		return current, nil
+	}
+
+	// a directory reached through .. or a symlink, which may have no entry
+	// of its own
+	e, err := find(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		dir := resolved + "/"
+		if resolved == "" {
+			dir = "/"
+		}
+		e = &EntryWithLayer{Entry: targzi.Entry{
+			Hdr:    tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0o755},
+			Parent: targzi.Parent(dir),
+		}}
+	}
+	return e, nil
+}
+
+// components splits a path into its names, without empty ones or dots.
+func components(name string) []string {
+	names := []string{}
+	for _, n := range strings.Split(name, "/") {
+		if n != "" && n != "." {
+			names = append(names, n)
+		}
+	}
+	return names
+}
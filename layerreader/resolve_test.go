@@ -0,0 +1,81 @@
+package layerreader
+
+import (
+	"archive/tar"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+
+	link := func(name, target string, typeflag byte) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: typeflag, Linkname: target}, Parent: targzi.Parent(name)}
+	}
+	dir := func(name string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeDir}, Parent: targzi.Parent(name)}
+	}
+	file := func(name string) targzi.Entry {
+		return targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1}, Parent: targzi.Parent(name)}
+	}
+
+	m := NewMerger()
+	for _, e := range []targzi.Entry{
+		dir("usr/"),
+		dir("usr/lib/"),
+		file("usr/lib/libfoo.so.1.2"),
+		file("usr/lib/libfoo.so.1.2.txt"),
+		link("usr/lib/libfoo.so.1", "libfoo.so.1.2", tar.TypeSymlink),
+		link("usr/lib/libfoo.so", "/usr/lib/libfoo.so.1", tar.TypeSymlink),
+		link("lib", "usr/lib", tar.TypeSymlink),
+		link("usr/lib64", "./lib/", tar.TypeSymlink),
+		link("usr/bin/foo", "usr/lib/libfoo.so.1.2", tar.TypeLink),
+		link("usr/bin/bar", "usr/bin/foo", tar.TypeLink),
+		link("loop/a", "b", tar.TypeSymlink),
+		link("loop/b", "../loop/a", tar.TypeSymlink),
+		link("dangling", "nowhere", tar.TypeSymlink),
+		file("etc/passwd"), // etc/ itself has no entry
+		link("etc/up", "..", tar.TypeSymlink),
+	} {
+		m.Add(ctx, "sha256:layer", e)
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, m.Write(buf))
+	s := storage.NewLocal(t.TempDir())
+	_, err := s.Put(ctx, "index.json.gz", bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	lookup := ImageIndexLookup(s, "index.json.gz")
+
+	for name, expected := range map[string]string{
+		"usr/lib/libfoo.so":            "usr/lib/libfoo.so.1.2",
+		"/lib/libfoo.so.1":             "usr/lib/libfoo.so.1.2",
+		"/usr/lib64/libfoo.so":         "usr/lib/libfoo.so.1.2",
+		"usr/lib/../lib/./libfoo.so.1": "usr/lib/libfoo.so.1.2",
+		"usr/bin/bar":                  "usr/lib/libfoo.so.1.2",
+		"lib":                          "usr/lib/",
+		"/usr/lib64/":                  "usr/lib/",
+		"etc/passwd":                   "etc/passwd",
+		"etc/up/etc/passwd":            "etc/passwd",
+		"etc/up":                       "/",
+		"/../..":                       "/",
+	} {
+		e, err := Resolve(ctx, lookup, name)
+		require.NoError(t, err, name)
+		require.Equal(t, expected, e.Hdr.Name, name)
+	}
+
+	for _, name := range []string{"dangling", "usr/lib/missing", "etc/passwd/x", "usr/bin/foo/x"} {
+		_, err := Resolve(ctx, lookup, name)
+		require.ErrorIs(t, err, fs.ErrNotExist, name)
+	}
+
+	_, err = Resolve(ctx, lookup, "loop/a")
+	require.ErrorIs(t, err, ErrLinkLoop)
+}
@@ -0,0 +1,50 @@
+package layerreader
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathMatcher matches entry names against a search query. Queries with glob
+// metacharacters are globs, matched against the whole path if they contain a
+// slash and against the base name otherwise, so "*.so" finds shared objects
+// in every directory. Other queries match any path containing them.
+type PathMatcher struct {
+	query string
+	glob  bool
+	base  bool
+}
+
+func NewPathMatcher(query string) (*PathMatcher, error) {
+	query = strings.TrimPrefix(query, "/")
+	if query == "" {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	m := &PathMatcher{query: query}
+	if strings.ContainsAny(query, "*?[") {
+		_, err := path.Match(query, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", query, err)
+		}
+
+		m.glob = true
+		m.base = !strings.Contains(strings.TrimSuffix(query, "/"), "/")
+	}
+
+	return m, nil
+}
+
+func (m *PathMatcher) Match(name string) bool {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "/"), "/")
+	if !m.glob {
+		return strings.Contains(name, m.query)
+	}
+
+	if m.base {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(strings.TrimSuffix(m.query, "/"), name)
+	return ok
+}
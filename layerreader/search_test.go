@@ -0,0 +1,37 @@
+package layerreader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathMatcher(t *testing.T) {
+	tests := []struct {
+		query string
+		name  string
+		match bool
+	}{
+		{"*.so", "usr/lib/libc.so", true},
+		{"*.so", "usr/lib/libc.so.6", false},
+		{"*.so*", "usr/lib/libc.so.6", true},
+		{"openssl", "usr/bin/openssl", true},
+		{"openssl", "etc/ssl/openssl.cnf", true},
+		{"openssl", "usr/bin/ssl", false},
+		{"usr/*/openssl", "usr/bin/openssl", true},
+		{"/usr/*/openssl", "usr/local/bin/openssl", false},
+		{"li?", "usr/lib/", true},
+		{"share/doc", "usr/share/doc/", true},
+	}
+
+	for _, tt := range tests {
+		m, err := NewPathMatcher(tt.query)
+		require.NoError(t, err)
+		require.Equal(t, tt.match, m.Match(tt.name), "%s %s", tt.query, tt.name)
+	}
+
+	_, err := NewPathMatcher("")
+	require.Error(t, err)
+	_, err = NewPathMatcher("[a-")
+	require.Error(t, err)
+}
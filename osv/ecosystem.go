@@ -0,0 +1,113 @@
+package osv
+
+import (
+	"browseimage/sbom"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Query is what to look up for a package: which dump has its advisories,
+// and its name and version as that ecosystem knows them.
+type Query struct {
+	// Dump is the directory of the ecosystem's dump, like "Debian"
+	Dump      string
+	Ecosystem string
+	Name      string
+	Version   string
+}
+
+// distroEcosystems are the OSV ecosystems of distros' os-release IDs, for
+// the distros whose advisories are in OSV.
+var distroEcosystems = map[string]string{
+	"debian":    "Debian",
+	"ubuntu":    "Ubuntu",
+	"alpine":    "Alpine",
+	"almalinux": "AlmaLinux",
+	"rocky":     "Rocky Linux",
+}
+
+// QueryFor returns what to look up for a package found in an image, or false
+// if OSV doesn't cover its ecosystem.
+func QueryFor(p sbom.Package, distro *sbom.Distro) (Query, bool) {
+	switch p.Type {
+	case "npm":
+		return Query{Dump: "npm", Ecosystem: "npm", Name: p.Name, Version: p.Version}, true
+	case "pypi":
+		return Query{Dump: "PyPI", Ecosystem: "PyPI", Name: normalizeName("PyPI", p.Name), Version: p.Version}, true
+	case "golang":
+		if p.Name == "stdlib" {
+			v, ok := goVersion(p.Version)
+			return Query{Dump: "Go", Ecosystem: "Go", Name: "stdlib", Version: v}, ok
+		}
+		return Query{Dump: "Go", Ecosystem: "Go", Name: p.Name, Version: p.Version}, true
+	case "deb", "apk", "rpm":
+	default:
+		return Query{}, false
+	}
+
+	// OS packages are only matched against advisories for their release
+	if distro == nil || distro.VersionID == "" {
+		return Query{}, false
+	}
+	base, ok := distroEcosystems[distro.ID]
+	if !ok {
+		return Query{}, false
+	}
+
+	name := p.Name
+	if p.Source != "" {
+		name = p.Source
+	}
+	q := Query{Dump: base, Name: name, Version: p.Version}
+
+	major, rest, _ := strings.Cut(distro.VersionID, ".")
+	minor, _, _ := strings.Cut(rest, ".")
+	switch base {
+	case "Debian":
+		q.Ecosystem = "Debian:" + major
+	case "Ubuntu":
+		// LTS releases are the even years' April ones
+		q.Ecosystem = "Ubuntu:" + distro.VersionID
+		if n, err := strconv.Atoi(major); err == nil && n%2 == 0 && minor == "04" {
+			q.Ecosystem += ":LTS"
+		}
+	case "Alpine":
+		q.Ecosystem = "Alpine:v" + major + "." + minor
+	default:
+		q.Ecosystem = base + ":" + major
+	}
+
+	return q, true
+}
+
+var goVersionPattern = regexp.MustCompile(`^go(\d+(?:\.\d+)*)(?:(rc|beta)(\d+))?`)
+
+// goVersion converts a Go release like go1.21rc2 to the semantic version
+// OSV uses for it, 1.21.0-rc.2.
+func goVersion(v string) (string, bool) {
+	m := goVersionPattern.FindStringSubmatch(v)
+	if m == nil {
+		return "", false
+	}
+
+	version := m[1]
+	for strings.Count(version, ".") < 2 {
+		version += ".0"
+	}
+	if m[2] != "" {
+		version += "-" + m[2] + "." + m[3]
+	}
+	return version, true
+}
+
+var pypiSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizeName is the name a package is indexed by, which for PyPI is
+// case and separator insensitive.
+func normalizeName(ecosystem, name string) string {
+	if ecosystem == "PyPI" {
+		return strings.ToLower(pypiSeparators.ReplaceAllString(name, "-"))
+	}
+	return name
+}
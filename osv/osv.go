@@ -0,0 +1,291 @@
+// Package osv matches packages against advisories in the Open Source
+// Vulnerability format (https://ossf.github.io/osv-schema/), as published in
+// per-ecosystem dumps like gs://osv-vulnerabilities/Debian/all.zip.
+package osv
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+type Vulnerability struct {
+	ID               string           `json:"id"`
+	Summary          string           `json:"summary,omitempty"`
+	Aliases          []string         `json:"aliases,omitempty"`
+	Withdrawn        string           `json:"withdrawn,omitempty"`
+	Severity         []Severity       `json:"severity,omitempty"`
+	Affected         []Affected       `json:"affected"`
+	DatabaseSpecific databaseSpecific `json:"database_specific,omitempty"`
+}
+
+type Severity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type Affected struct {
+	Package           AffectedPackage  `json:"package"`
+	Severity          []Severity       `json:"severity,omitempty"`
+	Ranges            []Range          `json:"ranges,omitempty"`
+	Versions          []string         `json:"versions,omitempty"`
+	EcosystemSpecific databaseSpecific `json:"ecosystem_specific,omitempty"`
+	DatabaseSpecific  databaseSpecific `json:"database_specific,omitempty"`
+}
+
+type AffectedPackage struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+}
+
+type Range struct {
+	// Type is SEMVER, ECOSYSTEM or GIT, which can't be matched to an
+	// installed version
+	Type   string  `json:"type"`
+	Events []Event `json:"events"`
+}
+
+// Event is one of the bounds of a range, only one field of which is set.
+type Event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+	Limit        string `json:"limit,omitempty"`
+}
+
+// databaseSpecific is the free-form object databases put their own fields
+// in, of which only the severity some of them rate is useful here.
+type databaseSpecific struct {
+	Severity string `json:"severity,omitempty"`
+}
+
+// DB is the advisories of a dump, by ecosystem and then package.
+type DB struct {
+	packages map[string]map[string][]*entry
+}
+
+// entry is one package's part of an advisory.
+type entry struct {
+	vuln     *Vulnerability
+	affected *Affected
+}
+
+func NewDB() *DB {
+	return &DB{packages: map[string]map[string][]*entry{}}
+}
+
+// Add indexes v by the packages it affects. Withdrawn advisories are
+// ignored.
+func (db *DB) Add(v *Vulnerability) {
+	if v.Withdrawn != "" {
+		return
+	}
+
+	for i := range v.Affected {
+		a := &v.Affected[i]
+		eco := db.packages[a.Package.Ecosystem]
+		if eco == nil {
+			eco = map[string][]*entry{}
+			db.packages[a.Package.Ecosystem] = eco
+		}
+		name := normalizeName(a.Package.Ecosystem, a.Package.Name)
+		eco[name] = append(eco[name], &entry{vuln: v, affected: a})
+	}
+}
+
+// Load reads every advisory in a zip of OSV JSON files. Files that aren't
+// valid advisories are skipped.
+func Load(r io.ReaderAt, size int64) (*DB, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("opening advisories: %w", err)
+	}
+
+	db := NewDB()
+	for _, f := range zr.File {
+		if path.Ext(f.Name) != ".json" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", f.Name, err)
+		}
+		v := &Vulnerability{}
+		err = json.NewDecoder(rc).Decode(v)
+		rc.Close()
+		if err != nil {
+			slog.Warn("skipping invalid advisory", "name", f.Name, "error", err)
+			continue
+		}
+
+		db.Add(v)
+	}
+
+	return db, nil
+}
+
+// Finding is an advisory that affects an installed version of a package.
+type Finding struct {
+	ID      string
+	Aliases []string `json:",omitempty"`
+	Summary string   `json:",omitempty"`
+	// Fixed is the lowest version above the installed one that fixes it, or
+	// empty if there isn't one yet
+	Fixed    string `json:",omitempty"`
+	Severity Rating
+}
+
+// Match finds the advisories that affect version of the package name in
+// ecosystem, like "Debian:12". Ranges are compared with the ecosystem's own
+// version ordering, which is only known for some.
+func (db *DB) Match(ecosystem, name, version string) []Finding {
+	cmp := comparator(ecosystem)
+
+	findings := []Finding{}
+	for _, e := range db.packages[ecosystem][normalizeName(ecosystem, name)] {
+		affected, fixed := e.affected.contains(version, cmp)
+		if !affected {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			ID:       e.vuln.ID,
+			Aliases:  e.vuln.Aliases,
+			Summary:  e.vuln.Summary,
+			Fixed:    fixed,
+			Severity: e.rate(),
+		})
+	}
+
+	return findings
+}
+
+// contains reports whether version is affected, and the version that fixes
+// it if any. Listed versions are matched exactly even if the ecosystem's
+// ordering isn't known.
+func (a *Affected) contains(version string, cmp compareFunc) (bool, string) {
+	affected := false
+	for _, v := range a.Versions {
+		if v == version {
+			affected = true
+		}
+	}
+
+	fixed := ""
+	for _, r := range a.Ranges {
+		if r.Type == "GIT" {
+			continue
+		}
+		c := cmp
+		if r.Type == "SEMVER" {
+			c = compareSemver
+		}
+		if c == nil {
+			continue
+		}
+
+		in, fix := r.contains(version, c)
+		if in {
+			affected = true
+			if fix != "" && (fixed == "" || c(fix, fixed) < 0) {
+				fixed = fix
+			}
+		}
+	}
+
+	return affected, fixed
+}
+
+// contains evaluates a range as the OSV schema describes: in version order,
+// an introduced event starts an affected span and a fixed or last_affected
+// one ends it.
+func (r *Range) contains(version string, cmp compareFunc) (bool, string) {
+	type bound struct {
+		version string
+		event   Event
+	}
+	bounds := []bound{}
+	for _, e := range r.Events {
+		switch {
+		case e.Introduced != "":
+			bounds = append(bounds, bound{e.Introduced, e})
+		case e.Fixed != "":
+			bounds = append(bounds, bound{e.Fixed, e})
+		case e.LastAffected != "":
+			bounds = append(bounds, bound{e.LastAffected, e})
+		}
+	}
+
+	// "0" is before every version, whatever the ecosystem
+	less := func(a, b string) bool {
+		if a == "0" || b == "0" {
+			return a == "0" && b != "0"
+		}
+		return cmp(a, b) < 0
+	}
+	for i := 1; i < len(bounds); i++ {
+		for j := i; j > 0 && less(bounds[j].version, bounds[j-1].version); j-- {
+			bounds[j], bounds[j-1] = bounds[j-1], bounds[j]
+		}
+	}
+
+	affected := false
+	fixed := ""
+	for _, b := range bounds {
+		switch {
+		case b.event.Introduced != "":
+			if b.version == "0" || cmp(version, b.version) >= 0 {
+				affected = true
+			}
+		case b.event.Fixed != "":
+			if cmp(version, b.version) >= 0 {
+				affected = false
+			} else if affected && fixed == "" {
+				fixed = b.version
+			}
+		case b.event.LastAffected != "":
+			if cmp(version, b.version) > 0 {
+				affected = false
+			}
+		}
+	}
+
+	if !affected {
+		return false, ""
+	}
+	return true, fixed
+}
+
+// rate is the severity of an advisory: the score of its CVSS vector if it has
+// one, otherwise whatever rating its database gives.
+func (e *entry) rate() Rating {
+	for _, sev := range [][]Severity{e.affected.Severity, e.vuln.Severity} {
+		for _, s := range sev {
+			if s.Type != "CVSS_V3" {
+				continue
+			}
+			score, err := cvss3BaseScore(s.Score)
+			if err != nil {
+				slog.Warn("invalid CVSS vector", "id", e.vuln.ID, "vector", s.Score, "error", err)
+				continue
+			}
+			return Rating{Level: level(score), Score: score, Vector: s.Score}
+		}
+	}
+
+	for _, s := range []string{e.vuln.DatabaseSpecific.Severity, e.affected.DatabaseSpecific.Severity, e.affected.EcosystemSpecific.Severity} {
+		switch strings.ToUpper(s) {
+		case "LOW", "MEDIUM", "HIGH", "CRITICAL":
+			return Rating{Level: strings.ToUpper(s)}
+		case "MODERATE":
+			return Rating{Level: "MEDIUM"}
+		}
+	}
+
+	return Rating{Level: "UNKNOWN"}
+}
@@ -0,0 +1,136 @@
+package osv
+
+import (
+	"archive/zip"
+	"browseimage/sbom"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatch(t *testing.T) {
+	advisories := []Vulnerability{{
+		ID:       "DSA-1",
+		Aliases:  []string{"CVE-2024-0001"},
+		Severity: []Severity{{Type: "CVSS_V3", Score: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}},
+		Affected: []Affected{{
+			Package: AffectedPackage{Ecosystem: "Debian:12", Name: "glibc"},
+			Ranges:  []Range{{Type: "ECOSYSTEM", Events: []Event{{Introduced: "0"}, {Fixed: "2.36-9+deb12u5"}}}},
+		}, {
+			Package: AffectedPackage{Ecosystem: "Debian:11", Name: "glibc"},
+			Ranges:  []Range{{Type: "ECOSYSTEM", Events: []Event{{Introduced: "0"}, {Fixed: "2.31-13+deb11u9"}}}},
+		}},
+	}, {
+		ID:               "GHSA-2",
+		DatabaseSpecific: databaseSpecific{Severity: "MODERATE"},
+		Affected: []Affected{{
+			Package: AffectedPackage{Ecosystem: "PyPI", Name: "Flask-Cors"},
+			// unsorted, with two affected spans
+			Ranges: []Range{{Type: "ECOSYSTEM", Events: []Event{{Fixed: "4.0.1"}, {Introduced: "4.0.0"}, {Fixed: "3.0.10"}, {Introduced: "3.0.0"}}}},
+		}},
+	}, {
+		ID: "GO-3",
+		Affected: []Affected{{
+			Package: AffectedPackage{Ecosystem: "Go", Name: "stdlib"},
+			Ranges:  []Range{{Type: "SEMVER", Events: []Event{{Introduced: "1.21.0-0"}, {LastAffected: "1.21.5"}}}},
+		}},
+	}, {
+		ID: "ALPINE-4",
+		Affected: []Affected{{
+			Package:  AffectedPackage{Ecosystem: "Alpine:v3.19", Name: "busybox"},
+			Versions: []string{"1.36.1-r15"},
+		}},
+	}, {
+		ID:        "WITHDRAWN-5",
+		Withdrawn: "2024-01-01T00:00:00Z",
+		Affected:  []Affected{{Package: AffectedPackage{Ecosystem: "Alpine:v3.19", Name: "busybox"}, Versions: []string{"1.36.1-r15"}}},
+	}}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, v := range advisories {
+		f, err := zw.Create(v.ID + ".json")
+		require.NoError(t, err)
+		require.NoError(t, json.NewEncoder(f).Encode(v))
+	}
+	f, err := zw.Create("broken.json")
+	require.NoError(t, err)
+	f.Write([]byte("{"))
+	require.NoError(t, zw.Close())
+
+	db, err := Load(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	debian := &sbom.Distro{ID: "debian", VersionID: "12"}
+	match := func(p sbom.Package, distro *sbom.Distro) []Finding {
+		q, ok := QueryFor(p, distro)
+		require.True(t, ok)
+		return db.Match(q.Ecosystem, q.Name, q.Version)
+	}
+
+	libc := sbom.Package{Name: "libc6", Source: "glibc", Version: "2.36-9+deb12u4", Type: "deb"}
+	require.Equal(t, []Finding{{
+		ID:       "DSA-1",
+		Aliases:  []string{"CVE-2024-0001"},
+		Fixed:    "2.36-9+deb12u5",
+		Severity: Rating{Level: "CRITICAL", Score: 9.8, Vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"},
+	}}, match(libc, debian))
+
+	libc.Version = "2.36-9+deb12u5"
+	require.Empty(t, match(libc, debian))
+
+	flask := sbom.Package{Name: "flask_cors", Version: "3.0.9", Type: "pypi"}
+	findings := match(flask, nil)
+	require.Len(t, findings, 1)
+	require.Equal(t, "3.0.10", findings[0].Fixed)
+	require.Equal(t, Rating{Level: "MEDIUM"}, findings[0].Severity)
+
+	flask.Version = "3.0.10"
+	require.Empty(t, match(flask, nil))
+	flask.Version = "4.0.0"
+	require.Equal(t, "4.0.1", match(flask, nil)[0].Fixed)
+
+	stdlib := sbom.Package{Name: "stdlib", Version: "go1.21.5", Type: "golang"}
+	findings = match(stdlib, nil)
+	require.Len(t, findings, 1)
+	require.Empty(t, findings[0].Fixed)
+	require.Equal(t, "UNKNOWN", findings[0].Severity.Level)
+	stdlib.Version = "go1.21.6"
+	require.Empty(t, match(stdlib, nil))
+
+	busybox := sbom.Package{Name: "busybox-binsh", Source: "busybox", Version: "1.36.1-r15", Type: "apk"}
+	findings = match(busybox, &sbom.Distro{ID: "alpine", VersionID: "3.19.1"})
+	require.Len(t, findings, 1)
+	require.Equal(t, "ALPINE-4", findings[0].ID)
+
+	_, ok := QueryFor(sbom.Package{Name: "bash", Type: "rpm"}, &sbom.Distro{ID: "fedora", VersionID: "40"})
+	require.False(t, ok)
+	_, ok = QueryFor(libc, nil)
+	require.False(t, ok)
+
+	q, ok := QueryFor(libc, &sbom.Distro{ID: "ubuntu", VersionID: "22.04"})
+	require.True(t, ok)
+	require.Equal(t, Query{Dump: "Ubuntu", Ecosystem: "Ubuntu:22.04:LTS", Name: "glibc", Version: libc.Version}, q)
+}
+
+func TestCVSS3BaseScore(t *testing.T) {
+	for vector, want := range map[string]float64{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": 9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": 10,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:U/C:L/I:L/A:N": 5.4,
+		"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:N/A:N": 5.5,
+		"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:C/C:L/I:L/A:N": 6.4,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:N": 0,
+	} {
+		score, err := cvss3BaseScore(vector)
+		require.NoError(t, err, vector)
+		require.Equal(t, want, score, vector)
+	}
+
+	_, err := cvss3BaseScore("CVSS:3.1/AV:N/AC:L")
+	require.Error(t, err)
+	_, err = cvss3BaseScore("AV:N/AC:L/Au:N/C:P/I:P/A:P")
+	require.Error(t, err)
+}
@@ -0,0 +1,479 @@
+package osv
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// compareFunc orders two versions of an ecosystem, returning -1, 0 or +1.
+type compareFunc func(a, b string) int
+
+// comparator returns the version ordering of an ecosystem, or nil if it
+// isn't known, in which case only exactly listed versions can match.
+func comparator(ecosystem string) compareFunc {
+	base, _, _ := strings.Cut(ecosystem, ":")
+	switch base {
+	case "Debian", "Ubuntu":
+		return compareDebian
+	case "Alpine":
+		return compareAlpine
+	case "AlmaLinux", "Rocky Linux":
+		return compareRPM
+	case "Go", "npm":
+		return compareSemver
+	case "PyPI":
+		return comparePyPI
+	}
+	return nil
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// compareNumeric orders two strings of digits of any length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// splitEpoch splits the "epoch:" off the versions of dpkg and rpm.
+func splitEpoch(v string) (string, string) {
+	epoch, rest, ok := strings.Cut(v, ":")
+	if !ok {
+		return "0", v
+	}
+	for i := 0; i < len(epoch); i++ {
+		if !isDigit(epoch[i]) {
+			return "0", v
+		}
+	}
+	return epoch, rest
+}
+
+// compareDebian orders versions as dpkg does
+// (https://www.debian.org/doc/debian-policy/ch-controlfields.html#version).
+func compareDebian(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+
+	ua, ra := a, ""
+	if i := strings.LastIndexByte(a, '-'); i >= 0 {
+		ua, ra = a[:i], a[i+1:]
+	}
+	ub, rb := b, ""
+	if i := strings.LastIndexByte(b, '-'); i >= 0 {
+		ub, rb = b[:i], b[i+1:]
+	}
+
+	if c := debianVerrevcmp(ua, ub); c != 0 {
+		return c
+	}
+	return debianVerrevcmp(ra, rb)
+}
+
+// debianOrder is where a character of the non-digit part of a version sorts:
+// tildes before the end of the string, and letters before everything else.
+func debianOrder(s string) int {
+	switch {
+	case s == "", isDigit(s[0]):
+		return 0
+	case isAlpha(s[0]):
+		return int(s[0])
+	case s[0] == '~':
+		return -1
+	}
+	return int(s[0]) + 256
+}
+
+func debianVerrevcmp(a, b string) int {
+	for a != "" || b != "" {
+		for a != "" && !isDigit(a[0]) || b != "" && !isDigit(b[0]) {
+			ac, bc := debianOrder(a), debianOrder(b)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		i := 0
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		j := 0
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		if c := compareNumeric(a[:i], b[:j]); c != 0 {
+			return c
+		}
+		a, b = a[i:], b[j:]
+	}
+	return 0
+}
+
+// compareRPM orders epoch:version-release strings as rpm does. A missing
+// release matches any, so "1.2" is neither before nor after "1.2-3".
+func compareRPM(a, b string) int {
+	ea, a := splitEpoch(a)
+	eb, b := splitEpoch(b)
+	if c := compareNumeric(ea, eb); c != 0 {
+		return c
+	}
+
+	va, ra, hasRa := strings.Cut(a, "-")
+	vb, rb, hasRb := strings.Cut(b, "-")
+	if c := rpmvercmp(va, vb); c != 0 || !hasRa || !hasRb {
+		return c
+	}
+	return rpmvercmp(ra, rb)
+}
+
+// rpmvercmp is rpm's comparison of one part of a version: alternating runs
+// of digits and letters, where separators only separate, a tilde sorts
+// before anything and a caret after the end.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isSep := func(s string) bool {
+		return s != "" && !isDigit(s[0]) && !isAlpha(s[0]) && s[0] != '~' && s[0] != '^'
+	}
+	first := func(s string) byte {
+		if s == "" {
+			return 0
+		}
+		return s[0]
+	}
+
+	for a != "" || b != "" {
+		for isSep(a) {
+			a = a[1:]
+		}
+		for isSep(b) {
+			b = b[1:]
+		}
+
+		if first(a) == '~' || first(b) == '~' {
+			if first(a) != '~' {
+				return 1
+			}
+			if first(b) != '~' {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if first(a) == '^' || first(b) == '^' {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case a[0] != '^':
+				return 1
+			case b[0] != '^':
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(a[0])
+		run := func(s string) int {
+			i := 0
+			for i < len(s) && (numeric && isDigit(s[i]) || !numeric && isAlpha(s[i])) {
+				i++
+			}
+			return i
+		}
+		i, j := run(a), run(b)
+
+		// a number is newer than letters
+		if j == 0 {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		var c int
+		if numeric {
+			c = compareNumeric(a[:i], b[:j])
+		} else {
+			c = strings.Compare(a[:i], b[:j])
+		}
+		if c != 0 {
+			return c
+		}
+		a, b = a[i:], b[j:]
+	}
+
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+// alpineSuffixes rank the suffixes of apk versions, with no suffix between
+// the pre-releases and the patches.
+var alpineSuffixes = map[string]int{"alpha": 0, "beta": 1, "pre": 2, "rc": 3, "cvs": 5, "svn": 6, "git": 7, "hg": 8, "p": 9}
+
+const alpineNoSuffix = 4
+
+type alpineVersion struct {
+	numbers  []string
+	letter   byte
+	suffixes [][2]string // rank and number
+	revision string
+}
+
+var alpineVersionPattern = regexp.MustCompile(`^(\d+(?:\.\d+)*)([a-z]?)((?:_[a-z]+\d*)*)(?:-r(\d+))?$`)
+var alpineSuffixPattern = regexp.MustCompile(`_([a-z]+)(\d*)`)
+
+func parseAlpine(v string) (*alpineVersion, bool) {
+	m := alpineVersionPattern.FindStringSubmatch(v)
+	if m == nil {
+		return nil, false
+	}
+
+	av := &alpineVersion{numbers: strings.Split(m[1], "."), revision: m[4]}
+	if m[2] != "" {
+		av.letter = m[2][0]
+	}
+	for _, s := range alpineSuffixPattern.FindAllStringSubmatch(m[3], -1) {
+		rank, ok := alpineSuffixes[s[1]]
+		if !ok {
+			return nil, false
+		}
+		av.suffixes = append(av.suffixes, [2]string{strconv.Itoa(rank), s[2]})
+	}
+	return av, true
+}
+
+// compareAlpine orders versions as apk does: numbers, then a letter, then
+// suffixes like _rc1 or _p2, then the -r revision. Versions it can't parse
+// are compared as strings.
+func compareAlpine(a, b string) int {
+	va, okA := parseAlpine(a)
+	vb, okB := parseAlpine(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+
+	for i := 0; i < len(va.numbers) || i < len(vb.numbers); i++ {
+		switch {
+		case i >= len(va.numbers):
+			return -1
+		case i >= len(vb.numbers):
+			return 1
+		}
+		if c := compareNumeric(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	if va.letter != vb.letter {
+		return sign(int(va.letter) - int(vb.letter))
+	}
+
+	none := [2]string{strconv.Itoa(alpineNoSuffix), ""}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		sa, sb := none, none
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if c := compareNumeric(sa[0], sb[0]); c != 0 {
+			return c
+		}
+		if c := compareNumeric(sa[1], sb[1]); c != 0 {
+			return c
+		}
+	}
+
+	return compareNumeric(va.revision, vb.revision)
+}
+
+// compareSemver orders semantic versions (https://semver.org), with or
+// without a leading v, as Go and npm use them. Missing minor and patch
+// numbers are zero.
+func compareSemver(a, b string) int {
+	ca, pa := splitSemver(a)
+	cb, pb := splitSemver(b)
+
+	for i := 0; i < len(ca) || i < len(cb); i++ {
+		na, nb := "0", "0"
+		if i < len(ca) {
+			na = ca[i]
+		}
+		if i < len(cb) {
+			nb = cb[i]
+		}
+		if c := compareNumeric(na, nb); c != 0 {
+			return c
+		}
+	}
+
+	// a pre-release is before its release
+	switch {
+	case pa == "" && pb == "":
+		return 0
+	case pa == "":
+		return 1
+	case pb == "":
+		return -1
+	}
+
+	ia, ib := strings.Split(pa, "."), strings.Split(pb, ".")
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		na, nb := isNumeric(ia[i]), isNumeric(ib[i])
+		var c int
+		switch {
+		case na && nb:
+			c = compareNumeric(ia[i], ib[i])
+		case na:
+			c = -1
+		case nb:
+			c = 1
+		default:
+			c = strings.Compare(ia[i], ib[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return sign(len(ia) - len(ib))
+}
+
+func splitSemver(v string) ([]string, string) {
+	v = strings.TrimPrefix(v, "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, _ := strings.Cut(v, "-")
+	return strings.Split(core, "."), pre
+}
+
+func isNumeric(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+var pep440Pattern = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)(?:[-_.]?(a|alpha|b|beta|c|rc|pre|preview)[-_.]?(\d*))?(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d*))?(?:[-_.]?(dev)[-_.]?(\d*))?(?:\+.*)?$`)
+
+// pep440Phases order the pre-release phases of Python versions.
+var pep440Phases = map[string]int{"a": 0, "alpha": 0, "b": 1, "beta": 1, "c": 2, "rc": 2, "pre": 2, "preview": 2}
+
+// pep440Releases is how many release numbers a Python version can have to be
+// compared, which is more than anyone uses
+const pep440Releases = 8
+
+// comparePyPI orders Python versions as PEP 440 does. Versions it can't
+// parse are compared as strings.
+func comparePyPI(a, b string) int {
+	ka, okA := pep440Key(a)
+	kb, okB := pep440Key(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return sign(ka[i] - kb[i])
+		}
+	}
+	return 0
+}
+
+// pep440Key turns a version into numbers that sort like it: the epoch, the
+// release numbers padded with zeros, then the pre-release, post-release and
+// dev parts, with missing ones sorting where PEP 440 puts them.
+func pep440Key(v string) ([]int, bool) {
+	m := pep440Pattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(v)))
+	if m == nil {
+		return nil, false
+	}
+	epoch, release, phase, pre, implicitPost, post, postN, dev, devN := m[1], strings.Split(m[2], "."), m[3], m[4], m[5], m[6], m[7], m[8], m[9]
+	if len(release) > pep440Releases {
+		return nil, false
+	}
+
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	const inf = 1 << 30
+
+	key := []int{atoi(epoch)}
+	for i := 0; i < pep440Releases; i++ {
+		if i < len(release) {
+			key = append(key, atoi(release[i]))
+		} else {
+			key = append(key, 0)
+		}
+	}
+
+	hasPost := implicitPost != "" || post != ""
+	switch {
+	case phase != "":
+		key = append(key, pep440Phases[phase], atoi(pre))
+	case dev != "" && !hasPost:
+		// 1.0.dev1 is before 1.0a1
+		key = append(key, -inf, 0)
+	default:
+		key = append(key, inf, 0)
+	}
+
+	switch {
+	case implicitPost != "":
+		key = append(key, atoi(implicitPost))
+	case post != "":
+		key = append(key, atoi(postN))
+	default:
+		key = append(key, -inf)
+	}
+
+	if dev != "" {
+		key = append(key, atoi(devN))
+	} else {
+		key = append(key, inf)
+	}
+
+	return key, true
+}
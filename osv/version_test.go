@@ -0,0 +1,59 @@
+package osv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		cmp  compareFunc
+		a, b string
+		want int
+	}{
+		{compareDebian, "2.36-9+deb12u4", "2.36-9+deb12u4", 0},
+		{compareDebian, "2.36-9+deb12u3", "2.36-9+deb12u4", -1},
+		{compareDebian, "1.0~rc1-1", "1.0-1", -1},
+		{compareDebian, "1.0-1", "1.0+dfsg-1", -1},
+		{compareDebian, "1:0.9-1", "2.0-1", 1},
+		{compareDebian, "1.10-1", "1.9-1", 1},
+		{compareDebian, "1.0a", "1.0+", -1},
+		{compareDebian, "1.01", "1.1", 0},
+
+		{compareRPM, "5.2.26-3.fc40", "5.2.26-10.fc40", -1},
+		{compareRPM, "1:1.0-1.el9", "2.0-1.el9", 1},
+		{compareRPM, "1.0~rc1-1", "1.0-1", -1},
+		{compareRPM, "1.0^git1-1", "1.0-1", 1},
+		{compareRPM, "1.0-1", "1.0", 0},
+		{compareRPM, "1.0a-1", "1.0.1-1", -1},
+		{compareRPM, "2.el9_1", "2.el9", 1},
+
+		{compareAlpine, "1.36.1-r15", "1.36.1-r2", 1},
+		{compareAlpine, "1.2.3_rc1-r0", "1.2.3-r0", -1},
+		{compareAlpine, "1.2.3_p1-r0", "1.2.3-r0", 1},
+		{compareAlpine, "1.2.3a-r0", "1.2.3-r0", 1},
+		{compareAlpine, "1.2-r0", "1.2.1-r0", -1},
+		{compareAlpine, "3.0.12-r0", "3.0.12-r0", 0},
+
+		{compareSemver, "v1.2.3", "1.2.3", 0},
+		{compareSemver, "1.21.0-rc.2", "1.21.0", -1},
+		{compareSemver, "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{compareSemver, "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{compareSemver, "v0.0.0-20240101000000-abcdef", "0.17.0", -1},
+		{compareSemver, "v2.0.0+incompatible", "2.0.0", 0},
+		{compareSemver, "1.10.0", "1.9.9", 1},
+
+		{comparePyPI, "2.31.0", "2.31", 0},
+		{comparePyPI, "1.0.dev1", "1.0a1", -1},
+		{comparePyPI, "1.0a1", "1.0b1", -1},
+		{comparePyPI, "1.0rc1", "1.0", -1},
+		{comparePyPI, "1.0", "1.0.post1", -1},
+		{comparePyPI, "1.0.post1.dev1", "1.0.post1", -1},
+		{comparePyPI, "1!0.1", "2.0", 1},
+		{comparePyPI, "1.0-1", "1.0.post1", 0},
+	} {
+		require.Equal(t, tc.want, tc.cmp(tc.a, tc.b), "%s vs %s", tc.a, tc.b)
+		require.Equal(t, -tc.want, tc.cmp(tc.b, tc.a), "%s vs %s", tc.b, tc.a)
+	}
+}
@@ -0,0 +1,52 @@
+package sbom
+
+import (
+	"strings"
+)
+
+// parseApkInstalled reads the packages in Alpine's installed database, which
+// has a paragraph of single letter fields per package.
+func parseApkInstalled(name string, contents []byte, distro *Distro) ([]Package, error) {
+	pkgs := []Package{}
+
+	fields := map[string]string{}
+	flush := func() {
+		if fields["P"] != "" && fields["V"] != "" {
+			// o: is the origin, the aport a subpackage was built from
+			source := fields["o"]
+			if source == fields["P"] {
+				source = ""
+			}
+
+			pkgs = append(pkgs, Package{
+				Name:    fields["P"],
+				Version: fields["V"],
+				Type:    "apk",
+				Source:  source,
+				License: fields["L"],
+				PURL: purl("apk", distroNamespace(distro, "alpine"), fields["P"], fields["V"], map[string]string{
+					"arch":   fields["A"],
+					"distro": distroQualifier(distro),
+				}),
+			})
+		}
+		fields = map[string]string{}
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			flush()
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		// F: and R: repeat for every file, so keep just the package fields
+		if ok && len(key) == 1 && fields[key] == "" {
+			fields[key] = value
+		}
+	}
+	flush()
+
+	return pkgs, nil
+}
@@ -0,0 +1,106 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Berkeley DB page types and hash item types, from db_page.h
+const (
+	bdbHashMagic = 0x061561
+
+	bdbPageHashUnsorted = 2
+	bdbPageHash         = 13
+
+	bdbItemOffPage = 3
+
+	bdbPageHeaderSize = 26
+)
+
+// berkeleyHashValues calls fn with every value of a Berkeley DB hash
+// database that is stored on overflow pages, which is where rpm's headers
+// always end up. Values small enough to be stored inline, like the counter
+// rpm keeps under key 0, are skipped.
+func berkeleyHashValues(data []byte, fn func(value []byte) error) error {
+	if len(data) < 512 {
+		return errors.New("not a Berkeley DB database")
+	}
+
+	// the metadata page is in the byte order of the machine that wrote it
+	var order binary.ByteOrder = binary.LittleEndian
+	if order.Uint32(data[12:]) != bdbHashMagic {
+		order = binary.BigEndian
+		if order.Uint32(data[12:]) != bdbHashMagic {
+			return errors.New("not a Berkeley DB hash database")
+		}
+	}
+
+	pageSize := int(order.Uint32(data[20:]))
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return fmt.Errorf("invalid Berkeley DB page size %d", pageSize)
+	}
+	lastPage := int(order.Uint32(data[32:]))
+
+	page := func(n int) ([]byte, error) {
+		start := n * pageSize
+		if n < 0 || n > lastPage || start+pageSize > len(data) {
+			return nil, fmt.Errorf("Berkeley DB page %d out of range", n)
+		}
+		return data[start : start+pageSize], nil
+	}
+
+	for n := 1; n <= lastPage; n++ {
+		p, err := page(n)
+		if err != nil {
+			return err
+		}
+		if p[25] != bdbPageHash && p[25] != bdbPageHashUnsorted {
+			continue
+		}
+
+		// items alternate between keys and values
+		entries := int(order.Uint16(p[20:]))
+		if bdbPageHeaderSize+entries*2 > len(p) {
+			return fmt.Errorf("Berkeley DB page %d has too many entries", n)
+		}
+		for i := 1; i < entries; i += 2 {
+			off := int(order.Uint16(p[bdbPageHeaderSize+i*2:]))
+			if off+12 > len(p) || p[off] != bdbItemOffPage {
+				continue
+			}
+
+			next := int(order.Uint32(p[off+4:]))
+			length := int(order.Uint32(p[off+8:]))
+			if length > len(data) {
+				return fmt.Errorf("Berkeley DB value of %d bytes out of range", length)
+			}
+
+			value := make([]byte, 0, length)
+			for len(value) < length {
+				if next == 0 {
+					return errors.New("Berkeley DB overflow chain ends early")
+				}
+				overflow, err := page(next)
+				if err != nil {
+					return err
+				}
+
+				// an overflow page's hf_offset is how much of it is used
+				used := int(order.Uint16(overflow[22:]))
+				if bdbPageHeaderSize+used > len(overflow) || used == 0 {
+					return fmt.Errorf("Berkeley DB overflow page %d is corrupt", next)
+				}
+				value = append(value, overflow[bdbPageHeaderSize:bdbPageHeaderSize+used]...)
+				next = int(order.Uint32(overflow[16:]))
+			}
+
+			err = fn(value[:length])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
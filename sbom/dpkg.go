@@ -0,0 +1,72 @@
+package sbom
+
+import (
+	"strings"
+)
+
+// parseDpkgStatus reads the packages in a dpkg status file, or a file of
+// status.d, which is the same format without the Status field.
+func parseDpkgStatus(name string, contents []byte, distro *Distro) ([]Package, error) {
+	pkgs := []Package{}
+
+	for _, para := range parseControl(string(contents)) {
+		if para["Package"] == "" || para["Version"] == "" {
+			continue
+		}
+
+		// removed packages can linger with their config files
+		if status, ok := para["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+
+		// Source may have the source version in parentheses, when it
+		// differs from the binary's
+		source, _, _ := strings.Cut(para["Source"], " ")
+		if source == para["Package"] {
+			source = ""
+		}
+
+		pkgs = append(pkgs, Package{
+			Name:    para["Package"],
+			Version: para["Version"],
+			Type:    "deb",
+			Source:  source,
+			PURL: purl("deb", distroNamespace(distro, "debian"), para["Package"], para["Version"], map[string]string{
+				"arch":   para["Architecture"],
+				"distro": distroQualifier(distro),
+			}),
+		})
+	}
+
+	return pkgs, nil
+}
+
+// parseControl splits a Debian control file into paragraphs of fields,
+// keeping only the first line of multi-line fields.
+func parseControl(contents string) []map[string]string {
+	paras := []map[string]string{}
+	para := map[string]string{}
+
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			if len(para) > 0 {
+				paras = append(paras, para)
+				para = map[string]string{}
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			// continuation of the previous field
+		default:
+			key, value, ok := strings.Cut(line, ":")
+			if ok {
+				para[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if len(para) > 0 {
+		paras = append(paras, para)
+	}
+
+	return paras
+}
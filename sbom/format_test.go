@@ -0,0 +1,58 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDocuments(t *testing.T) {
+	doc := &Document{
+		Repo:    "index.docker.io/library/debian",
+		Digest:  "sha256:abcd",
+		Created: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Catalog: &Catalog{
+			Distro: &Distro{ID: "debian", VersionID: "12"},
+			Packages: []Package{
+				{Name: "libc6", Version: "2.36-9", Type: "deb", PURL: "pkg:deb/debian/libc6@2.36-9", Location: "var/lib/dpkg/status"},
+				{Name: "left-pad", Version: "1.3.0", Type: "npm", PURL: "pkg:npm/left-pad@1.3.0", License: "WTFPL", Location: "node_modules/left-pad/package.json"},
+			},
+		},
+	}
+	require.Equal(t, "pkg:oci/debian@sha256%3Aabcd?repository_url=index.docker.io%2Flibrary%2Fdebian", doc.imagePURL())
+	require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, doc.uuid())
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteSPDX(buf, doc))
+
+	spdx := spdxDocument{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &spdx))
+	require.Equal(t, "SPDX-2.3", spdx.SPDXVersion)
+	require.Equal(t, "2024-05-01T12:00:00Z", spdx.CreationInfo.Created)
+	require.Equal(t, "https://spdx.org/spdxdocs/browseimage-"+doc.uuid(), spdx.DocumentNamespace)
+	require.Len(t, spdx.Packages, 3)
+	require.Equal(t, "SPDXRef-Package-2", spdx.Packages[2].SPDXID)
+	require.Equal(t, "declared license: WTFPL", spdx.Packages[2].LicenseComments)
+	require.Equal(t, "pkg:npm/left-pad@1.3.0", spdx.Packages[2].ExternalRefs[0].ReferenceLocator)
+	require.Equal(t, []spdxRelationship{
+		{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: "SPDXRef-Image"},
+		{SPDXElementID: "SPDXRef-Image", RelationshipType: "CONTAINS", RelatedSPDXElement: "SPDXRef-Package-1"},
+		{SPDXElementID: "SPDXRef-Image", RelationshipType: "CONTAINS", RelatedSPDXElement: "SPDXRef-Package-2"},
+	}, spdx.Relationships)
+
+	buf.Reset()
+	require.NoError(t, WriteCycloneDX(buf, doc))
+
+	cdx := cycloneDXDocument{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &cdx))
+	require.Equal(t, "urn:uuid:"+doc.uuid(), cdx.SerialNumber)
+	require.Equal(t, "container", cdx.Metadata.Component.Type)
+	require.Len(t, cdx.Components, 3)
+	require.Equal(t, "operating-system", cdx.Components[0].Type)
+	require.Equal(t, "pkg:npm/left-pad@1.3.0", cdx.Components[2].PURL)
+	require.Equal(t, "WTFPL", cdx.Components[2].Licenses[0].License.Name)
+	require.Equal(t, []cycloneDXProperty{{Name: "browseimage:location", Value: "/node_modules/left-pad/package.json"}}, cdx.Components[2].Properties)
+}
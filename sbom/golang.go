@@ -0,0 +1,61 @@
+package sbom
+
+import (
+	"browseimage/layerreader"
+	"context"
+	"debug/buildinfo"
+	"path"
+	"strings"
+)
+
+// goBlockSize is how much of a binary each read fetches. Build info is read
+// from a handful of places, headers and the build info section, so small
+// blocks avoid decompressing most of the file.
+const goBlockSize = 64 << 10
+
+// readGoBinary finds the modules a Go binary was built from in its build
+// info, or nothing if it isn't a Go binary.
+func readGoBinary(ctx context.Context, read layerreader.FileReader, e *layerreader.EntryWithLayer) ([]Package, error) {
+	ra := layerreader.NewReaderAt(ctx, read, e, goBlockSize)
+
+	// only the read errors matter, as buildinfo also fails on anything that
+	// isn't a Go binary
+	info, err := buildinfo.Read(ra)
+	if ra.Err() != nil {
+		return nil, ra.Err()
+	} else if err != nil {
+		return nil, nil
+	}
+
+	pkgs := []Package{{
+		Name:    "stdlib",
+		Version: info.GoVersion,
+		Type:    "golang",
+		PURL:    purl("golang", "", "stdlib", info.GoVersion, nil),
+	}}
+
+	if info.Main.Path != "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		pkgs = append(pkgs, goModule(info.Main.Path, info.Main.Version))
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		// replacements with local directories have no version
+		if dep.Version != "" {
+			pkgs = append(pkgs, goModule(dep.Path, dep.Version))
+		}
+	}
+
+	return pkgs, nil
+}
+
+func goModule(modPath, version string) Package {
+	namespace, name := path.Split(modPath)
+	return Package{
+		Name:    modPath,
+		Version: version,
+		Type:    "golang",
+		PURL:    purl("golang", strings.TrimSuffix(namespace, "/"), name, version, nil),
+	}
+}
@@ -0,0 +1,43 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// parsePackageJSON reads the package installed in a node_modules directory.
+func parsePackageJSON(name string, contents []byte, distro *Distro) ([]Package, error) {
+	var pj struct {
+		Name    string
+		Version string
+		// usually a string, but old packages have {"type": ...}
+		License json.RawMessage
+	}
+	err := json.Unmarshal(contents, &pj)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling package.json: %w", err)
+	}
+	if pj.Name == "" || pj.Version == "" {
+		return nil, nil
+	}
+
+	license := ""
+	var typed struct{ Type string }
+	if json.Unmarshal(pj.License, &license) != nil && json.Unmarshal(pj.License, &typed) == nil {
+		license = typed.Type
+	}
+
+	namespace, pkgName := "", pj.Name
+	if strings.HasPrefix(pj.Name, "@") {
+		namespace, pkgName, _ = strings.Cut(pj.Name, "/")
+	}
+
+	return []Package{{
+		Name:    pj.Name,
+		Version: pj.Version,
+		Type:    "npm",
+		License: license,
+		PURL:    purl("npm", namespace, pkgName, pj.Version, nil),
+	}}, nil
+}
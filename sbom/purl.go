@@ -0,0 +1,79 @@
+package sbom
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// purl builds a package URL (https://github.com/package-url/purl-spec). The
+// namespace may contain slashes, which separate its segments.
+func purl(typ, namespace, name, version string, qualifiers map[string]string) string {
+	sb := &strings.Builder{}
+	sb.WriteString("pkg:" + typ + "/")
+
+	if namespace != "" {
+		for _, segment := range strings.Split(namespace, "/") {
+			sb.WriteString(purlEscape(segment) + "/")
+		}
+	}
+	sb.WriteString(purlEscape(name))
+
+	if version != "" {
+		sb.WriteString("@" + purlEscape(version))
+	}
+
+	keys := []string{}
+	for k, v := range qualifiers {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		sep := "&"
+		if i == 0 {
+			sep = "?"
+		}
+		sb.WriteString(sep + k + "=" + purlEscape(qualifiers[k]))
+	}
+
+	return sb.String()
+}
+
+// purlEscape percent-encodes everything but the characters purls allow
+// unencoded in a component.
+func purlEscape(s string) string {
+	sb := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte(".-_~", c) >= 0:
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// distroQualifier is the purl qualifier for the distro a package is built
+// for, like "debian-12".
+func distroQualifier(d *Distro) string {
+	if d == nil {
+		return ""
+	}
+	if d.VersionID == "" {
+		return d.ID
+	}
+	return d.ID + "-" + d.VersionID
+}
+
+// distroNamespace is the purl namespace of an OS package, which is its
+// distro, or fallback if that isn't known.
+func distroNamespace(d *Distro, fallback string) string {
+	if d == nil {
+		return fallback
+	}
+	return d.ID
+}
@@ -0,0 +1,64 @@
+package sbom
+
+import (
+	"regexp"
+	"strings"
+)
+
+// parsePythonMetadata reads the package an installed distribution's METADATA
+// or PKG-INFO describes, from the headers before its description.
+func parsePythonMetadata(name string, contents []byte, distro *Distro) ([]Package, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if ok && fields[key] == "" {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+
+	if fields["Name"] == "" || fields["Version"] == "" {
+		return nil, nil
+	}
+
+	license := fields["License-Expression"]
+	if license == "" && fields["License"] != "UNKNOWN" {
+		license = fields["License"]
+	}
+
+	return []Package{pypiPackage(fields["Name"], fields["Version"], license)}, nil
+}
+
+// requirementPin matches requirements that pin an exact version, which are
+// the only ones that say what is installed.
+var requirementPin = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*===?\s*([^\s;#,]+)`)
+
+// parseRequirements reads the pinned packages of a pip requirements file.
+func parseRequirements(name string, contents []byte, distro *Distro) ([]Package, error) {
+	pkgs := []Package{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		m := requirementPin.FindStringSubmatch(strings.TrimSpace(line))
+		if m != nil {
+			pkgs = append(pkgs, pypiPackage(m[1], m[3], ""))
+		}
+	}
+	return pkgs, nil
+}
+
+// pypiSeparators are alike in PyPI names, which are also case insensitive
+var pypiSeparators = regexp.MustCompile(`[-_.]+`)
+
+func pypiPackage(name, version, license string) Package {
+	normalized := strings.ToLower(pypiSeparators.ReplaceAllString(name, "-"))
+	return Package{
+		Name:    name,
+		Version: version,
+		Type:    "pypi",
+		License: license,
+		PURL:    purl("pypi", "", normalized, version, nil),
+	}
+}
@@ -0,0 +1,150 @@
+package sbom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// RPM header tags and types, from rpmtag.h
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagLicense = 1014
+	rpmTagArch    = 1022
+
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// parseRpmSqlite reads the packages in the sqlite rpmdb of newer Fedora and
+// RHEL releases.
+func parseRpmSqlite(name string, contents []byte, distro *Distro) ([]Package, error) {
+	db, err := openSQLite(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgs := []Package{}
+	err = db.tableRows("Packages", func(cols []any) error {
+		if len(cols) < 2 {
+			return fmt.Errorf("unexpected Packages row of %d columns", len(cols))
+		}
+		blob, ok := cols[1].([]byte)
+		if !ok {
+			return fmt.Errorf("unexpected Packages blob of type %T", cols[1])
+		}
+
+		p, err := parseRpmHeader(blob, distro)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			pkgs = append(pkgs, *p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pkgs, nil
+}
+
+// parseRpmBerkeley reads the packages in the Berkeley DB rpmdb of older
+// releases, like CentOS 7 and Amazon Linux 2.
+func parseRpmBerkeley(name string, contents []byte, distro *Distro) ([]Package, error) {
+	pkgs := []Package{}
+	err := berkeleyHashValues(contents, func(value []byte) error {
+		p, err := parseRpmHeader(value, distro)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			pkgs = append(pkgs, *p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pkgs, nil
+}
+
+// parseRpmHeader reads a package from an RPM header as rpmdb stores it: the
+// index and data lengths, then the index entries and the data they point
+// into. It returns nil for the public keys rpm keeps as pseudo-packages.
+func parseRpmHeader(blob []byte, distro *Distro) (*Package, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("rpm header too short")
+	}
+	il := int(binary.BigEndian.Uint32(blob[0:]))
+	dl := int(binary.BigEndian.Uint32(blob[4:]))
+
+	indexEnd := 8 + il*16
+	if il < 0 || dl < 0 || il > 1<<20 || indexEnd+dl > len(blob) {
+		return nil, fmt.Errorf("rpm header lengths out of range")
+	}
+	data := blob[indexEnd : indexEnd+dl]
+
+	strs := map[uint32]string{}
+	var epoch string
+	for i := 0; i < il; i++ {
+		entry := blob[8+i*16:]
+		tag := binary.BigEndian.Uint32(entry[0:])
+		typ := binary.BigEndian.Uint32(entry[4:])
+		offset := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || offset >= len(data) {
+			continue
+		}
+
+		switch tag {
+		case rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagLicense, rpmTagArch:
+			if typ != rpmTypeString && typ != rpmTypeStringArray && typ != rpmTypeI18NString {
+				continue
+			}
+			end := bytes.IndexByte(data[offset:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string for rpm tag %d", tag)
+			}
+			strs[tag] = string(data[offset : offset+end])
+		case rpmTagEpoch:
+			if typ == rpmTypeInt32 && offset+4 <= len(data) {
+				epoch = strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[offset:])), 10)
+			}
+		}
+	}
+
+	name := strs[rpmTagName]
+	if name == "" || name == "gpg-pubkey" {
+		return nil, nil
+	}
+
+	version := strs[rpmTagVersion]
+	if release := strs[rpmTagRelease]; release != "" {
+		version += "-" + release
+	}
+
+	p := &Package{
+		Name:    name,
+		Version: version,
+		Type:    "rpm",
+		License: strs[rpmTagLicense],
+		PURL: purl("rpm", distroNamespace(distro, "redhat"), name, version, map[string]string{
+			"arch":   strs[rpmTagArch],
+			"epoch":  epoch,
+			"distro": distroQualifier(distro),
+		}),
+	}
+	if epoch != "" && epoch != "0" {
+		p.Version = epoch + ":" + version
+	}
+
+	return p, nil
+}
@@ -0,0 +1,230 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rpmHeader builds a header blob as rpmdb stores it, with strs as string
+// tags and epoch as an int32 tag if it isn't negative.
+func rpmHeader(strs map[uint32]string, epoch int, padding int) []byte {
+	index := []byte{}
+	data := []byte{}
+	entry := func(tag, typ uint32, value []byte) {
+		index = binary.BigEndian.AppendUint32(index, tag)
+		index = binary.BigEndian.AppendUint32(index, typ)
+		index = binary.BigEndian.AppendUint32(index, uint32(len(data)))
+		index = binary.BigEndian.AppendUint32(index, 1)
+		data = append(data, value...)
+	}
+
+	for _, tag := range []uint32{rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagLicense, rpmTagArch} {
+		if s, ok := strs[tag]; ok {
+			entry(tag, rpmTypeString, append([]byte(s), 0))
+		}
+	}
+	if epoch >= 0 {
+		entry(rpmTagEpoch, rpmTypeInt32, binary.BigEndian.AppendUint32(nil, uint32(epoch)))
+	}
+	data = append(data, make([]byte, padding)...)
+
+	blob := binary.BigEndian.AppendUint32(nil, uint32(len(index)/16))
+	blob = binary.BigEndian.AppendUint32(blob, uint32(len(data)))
+	return append(append(blob, index...), data...)
+}
+
+func bashHeader(padding int) []byte {
+	return rpmHeader(map[uint32]string{
+		rpmTagName:    "bash",
+		rpmTagVersion: "5.2.26",
+		rpmTagRelease: "3.fc40",
+		rpmTagLicense: "GPL-3.0-or-later",
+		rpmTagArch:    "x86_64",
+	}, 1, padding)
+}
+
+var fedora = &Distro{ID: "fedora", VersionID: "40"}
+
+var bash = Package{
+	Name:    "bash",
+	Version: "1:5.2.26-3.fc40",
+	Type:    "rpm",
+	License: "GPL-3.0-or-later",
+	PURL:    "pkg:rpm/fedora/bash@5.2.26-3.fc40?arch=x86_64&distro=fedora-40&epoch=1",
+}
+
+func TestParseRpmHeader(t *testing.T) {
+	p, err := parseRpmHeader(bashHeader(0), fedora)
+	require.NoError(t, err)
+	require.Equal(t, &bash, p)
+
+	p, err = parseRpmHeader(rpmHeader(map[uint32]string{rpmTagName: "gpg-pubkey", rpmTagVersion: "a15b79cc"}, -1, 0), fedora)
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	p, err = parseRpmHeader(rpmHeader(map[uint32]string{rpmTagName: "zlib", rpmTagVersion: "1.2.7", rpmTagRelease: "21.el7_9"}, 0, 0), nil)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.7-21.el7_9", p.Version)
+	require.Equal(t, "pkg:rpm/redhat/zlib@1.2.7-21.el7_9?epoch=0", p.PURL)
+
+	_, err = parseRpmHeader(bashHeader(0)[:40], fedora)
+	require.Error(t, err)
+}
+
+func TestBerkeleyHashValues(t *testing.T) {
+	const pageSize = 512
+	value := bashHeader(600) // needs two overflow pages
+	le := binary.LittleEndian
+
+	db := make([]byte, 5*pageSize)
+	page := func(n int) []byte { return db[n*pageSize : (n+1)*pageSize] }
+
+	meta := page(0)
+	le.PutUint32(meta[12:], bdbHashMagic)
+	le.PutUint32(meta[20:], pageSize)
+	le.PutUint32(meta[32:], 4)
+
+	// a key inline, then its value on overflow pages 2 and 3
+	hash := page(1)
+	hash[25] = bdbPageHash
+	le.PutUint16(hash[20:], 2)
+	le.PutUint16(hash[bdbPageHeaderSize:], 400)
+	le.PutUint16(hash[bdbPageHeaderSize+2:], 450)
+	hash[400] = 1 // H_KEYDATA
+	hash[450] = bdbItemOffPage
+	le.PutUint32(hash[450+4:], 2)
+	le.PutUint32(hash[450+8:], uint32(len(value)))
+
+	rest := value
+	for n := 2; n <= 3; n++ {
+		p := page(n)
+		p[25] = 7 // P_OVERFLOW
+		chunk := rest[:min(len(rest), pageSize-bdbPageHeaderSize)]
+		rest = rest[len(chunk):]
+		copy(p[bdbPageHeaderSize:], chunk)
+		le.PutUint16(p[22:], uint16(len(chunk)))
+		if len(rest) > 0 {
+			le.PutUint32(p[16:], uint32(n+1))
+		}
+	}
+	require.Empty(t, rest)
+
+	pkgs, err := parseRpmBerkeley("var/lib/rpm/Packages", db, fedora)
+	require.NoError(t, err)
+	require.Equal(t, []Package{bash}, pkgs)
+
+	_, err = parseRpmBerkeley("var/lib/rpm/Packages", make([]byte, 1024), fedora)
+	require.Error(t, err)
+}
+
+// sqliteTestDB builds an SQLite database of 512-byte pages with a Packages
+// table, whose rows are on page 2 and overflow onto pages after it.
+func sqliteTestDB(blobs ...[]byte) []byte {
+	const pageSize = 512
+	pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+
+	varint := func(b []byte, v int) []byte {
+		if v < 0x80 {
+			return append(b, byte(v))
+		}
+		return append(b, byte(v>>7|0x80), byte(v&0x7f))
+	}
+	record := func(cols ...any) []byte {
+		hdr, body := []byte{}, []byte{}
+		for _, c := range cols {
+			switch c := c.(type) {
+			case nil:
+				hdr = varint(hdr, 0)
+			case int:
+				hdr = varint(hdr, 1)
+				body = append(body, byte(c))
+			case string:
+				hdr = varint(hdr, 13+2*len(c))
+				body = append(body, c...)
+			case []byte:
+				hdr = varint(hdr, 12+2*len(c))
+				body = append(body, c...)
+			}
+		}
+		return append(varint(nil, len(hdr)+1), append(hdr, body...)...)
+	}
+
+	// fill writes cells at the end of a leaf table page, spilling onto
+	// overflow pages as the file format describes
+	fill := func(n int, hdrOffset int, payloads [][]byte) {
+		p := pages[n-1]
+		p[hdrOffset] = 0x0d
+		binary.BigEndian.PutUint16(p[hdrOffset+3:], uint16(len(payloads)))
+		end := pageSize
+		for i, payload := range payloads {
+			local := len(payload)
+			maxLocal, minLocal := pageSize-35, (pageSize-12)*32/255-23
+			if local > maxLocal {
+				local = minLocal + (len(payload)-minLocal)%(pageSize-4)
+				if local > maxLocal {
+					local = minLocal
+				}
+			}
+
+			cell := varint(varint(nil, len(payload)), i+1)
+			cell = append(cell, payload[:local]...)
+			if rest := payload[local:]; len(rest) > 0 {
+				cell = binary.BigEndian.AppendUint32(cell, uint32(len(pages)+1))
+				for len(rest) > 0 {
+					overflow := make([]byte, pageSize)
+					chunk := rest[:min(len(rest), pageSize-4)]
+					rest = rest[len(chunk):]
+					copy(overflow[4:], chunk)
+					if len(rest) > 0 {
+						binary.BigEndian.PutUint32(overflow, uint32(len(pages)+2))
+					}
+					pages = append(pages, overflow)
+				}
+			}
+
+			end -= len(cell)
+			copy(p[end:], cell)
+			binary.BigEndian.PutUint16(p[hdrOffset+8+i*2:], uint16(end))
+		}
+		binary.BigEndian.PutUint16(p[hdrOffset+5:], uint16(end))
+	}
+
+	sql := "CREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL)"
+	fill(1, 100, [][]byte{record("table", "Packages", "Packages", 2, sql)})
+
+	rows := [][]byte{}
+	for _, blob := range blobs {
+		rows = append(rows, record(nil, blob))
+	}
+	fill(2, 0, rows)
+
+	db := []byte{}
+	for _, p := range pages {
+		db = append(db, p...)
+	}
+	copy(db, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(db[16:], pageSize)
+	db[18], db[19], db[21], db[22], db[23] = 1, 1, 64, 32, 32
+	binary.BigEndian.PutUint32(db[28:], uint32(len(pages)))
+	binary.BigEndian.PutUint32(db[44:], 4)
+	binary.BigEndian.PutUint32(db[56:], 1)
+	return db
+}
+
+func TestParseRpmSqlite(t *testing.T) {
+	zlib := rpmHeader(map[uint32]string{rpmTagName: "zlib", rpmTagVersion: "1.3.1", rpmTagRelease: "1.fc40"}, -1, 0)
+
+	db := sqliteTestDB(zlib, bashHeader(1200))
+	pkgs, err := parseRpmSqlite("var/lib/rpm/rpmdb.sqlite", db, fedora)
+	require.NoError(t, err)
+	require.Equal(t, []Package{
+		{Name: "zlib", Version: "1.3.1-1.fc40", Type: "rpm", PURL: "pkg:rpm/fedora/zlib@1.3.1-1.fc40?distro=fedora-40"},
+		bash,
+	}, pkgs)
+
+	_, err = parseRpmSqlite("var/lib/rpm/rpmdb.sqlite", []byte(strings.Repeat("x", 1024)), fedora)
+	require.Error(t, err)
+}
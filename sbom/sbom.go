@@ -0,0 +1,242 @@
+// Package sbom finds the software packages installed in an image, from the
+// databases of OS package managers, language package metadata and the build
+// info of Go binaries, and writes them as SPDX or CycloneDX documents.
+package sbom
+
+import (
+	"archive/tar"
+	"browseimage/layerreader"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// minGoBinarySize skips the many small executables of a distro, which
+	// are rarely Go and would each cost a request to check
+	minGoBinarySize = 1 << 20
+	maxGoBinaries   = 256
+	concurrency     = 8
+)
+
+type Package struct {
+	Name    string
+	Version string
+	// Type is the purl type: deb, apk, rpm, golang, npm or pypi
+	Type string
+	PURL string
+	// Source is the source package an OS package was built from, if it
+	// isn't named the same, which is what distros' advisories are about
+	Source  string `json:",omitempty"`
+	License string `json:",omitempty"`
+	// Location is the file the package was found in
+	Location string
+}
+
+// Distro is the operating system of an image, from its os-release file.
+type Distro struct {
+	ID         string
+	VersionID  string `json:",omitempty"`
+	PrettyName string `json:",omitempty"`
+}
+
+// Catalog is every package found in an image.
+type Catalog struct {
+	Distro   *Distro `json:",omitempty"`
+	Packages []Package
+}
+
+// parser finds the packages in the contents of a file.
+type parser func(name string, contents []byte, distro *Distro) ([]Package, error)
+
+// source is how to find packages in a kind of file.
+type source struct {
+	parse   parser
+	maxSize int64
+}
+
+// sourceFor returns how to find packages in the file named name, if it is one
+// that describes packages.
+func sourceFor(name string) (source, bool) {
+	base := path.Base(name)
+	dir := path.Dir(name)
+
+	switch {
+	case name == "var/lib/dpkg/status" || dir == "var/lib/dpkg/status.d" && !strings.Contains(base, "."):
+		// distroless images have a file per package in status.d
+		return source{parse: parseDpkgStatus, maxSize: 64 << 20}, true
+	case name == "lib/apk/db/installed":
+		return source{parse: parseApkInstalled, maxSize: 64 << 20}, true
+	case name == "var/lib/rpm/rpmdb.sqlite" || name == "usr/lib/sysimage/rpm/rpmdb.sqlite":
+		return source{parse: parseRpmSqlite, maxSize: 256 << 20}, true
+	case name == "var/lib/rpm/Packages" || name == "usr/lib/sysimage/rpm/Packages":
+		return source{parse: parseRpmBerkeley, maxSize: 256 << 20}, true
+	case base == "package.json" && strings.Contains("/"+dir+"/", "/node_modules/"):
+		return source{parse: parsePackageJSON, maxSize: 1 << 20}, true
+	case base == "METADATA" && strings.HasSuffix(dir, ".dist-info"), base == "PKG-INFO" && strings.HasSuffix(dir, ".egg-info"):
+		return source{parse: parsePythonMetadata, maxSize: 1 << 20}, true
+	case strings.HasPrefix(base, "requirements") && strings.HasSuffix(base, ".txt"):
+		return source{parse: parseRequirements, maxSize: 1 << 20}, true
+	}
+
+	return source{}, false
+}
+
+// Find catalogs the packages described by entries, which should be every
+// entry of an image's merged filesystem. Files that can't be parsed are
+// skipped, but failing to read them is an error.
+func Find(ctx context.Context, entries []*layerreader.EntryWithLayer, read layerreader.FileReader) (*Catalog, error) {
+	byName := map[string]*layerreader.EntryWithLayer{}
+	for _, e := range entries {
+		byName[strings.TrimPrefix(e.Hdr.Name, "/")] = e
+	}
+
+	catalog := &Catalog{Packages: []Package{}}
+	for _, name := range []string{"etc/os-release", "usr/lib/os-release"} {
+		e := byName[name]
+		if e == nil || e.Hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		contents, err := readAll(ctx, read, e, 1<<20)
+		if err != nil {
+			return nil, err
+		}
+		catalog.Distro = parseOSRelease(contents)
+		break
+	}
+
+	type job struct {
+		e      *layerreader.EntryWithLayer
+		source source
+		goBin  bool
+	}
+	jobs := []job{}
+	goBinaries := 0
+	for _, e := range entries {
+		if e.Hdr.Typeflag != tar.TypeReg || e.Hdr.Size == 0 {
+			continue
+		}
+
+		name := strings.TrimPrefix(e.Hdr.Name, "/")
+		if src, ok := sourceFor(name); ok {
+			if e.Hdr.Size > src.maxSize {
+				slog.WarnContext(ctx, "skipping oversized package file", "name", name, "size", e.Hdr.Size)
+				continue
+			}
+			jobs = append(jobs, job{e: e, source: src})
+		} else if e.Hdr.Mode&0o111 != 0 && e.Hdr.Size >= minGoBinarySize && goBinaries < maxGoBinaries {
+			jobs = append(jobs, job{e: e, goBin: true})
+			goBinaries++
+		}
+	}
+
+	results := make([][]Package, len(jobs))
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+
+	for i, j := range jobs {
+		sem <- struct{}{}
+		wg.Add(1)
+
+		go func(i int, j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			name := strings.TrimPrefix(j.e.Hdr.Name, "/")
+			var err error
+			if j.goBin {
+				results[i], err = readGoBinary(ctx, read, j.e)
+			} else {
+				var contents []byte
+				contents, err = readAll(ctx, read, j.e, j.source.maxSize)
+				if err == nil {
+					results[i], err = j.source.parse(name, contents, catalog.Distro)
+					if err != nil {
+						slog.WarnContext(ctx, "parsing package file", "name", name, "error", err)
+						err = nil
+					}
+				}
+			}
+			errs[i] = err
+		}(i, j)
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, pkgs := range results {
+		for _, p := range pkgs {
+			p.Location = strings.TrimPrefix(jobs[i].e.Hdr.Name, "/")
+			if seen[p.PURL+"\x00"+p.Location] {
+				continue
+			}
+			seen[p.PURL+"\x00"+p.Location] = true
+			catalog.Packages = append(catalog.Packages, p)
+		}
+	}
+
+	sort.SliceStable(catalog.Packages, func(i, j int) bool {
+		a, b := catalog.Packages[i], catalog.Packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Location < b.Location
+	})
+
+	return catalog, nil
+}
+
+func readAll(ctx context.Context, read layerreader.FileReader, e *layerreader.EntryWithLayer, maxSize int64) ([]byte, error) {
+	body, err := read(ctx, e, 0, min(e.Hdr.Size, maxSize))
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", e.Hdr.Name, err)
+	}
+	defer body.Close()
+
+	contents, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", e.Hdr.Name, err)
+	}
+	return contents, nil
+}
+
+// parseOSRelease reads the os-release(5) fields that identify a distro.
+func parseOSRelease(contents []byte) *Distro {
+	d := &Distro{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+
+		switch key {
+		case "ID":
+			d.ID = value
+		case "VERSION_ID":
+			d.VersionID = value
+		case "PRETTY_NAME":
+			d.PrettyName = value
+		}
+	}
+
+	if d.ID == "" {
+		return nil
+	}
+	return d
+}
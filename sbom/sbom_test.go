@@ -0,0 +1,115 @@
+package sbom
+
+import (
+	"archive/tar"
+	"browseimage/layerreader"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+
+	files := map[string]string{
+		"etc/os-release": "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n",
+		"var/lib/dpkg/status": `Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 12.4+deb12u5
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: libc6
+Status: install ok installed
+Source: glibc (2.36-9+deb12u4)
+Architecture: amd64
+Description: GNU C Library
+ continued description
+Version: 2.36-9+deb12u4
+`,
+		"var/lib/dpkg/status.d/tzdata":                                      "Package: tzdata\nArchitecture: all\nVersion: 2024a-0+deb12u1\n",
+		"var/lib/dpkg/status.d/tzdata.md5sums":                              "not a package",
+		"usr/lib/node_modules/@scope/pkg/package.json":                      `{"name": "@scope/pkg", "version": "1.2.3", "license": "MIT"}`,
+		"usr/lib/node_modules/left-pad/package.json":                        `{"name": "left-pad", "version": "1.3.0", "license": {"type": "WTFPL"}}`,
+		"app/package.json":                                                  `{"name": "not-a-dependency", "version": "0.0.1"}`,
+		"usr/lib/python3/site-packages/Flask_Cors-4.0.0.dist-info/METADATA": "Metadata-Version: 2.1\nName: Flask_Cors\nVersion: 4.0.0\nLicense: UNKNOWN\n\nbody\n",
+		"app/requirements.txt":                                              "# pinned\nrequests==2.31.0\nflask>=2.0\nurllib3 === 2.2.1 ; python_version > '3'\n",
+		"usr/lib/node_modules/broken/package.json":                          `{not json`,
+	}
+
+	entries := []*layerreader.EntryWithLayer{}
+	for name, contents := range files {
+		entries = append(entries, &layerreader.EntryWithLayer{Entry: targzi.Entry{Hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Size: int64(len(contents))}}})
+	}
+	entries = append(entries, &layerreader.EntryWithLayer{Entry: targzi.Entry{Hdr: tar.Header{Name: "var/lib/dpkg/status.d/", Typeflag: tar.TypeDir}}})
+
+	read := func(ctx context.Context, e *layerreader.EntryWithLayer, offset, length int64) (io.ReadCloser, error) {
+		contents, ok := files[e.Hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("no %s", e.Hdr.Name)
+		}
+		return io.NopCloser(bytes.NewReader([]byte(contents)[offset : offset+length])), nil
+	}
+
+	catalog, err := Find(ctx, entries, read)
+	require.NoError(t, err)
+	require.Equal(t, &Distro{ID: "debian", VersionID: "12", PrettyName: "Debian GNU/Linux 12 (bookworm)"}, catalog.Distro)
+
+	purls := map[string]string{}
+	for _, p := range catalog.Packages {
+		purls[p.PURL] = p.Location
+	}
+	require.Equal(t, map[string]string{
+		"pkg:deb/debian/base-files@12.4%2Bdeb12u5?arch=amd64&distro=debian-12": "var/lib/dpkg/status",
+		"pkg:deb/debian/libc6@2.36-9%2Bdeb12u4?arch=amd64&distro=debian-12":    "var/lib/dpkg/status",
+		"pkg:deb/debian/tzdata@2024a-0%2Bdeb12u1?arch=all&distro=debian-12":    "var/lib/dpkg/status.d/tzdata",
+		"pkg:npm/%40scope/pkg@1.2.3":                                           "usr/lib/node_modules/@scope/pkg/package.json",
+		"pkg:npm/left-pad@1.3.0":                                               "usr/lib/node_modules/left-pad/package.json",
+		"pkg:pypi/flask-cors@4.0.0":                                            "usr/lib/python3/site-packages/Flask_Cors-4.0.0.dist-info/METADATA",
+		"pkg:pypi/requests@2.31.0":                                             "app/requirements.txt",
+		"pkg:pypi/urllib3@2.2.1":                                               "app/requirements.txt",
+	}, purls)
+
+	// sorted by type, then name
+	require.Equal(t, "base-files", catalog.Packages[0].Name)
+	require.Equal(t, "urllib3", catalog.Packages[len(catalog.Packages)-1].Name)
+
+	for _, p := range catalog.Packages {
+		switch p.Name {
+		case "left-pad":
+			require.Equal(t, "WTFPL", p.License)
+		case "Flask_Cors":
+			require.Empty(t, p.License)
+		case "libc6":
+			require.Equal(t, "glibc", p.Source)
+		case "base-files":
+			require.Empty(t, p.Source)
+		}
+	}
+}
+
+func TestParseApkInstalled(t *testing.T) {
+	contents := "C:Q1abc=\nP:musl\nV:1.2.4-r2\nA:x86_64\nL:MIT\no:musl\n\nP:busybox-binsh\nV:1.36.1-r15\nA:x86_64\nL:GPL-2.0-only\no:busybox\n"
+
+	pkgs, err := parseApkInstalled("lib/apk/db/installed", []byte(contents), &Distro{ID: "alpine", VersionID: "3.19.1"})
+	require.NoError(t, err)
+	require.Equal(t, []Package{
+		{Name: "musl", Version: "1.2.4-r2", Type: "apk", License: "MIT", PURL: "pkg:apk/alpine/musl@1.2.4-r2?arch=x86_64&distro=alpine-3.19.1"},
+		{Name: "busybox-binsh", Version: "1.36.1-r15", Type: "apk", Source: "busybox", License: "GPL-2.0-only", PURL: "pkg:apk/alpine/busybox-binsh@1.36.1-r15?arch=x86_64&distro=alpine-3.19.1"},
+	}, pkgs)
+}
+
+func TestPurl(t *testing.T) {
+	require.Equal(t, "pkg:golang/github.com/foo/bar@v1.0.0%2Bincompatible", purl("golang", "github.com/foo", "bar", "v1.0.0+incompatible", nil))
+	require.Equal(t, "pkg:rpm/fedora/bash@5.2.26-3.fc40?arch=x86_64&epoch=1", purl("rpm", "fedora", "bash", "5.2.26-3.fc40", map[string]string{"epoch": "1", "arch": "x86_64", "distro": ""}))
+	require.Equal(t, "pkg:generic/a%20b%2Fc", purl("generic", "", "a b/c", "", nil))
+}
@@ -0,0 +1,281 @@
+package sbom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sqliteDB reads the rows of tables straight from an SQLite database file
+// (https://www.sqlite.org/fileformat.html), which is all rpmdb needs and
+// saves a cgo or pure Go SQLite dependency. It doesn't replay journals, and
+// image layers shouldn't have any.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int
+}
+
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, errors.New("not an SQLite database")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+
+	return &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("SQLite page %d out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// tableRows calls fn with the columns of every row of the named table. An
+// INTEGER PRIMARY KEY column is nil, as SQLite stores it as the rowid.
+func (db *sqliteDB) tableRows(table string, fn func(cols []any) error) error {
+	root := 0
+	err := db.walkTable(1, 0, func(payload []byte) error {
+		cols, err := parseSQLiteRecord(payload)
+		if err != nil {
+			return err
+		}
+
+		// type, name, tbl_name, rootpage, sql
+		if len(cols) >= 4 && cols[0] == "table" {
+			if name, _ := cols[1].(string); strings.EqualFold(name, table) {
+				page, _ := cols[3].(int64)
+				root = int(page)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reading SQLite schema: %w", err)
+	}
+	if root == 0 {
+		return fmt.Errorf("no %s table", table)
+	}
+
+	return db.walkTable(root, 0, func(payload []byte) error {
+		cols, err := parseSQLiteRecord(payload)
+		if err != nil {
+			return err
+		}
+		return fn(cols)
+	})
+}
+
+// walkTable calls fn with the payload of every cell of the table b-tree
+// rooted at page n.
+func (db *sqliteDB) walkTable(n, depth int, fn func(payload []byte) error) error {
+	if depth > 64 {
+		return errors.New("SQLite b-tree too deep")
+	}
+
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	// the first page starts with the database header
+	hdr := page
+	if n == 1 {
+		hdr = page[100:]
+	}
+
+	cells := int(binary.BigEndian.Uint16(hdr[3:]))
+	switch hdr[0] {
+	case 0x05: // interior table page
+		pointers := hdr[12:]
+		if len(pointers) < cells*2 {
+			return errors.New("SQLite cell pointers out of range")
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(pointers[i*2:]))
+			if off+4 > len(page) {
+				return errors.New("SQLite cell out of range")
+			}
+			err = db.walkTable(int(binary.BigEndian.Uint32(page[off:])), depth+1, fn)
+			if err != nil {
+				return err
+			}
+		}
+		return db.walkTable(int(binary.BigEndian.Uint32(hdr[8:])), depth+1, fn)
+	case 0x0d: // leaf table page
+		pointers := hdr[8:]
+		if len(pointers) < cells*2 {
+			return errors.New("SQLite cell pointers out of range")
+		}
+		for i := 0; i < cells; i++ {
+			off := int(binary.BigEndian.Uint16(pointers[i*2:]))
+			payload, err := db.leafPayload(page, off)
+			if err != nil {
+				return err
+			}
+			err = fn(payload)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected SQLite page type %#x", hdr[0])
+	}
+}
+
+// leafPayload reads the payload of the leaf table cell at off, following
+// overflow pages if it didn't fit.
+func (db *sqliteDB) leafPayload(page []byte, off int) ([]byte, error) {
+	if off >= len(page) {
+		return nil, errors.New("SQLite cell out of range")
+	}
+
+	size, n := sqliteVarint(page[off:])
+	off += n
+	_, m := sqliteVarint(page[off:]) // rowid
+	off += m
+
+	if n == 0 || m == 0 || size < 0 || size > int64(len(db.data)) {
+		return nil, errors.New("SQLite payload size out of range")
+	}
+	total := int(size)
+
+	u := db.usable
+	maxLocal := u - 35
+	local := total
+	if total > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	if off+local > len(page) {
+		return nil, errors.New("SQLite payload out of range")
+	}
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[off:off+local]...)
+	if local == total {
+		return payload, nil
+	}
+
+	if off+local+4 > len(page) {
+		return nil, errors.New("SQLite overflow pointer out of range")
+	}
+	next := int(binary.BigEndian.Uint32(page[off+local:]))
+	for len(payload) < total {
+		if next == 0 {
+			return nil, errors.New("SQLite overflow chain ends early")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+
+		next = int(binary.BigEndian.Uint32(overflow))
+		chunk := min(total-len(payload), u-4)
+		payload = append(payload, overflow[4:4+chunk]...)
+	}
+
+	return payload, nil
+}
+
+// parseSQLiteRecord decodes a record into nil, int64, float64, []byte or
+// string values.
+func parseSQLiteRecord(payload []byte) ([]any, error) {
+	hdrSize, n := sqliteVarint(payload)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(payload)) {
+		return nil, errors.New("SQLite record header out of range")
+	}
+
+	types := []int64{}
+	for off := n; off < int(hdrSize); {
+		t, n := sqliteVarint(payload[off:int(hdrSize)])
+		if n == 0 {
+			return nil, errors.New("bad SQLite serial type")
+		}
+		types = append(types, t)
+		off += n
+	}
+
+	cols := make([]any, 0, len(types))
+	body := payload[hdrSize:]
+	for _, t := range types {
+		var size int
+		switch {
+		case t == 0, t == 8, t == 9:
+			size = 0
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = int((t - 12) / 2)
+		default:
+			return nil, fmt.Errorf("reserved SQLite serial type %d", t)
+		}
+		if size > len(body) {
+			return nil, errors.New("SQLite record value out of range")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			cols = append(cols, nil)
+		case t == 8:
+			cols = append(cols, int64(0))
+		case t == 9:
+			cols = append(cols, int64(1))
+		case t == 7:
+			cols = append(cols, math.Float64frombits(binary.BigEndian.Uint64(value)))
+		case t <= 6:
+			// big-endian two's complement of size bytes
+			v := int64(int8(value[0]))
+			for _, b := range value[1:] {
+				v = v<<8 | int64(b)
+			}
+			cols = append(cols, v)
+		case t%2 == 0:
+			cols = append(cols, value)
+		default:
+			cols = append(cols, string(value))
+		}
+	}
+
+	return cols, nil
+}
+
+// sqliteVarint decodes a big-endian varint of up to nine bytes, returning
+// its value and length, or a length of zero if b is too short.
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/logging"
+	"browseimage/secrets"
+	"browseimage/storage"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// scanBudget leaves time within the function's timeout to store what was
+// found before it runs out.
+const scanBudget = 12 * time.Minute
+
+func main() {
+	logging.Init()
+
+	ctx := context.Background()
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	s := &secretScanner{
+		storage:  storage.NewS3(s3.NewFromConfig(cfg), os.Getenv("BUCKET")),
+		dynamodb: dynamodb.NewFromConfig(cfg),
+		table:    os.Getenv("TABLE"),
+		http: &auth.Client{
+			Client: http.DefaultClient,
+			Cache:  auth.DefaultCache,
+		},
+	}
+	lambda.Start(logging.Middleware(s.handle))
+}
+
+type secretScannerInput struct {
+	Key *bitypes.ImageInfoKey
+}
+
+type secretScanner struct {
+	storage  storage.Storage
+	dynamodb *dynamodb.Client
+	table    string
+	http     *auth.Client
+}
+
+func (ss *secretScanner) handle(ctx context.Context, input *secretScannerInput) (*bitypes.Secrets, error) {
+	ctx = logging.WithRequestPayload(ctx, input)
+	slog.InfoContext(ctx, "handling secret scanner request")
+
+	summary, err := secrets.ScanImage(ctx, ss.storage, ss.http, input.Key, secrets.DefaultRules, scanBudget)
+	if err != nil {
+		return nil, fmt.Errorf("scanning for secrets: %w", err)
+	}
+
+	av, err := attributevalue.Marshal(summary)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling secrets: %w", err)
+	}
+
+	_, err = ss.dynamodb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        &ss.table,
+		Key:              input.Key.Key(),
+		UpdateExpression: aws.String("SET Secrets = :Secrets"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":Secrets": av,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating image secrets in dynamo: %w", err)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"browseimage/bitypes"
+	"browseimage/extract"
+	"browseimage/layerreader"
+	"browseimage/sortedindex"
+	"browseimage/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// WriteIndex writes files, which must be sorted by path, as an index keyed
+// by path.
+func WriteIndex(w io.Writer, files []File) error {
+	sw := sortedindex.NewWriter(w)
+	for _, f := range files {
+		j, _ := json.Marshal(f)
+		err := sw.Add(f.Path, j)
+		if err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+// Lookup returns the files with secrets under prefix, or all of them if
+// prefix is empty, from the index at key.
+func Lookup(ctx context.Context, s storage.Storage, key, prefix string) ([]File, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+
+	index, err := sortedindex.Open(ctx, func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		return s.GetRange(ctx, key, offset, length)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening secrets index: %w", err)
+	}
+
+	files := []File{}
+	// no path has a byte this high, as they're UTF-8
+	err = index.Scan(ctx, prefix, prefix+"\xff", func(record []byte) error {
+		f := File{}
+		err := json.Unmarshal(record, &f)
+		if err != nil {
+			return fmt.Errorf("unmarshalling secrets: %w", err)
+		}
+
+		if strings.HasPrefix(f.Path, prefix) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// ScanImage scans an indexed image for secrets, spending at most budget on
+// reading files, and stores the findings at SecretsIndexKey.
+func ScanImage(ctx context.Context, s storage.Storage, client *auth.Client, key *bitypes.ImageInfoKey, rules []Rule, budget time.Duration) (*bitypes.Secrets, error) {
+	entries, err := layerreader.LoadImageIndex(ctx, s, storage.ImageIndexKey(key.Repo, key.Digest), "")
+	if err != nil {
+		return nil, fmt.Errorf("loading image index: %w", err)
+	}
+
+	repo, err := name.NewRepository(key.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repository: %w", err)
+	}
+
+	indexes := &extract.Indexes{Storage: s}
+	defer indexes.Close()
+
+	scanCtx, cancel := context.WithTimeout(auth.WithScopes(ctx, repo.Scope("pull")), budget)
+	defer cancel()
+
+	files, summary := Scan(scanCtx, entries, extract.Reader(client, repo, indexes), rules)
+
+	buf := &bytes.Buffer{}
+	err = WriteIndex(buf, files)
+	if err != nil {
+		return nil, fmt.Errorf("writing secrets index: %w", err)
+	}
+
+	_, err = s.Put(ctx, storage.SecretsIndexKey(key.Repo, key.Digest), buf)
+	if err != nil {
+		return nil, fmt.Errorf("uploading secrets index: %w", err)
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,105 @@
+package secrets
+
+import (
+	"math"
+	"regexp"
+)
+
+// Rule finds one kind of secret in the lines of a file.
+type Rule struct {
+	ID          string
+	Description string
+	// Keywords are lowercase strings a line has to contain, ignoring case,
+	// for Pattern to be tried on it, as most lines contain none and regexps
+	// are slow
+	Keywords []string
+	// Pattern matches the secret. If it has a subexpression named "secret",
+	// only that part is checked for entropy and redacted.
+	Pattern *regexp.Regexp
+	// MinEntropy is the Shannon entropy, in bits per byte, the secret needs
+	// to have, to skip placeholders like "changeme" or "xxxxxxxx"
+	MinEntropy float64
+	// Files limits the rule to files with these base names. Those files are
+	// always scanned, whatever their path.
+	Files []string
+}
+
+// DefaultRules are the credentials images most often leak.
+var DefaultRules = []Rule{{
+	ID:          "aws-access-key-id",
+	Description: "AWS access key ID",
+	Keywords:    []string{"akia", "asia"},
+	Pattern:     regexp.MustCompile(`\b(?P<secret>(?:AKIA|ASIA)[0-9A-Z]{16})\b`),
+	MinEntropy:  3,
+}, {
+	ID:          "aws-secret-access-key",
+	Description: "AWS secret access key",
+	Keywords:    []string{"secret"},
+	Pattern:     regexp.MustCompile(`(?i)(?:aws_?secret_?access_?key|secretaccesskey)["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+]{40})\b`),
+	MinEntropy:  4,
+}, {
+	ID:          "private-key",
+	Description: "PEM private key",
+	Keywords:    []string{"private key"},
+	Pattern:     regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |ENCRYPTED |PGP )?PRIVATE KEY(?: BLOCK)?-----`),
+}, {
+	ID:          "npmrc-auth-token",
+	Description: "npm registry token in .npmrc",
+	Keywords:    []string{"_auth", "_password"},
+	Pattern:     regexp.MustCompile(`(?:_authToken|_auth|_password)\s*=\s*["']?(?P<secret>[^\s"'$]{8,})`),
+	MinEntropy:  3,
+	Files:       []string{".npmrc"},
+}, {
+	ID:          "npm-token",
+	Description: "npm access token",
+	Keywords:    []string{"npm_"},
+	Pattern:     regexp.MustCompile(`\b(?P<secret>npm_[A-Za-z0-9]{36})\b`),
+	MinEntropy:  4,
+}, {
+	ID:          "github-token",
+	Description: "GitHub token",
+	Keywords:    []string{"ghp_", "gho_", "ghu_", "ghs_", "ghr_", "github_pat_"},
+	Pattern:     regexp.MustCompile(`\b(?P<secret>gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`),
+	MinEntropy:  4,
+}, {
+	ID:          "slack-token",
+	Description: "Slack token",
+	Keywords:    []string{"xox"},
+	Pattern:     regexp.MustCompile(`\b(?P<secret>xox[baprs]-[A-Za-z0-9-]{10,})\b`),
+	MinEntropy:  3.5,
+}, {
+	ID:          "docker-config-auth",
+	Description: "registry credentials in a Docker config",
+	Keywords:    []string{`"auth"`},
+	Pattern:     regexp.MustCompile(`"auth"\s*:\s*"(?P<secret>[A-Za-z0-9+/=]{16,})"`),
+	MinEntropy:  3.5,
+	Files:       []string{"config.json", ".dockercfg"},
+}, {
+	ID:          "generic-secret",
+	Description: "high entropy value assigned to a password, secret, token or API key",
+	Keywords:    []string{"pass", "secret", "token", "key"},
+	Pattern:     regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api_?key)[A-Za-z0-9_]*["']?\s*[:=]\s*["'](?P<secret>[^"'\s]{16,})["']`),
+	MinEntropy:  4.2,
+}}
+
+// entropy is the Shannon entropy of s in bits per byte.
+func entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := [256]int{}
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	e := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(s))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
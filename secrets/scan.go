@@ -0,0 +1,277 @@
+// Package secrets scans the files of an indexed image for credentials that
+// shouldn't have been shipped in it, like cloud keys, private keys and
+// registry tokens.
+package secrets
+
+import (
+	"archive/tar"
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxFileSize is the largest file scanned, as secrets are in config and
+	// source files, not in big data files
+	MaxFileSize = 1 << 20
+	// MaxFiles bounds how many files a scan reads, as each is a request to
+	// the registry
+	MaxFiles = 20000
+
+	maxFindingsPerFile = 20
+	concurrency        = 8
+)
+
+// skippedExtensions are of files that are binary or can't hold secrets as
+// text anyway.
+var skippedExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".ico": true, ".webp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".gz": true, ".xz": true, ".bz2": true, ".zst": true, ".zip": true, ".jar": true, ".whl": true,
+	".so": true, ".a": true, ".o": true, ".pyc": true, ".class": true, ".mo": true, ".wasm": true,
+	".pdf": true, ".db": true, ".sqlite": true,
+}
+
+// skippedDirs are full of text no one writes secrets into, and of example
+// keys that would only be noise.
+var skippedDirs = []string{
+	"usr/share/doc/", "usr/share/man/", "usr/share/info/", "usr/share/locale/", "usr/share/i18n/",
+	"usr/share/zoneinfo/", "usr/share/ca-certificates/", "etc/ssl/certs/", "usr/include/",
+}
+
+// Finding is a secret found in a file.
+type Finding struct {
+	Rule string
+	Line int
+	// Redacted is enough of the secret to recognize it, or the whole match
+	// for rules that find a marker, like a private key's header
+	Redacted string
+}
+
+// File is a file with secrets in it.
+type File struct {
+	Path     string
+	Layer    string
+	Findings []Finding
+}
+
+// ScanFile returns the secrets rules find in r. It returns binary if r looks
+// like a binary file, which isn't scanned.
+func ScanFile(name string, r io.Reader, rules []Rule) (findings []Finding, binary bool, err error) {
+	br := bufio.NewReaderSize(r, 8000)
+	head, err := br.Peek(8000)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, false, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, true, nil
+	}
+
+	base := path.Base(name)
+	active := []*Rule{}
+	for i := range rules {
+		if len(rules[i].Files) == 0 || contains(rules[i].Files, base) {
+			active = append(active, &rules[i])
+		}
+	}
+
+	scan := bufio.NewScanner(br)
+	scan.Buffer(nil, MaxFileSize+1)
+	for line := 1; scan.Scan(); line++ {
+		text := scan.Text()
+		lower := strings.ToLower(text)
+
+		for _, rule := range active {
+			if len(rule.Keywords) > 0 && !containsAny(lower, rule.Keywords) {
+				continue
+			}
+
+			for _, m := range rule.Pattern.FindAllStringSubmatch(text, -1) {
+				redacted, ok := rule.check(m)
+				if !ok {
+					continue
+				}
+
+				findings = append(findings, Finding{Rule: rule.ID, Line: line, Redacted: redacted})
+				if len(findings) == maxFindingsPerFile {
+					return findings, false, nil
+				}
+			}
+		}
+	}
+
+	return findings, false, scan.Err()
+}
+
+// check decides whether a match of the rule is a secret, and redacts it.
+func (r *Rule) check(match []string) (string, bool) {
+	i := r.Pattern.SubexpIndex("secret")
+	if i < 0 {
+		return truncate(match[0], 64), true
+	}
+
+	secret := match[i]
+	if entropy(secret) < r.MinEntropy {
+		return "", false
+	}
+	return secret[:min(4, len(secret)/4)] + "****", true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidate reports whether a file could have secrets worth scanning for.
+func candidate(e *layerreader.EntryWithLayer, rules []Rule) bool {
+	if e.Hdr.Typeflag != tar.TypeReg || e.Hdr.Size == 0 || e.Hdr.Size > MaxFileSize {
+		return false
+	}
+
+	name := strings.TrimPrefix(e.Hdr.Name, "/")
+	base := path.Base(name)
+	for _, r := range rules {
+		if contains(r.Files, base) {
+			return true
+		}
+	}
+
+	if skippedExtensions[strings.ToLower(path.Ext(name))] {
+		return false
+	}
+	for _, dir := range skippedDirs {
+		if strings.HasPrefix(name, dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scan reads the candidate files among entries, which should be the merged
+// filesystem of an image, and returns those with secrets in path order. A
+// scan cut short by ctx returns what it found so far, marked truncated.
+func Scan(ctx context.Context, entries []*layerreader.EntryWithLayer, read layerreader.FileReader, rules []Rule) ([]File, *bitypes.Secrets) {
+	summary := &bitypes.Secrets{Rules: map[string]int{}}
+
+	candidates := []*layerreader.EntryWithLayer{}
+	for _, e := range entries {
+		if !candidate(e, rules) {
+			continue
+		}
+		if len(candidates) == MaxFiles {
+			summary.Skipped++
+			summary.Truncated = true
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+
+	type result struct {
+		// started is false for the files a cancelled scan never got to
+		started  bool
+		binary   bool
+		findings []Finding
+		err      error
+	}
+	results := make([]result, len(candidates))
+
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, e := range candidates {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+
+		go func(i int, e *layerreader.EntryWithLayer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i].started = true
+			body, err := read(ctx, e, 0, e.Hdr.Size)
+			if err != nil {
+				results[i].err = fmt.Errorf("opening %s: %w", e.Hdr.Name, err)
+				return
+			}
+			defer body.Close()
+
+			findings, binary, err := ScanFile(e.Hdr.Name, body, rules)
+			if err != nil {
+				results[i].err = fmt.Errorf("scanning %s: %w", e.Hdr.Name, err)
+				return
+			}
+			results[i].findings, results[i].binary = findings, binary
+		}(i, e)
+	}
+	wg.Wait()
+
+	files := []File{}
+	for i, res := range results {
+		e := candidates[i]
+		switch {
+		case !res.started, res.err != nil && ctx.Err() != nil:
+			summary.Skipped++
+			summary.Truncated = true
+			continue
+		case res.err != nil:
+			// one unreadable file shouldn't hide the secrets in the rest
+			slog.WarnContext(ctx, "skipping file", "error", res.err)
+			summary.Skipped++
+			continue
+		case res.binary:
+			summary.Skipped++
+			continue
+		}
+
+		summary.Scanned++
+		if len(res.findings) == 0 {
+			continue
+		}
+
+		files = append(files, File{Path: strings.TrimPrefix(e.Hdr.Name, "/"), Layer: e.Layer, Findings: res.findings})
+		summary.Files++
+		summary.Findings += len(res.findings)
+		for _, f := range res.findings {
+			summary.Rules[f.Rule]++
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, summary
+}
@@ -0,0 +1,322 @@
+// Package sortedindex implements a file format for JSON lines records sorted
+// by a key, which can be searched with a handful of range requests.
+//
+// Records are written in blocks, each its own gzip member. After the blocks
+// comes a table of the first key and offset of every block, and then a
+// fixed-size trailer locating the table. The table and trailer are carried in
+// the extra fields of empty gzip members, so the whole file is still a valid
+// gzipped JSON lines file for anything that reads it from start to end.
+package sortedindex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// ErrNotSorted is returned by Open for files without a trailer, such as
+// plain gzipped JSON lines files.
+var ErrNotSorted = errors.New("not a sorted index")
+
+const (
+	magic = "SORTIDX1"
+
+	// payloads of the extra field are limited by its 16-bit length, less
+	// the subfield header
+	maxPayload = 0xffff - 4
+
+	// a gzip header with an extra field, an empty stored block and a zero
+	// crc and size
+	memberOverhead = 10 + 2 + 4 + 5 + 8
+	trailerSize    = memberOverhead + 24
+
+	// how much of the end of a file Open reads, in the hope that the table
+	// is within it
+	tailSize = 64 << 10
+)
+
+// Block locates a gzip member of records within the file.
+type Block struct {
+	Key    string // of the first record in the block
+	Offset int64
+}
+
+type table struct {
+	Blocks []Block
+}
+
+// Writer writes a sorted index. Records must be added in key order.
+type Writer struct {
+	// BlockSize is the amount of uncompressed records after which a new
+	// block is started.
+	BlockSize int
+
+	w      *countingWriter
+	gz     *gzip.Writer
+	block  int
+	blocks []Block
+	last   string
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		BlockSize: 64 << 10,
+		w:         &countingWriter{w: w},
+	}
+}
+
+// Add appends a record, which must not contain newlines.
+func (w *Writer) Add(key string, record []byte) error {
+	if len(w.blocks) > 0 && key < w.last {
+		return fmt.Errorf("key %q added after %q", key, w.last)
+	}
+	w.last = key
+
+	if w.gz == nil || w.block >= w.BlockSize {
+		err := w.endBlock()
+		if err != nil {
+			return err
+		}
+
+		w.blocks = append(w.blocks, Block{Key: key, Offset: w.w.n})
+		w.gz = gzip.NewWriter(w.w)
+		w.block = 0
+	}
+
+	w.block += len(record) + 1
+	_, err := w.gz.Write(record)
+	if err == nil {
+		_, err = w.gz.Write([]byte{'\n'})
+	}
+	if err != nil {
+		return fmt.Errorf("writing record: %w", err)
+	}
+
+	return nil
+}
+
+func (w *Writer) endBlock() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	err := w.gz.Close()
+	if err != nil {
+		return fmt.Errorf("closing block: %w", err)
+	}
+	w.gz = nil
+	return nil
+}
+
+// Close writes the table and trailer. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	err := w.endBlock()
+	if err != nil {
+		return err
+	}
+
+	j, _ := json.Marshal(table{Blocks: w.blocks})
+
+	tableOffset := w.w.n
+	for len(j) > 0 {
+		n := min(len(j), maxPayload)
+		_, err = w.w.Write(emptyMember(j[:n]))
+		if err != nil {
+			return fmt.Errorf("writing table: %w", err)
+		}
+		j = j[n:]
+	}
+
+	payload := binary.LittleEndian.AppendUint64(nil, uint64(tableOffset))
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(w.w.n-tableOffset))
+	payload = append(payload, magic...)
+
+	_, err = w.w.Write(emptyMember(payload))
+	if err != nil {
+		return fmt.Errorf("writing trailer: %w", err)
+	}
+
+	return nil
+}
+
+// emptyMember builds a gzip member with no content and payload in its extra
+// field. It is built by hand so that its size is always the same.
+func emptyMember(payload []byte) []byte {
+	b := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff}
+	b = binary.LittleEndian.AppendUint16(b, uint16(4+len(payload)))
+	b = append(b, 'S', 'I')
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, payload...)
+	b = append(b, 1, 0, 0, 0xff, 0xff)
+	return append(b, make([]byte, 8)...)
+}
+
+// memberPayload is the inverse of emptyMember.
+func memberPayload(b []byte) ([]byte, bool) {
+	if len(b) < memberOverhead || !bytes.Equal(b[:4], []byte{0x1f, 0x8b, 8, 4}) || b[12] != 'S' || b[13] != 'I' {
+		return nil, false
+	}
+
+	n := int(binary.LittleEndian.Uint16(b[14:]))
+	if len(b) != memberOverhead+n {
+		return nil, false
+	}
+	return b[16 : 16+n], true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// RangeReader reads length bytes at offset of a file. A negative offset
+// counts back from the end of the file, and a negative length reads to the
+// end of it. It has the same semantics as storage.Storage.GetRange.
+type RangeReader func(ctx context.Context, offset, length int64) (io.ReadCloser, error)
+
+// Index is an opened sorted index.
+type Index struct {
+	get    RangeReader
+	blocks []Block
+	end    int64 // of the blocks
+}
+
+// Open reads the table of a sorted index, which usually takes a single range
+// request.
+func Open(ctx context.Context, get RangeReader) (*Index, error) {
+	tail, err := readRange(ctx, get, -tailSize, -1)
+	if err != nil {
+		return nil, fmt.Errorf("reading end of index: %w", err)
+	}
+
+	if len(tail) < trailerSize {
+		return nil, ErrNotSorted
+	}
+
+	payload, ok := memberPayload(tail[len(tail)-trailerSize:])
+	if !ok || len(payload) != 24 || string(payload[16:]) != magic {
+		return nil, ErrNotSorted
+	}
+
+	tableOffset := int64(binary.LittleEndian.Uint64(payload))
+	tableSize := int64(binary.LittleEndian.Uint64(payload[8:]))
+
+	var tableBytes []byte
+	tailStart := tableOffset + tableSize + trailerSize - int64(len(tail))
+	if tableOffset >= tailStart {
+		tableBytes = tail[tableOffset-tailStart : tableOffset-tailStart+tableSize]
+	} else {
+		tableBytes, err = readRange(ctx, get, tableOffset, tableSize)
+		if err != nil {
+			return nil, fmt.Errorf("reading table: %w", err)
+		}
+	}
+
+	j := []byte{}
+	for len(tableBytes) > 0 {
+		if len(tableBytes) < memberOverhead {
+			return nil, fmt.Errorf("truncated table")
+		}
+		n := memberOverhead + int(binary.LittleEndian.Uint16(tableBytes[14:]))
+		payload, ok := memberPayload(tableBytes[:min(n, len(tableBytes))])
+		if !ok {
+			return nil, fmt.Errorf("malformed table")
+		}
+		j = append(j, payload...)
+		tableBytes = tableBytes[n:]
+	}
+
+	t := table{}
+	err = json.Unmarshal(j, &t)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling table: %w", err)
+	}
+
+	return &Index{get: get, blocks: t.Blocks, end: tableOffset}, nil
+}
+
+// Scan calls fn for every record with a key from from up to but excluding
+// to. It fetches the blocks that might hold them in one range request, and
+// may also call fn for records just outside the range, so fn should check
+// the records it's given.
+func (ix *Index) Scan(ctx context.Context, from, to string, fn func(record []byte) error) error {
+	first := sort.Search(len(ix.blocks), func(i int) bool {
+		return ix.blocks[i].Key > from
+	}) - 1
+	first = max(first, 0)
+
+	// the last block that starts before to
+	last := sort.Search(len(ix.blocks), func(i int) bool {
+		return ix.blocks[i].Key >= to
+	}) - 1
+
+	if len(ix.blocks) == 0 || last < first {
+		return nil
+	}
+
+	start := ix.blocks[first].Offset
+	end := ix.end
+	if last+1 < len(ix.blocks) {
+		end = ix.blocks[last+1].Offset
+	}
+
+	body, err := ix.get(ctx, start, end-start)
+	if err != nil {
+		return fmt.Errorf("reading blocks: %w", err)
+	}
+	defer body.Close()
+
+	gzr, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("gunzipping blocks: %w", err)
+	}
+	defer gzr.Close()
+
+	scan := bufio.NewScanner(gzr)
+	scan.Buffer(nil, 1<<20)
+	for scan.Scan() {
+		err = fn(scan.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	err = scan.Err()
+	if err != nil {
+		return fmt.Errorf("reading blocks: %w", err)
+	}
+
+	return nil
+}
+
+func readRange(ctx context.Context, get RangeReader, offset, length int64) ([]byte, error) {
+	body, err := get(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if length >= 0 && int64(len(b)) != length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
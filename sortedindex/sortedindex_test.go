@@ -0,0 +1,144 @@
+package sortedindex
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+// rangeReader serves ranges of b the way storage.Storage.GetRange does, and
+// counts the requests it gets.
+func rangeReader(b []byte, requests *int) RangeReader {
+	return func(ctx context.Context, offset, length int64) (io.ReadCloser, error) {
+		*requests++
+		if offset < 0 {
+			offset = max(int64(len(b))+offset, 0)
+			length = -1
+		}
+		end := int64(len(b))
+		if length >= 0 {
+			end = offset + length
+		}
+		return io.NopCloser(bytes.NewReader(b[offset:end])), nil
+	}
+}
+
+func writeIndex(t *testing.T, keys []string, blockSize int) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.BlockSize = blockSize
+	for _, key := range keys {
+		require.NoError(t, w.Add(key, []byte(fmt.Sprintf(`{"Key":%q}`, key))))
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestScan(t *testing.T) {
+	ctx := context.Background()
+
+	keys := []string{}
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, fmt.Sprintf("key%05d", i))
+	}
+
+	for _, blockSize := range []int{1, 100, 4096, 1 << 20} {
+		b := writeIndex(t, keys, blockSize)
+
+		requests := 0
+		ix, err := Open(ctx, rangeReader(b, &requests))
+		require.NoError(t, err)
+
+		for _, rng := range [][2]string{
+			{"key00000", "key00001"},
+			{"key01234", "key01300"},
+			{"key04999", "key05000"},
+			{"a", "key00003"},
+			{"key", "z"},
+			{"key01234x", "key01235"},
+			{"z", "zz"},
+		} {
+			expected := []string{}
+			for _, key := range keys {
+				if key >= rng[0] && key < rng[1] {
+					expected = append(expected, fmt.Sprintf(`{"Key":%q}`, key))
+				}
+			}
+
+			got := []string{}
+			err = ix.Scan(ctx, rng[0], rng[1], func(record []byte) error {
+				s := string(record)
+				if s >= fmt.Sprintf(`{"Key":%q}`, rng[0]) && s < fmt.Sprintf(`{"Key":%q}`, rng[1]) {
+					got = append(got, s)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, expected, got, "block size %d, range %v", blockSize, rng)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+
+	// long keys and tiny blocks make a table that spans several gzip
+	// members and doesn't fit in the tail
+	keys := []string{}
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, fmt.Sprintf("%s%05d", strings.Repeat("k", 100), i))
+	}
+	b := writeIndex(t, keys, 1)
+
+	requests := 0
+	ix, err := Open(ctx, rangeReader(b, &requests))
+	require.NoError(t, err)
+	require.Len(t, ix.blocks, len(keys))
+	require.Equal(t, 2, requests)
+
+	b = writeIndex(t, keys[:10], 1)
+	requests = 0
+	_, err = Open(ctx, rangeReader(b, &requests))
+	require.NoError(t, err)
+	require.Equal(t, 1, requests)
+
+	// the file is still plain gzipped JSON lines from start to end
+	gzr, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	scan := bufio.NewScanner(gzr)
+	lines := 0
+	for scan.Scan() {
+		require.Equal(t, fmt.Sprintf(`{"Key":%q}`, keys[lines]), scan.Text())
+		lines++
+	}
+	require.NoError(t, scan.Err())
+	require.Equal(t, 10, lines)
+
+	plain := &bytes.Buffer{}
+	gzw := gzip.NewWriter(plain)
+	gzw.Write([]byte(`{"Key":"a"}` + "\n"))
+	require.NoError(t, gzw.Close())
+	_, err = Open(ctx, rangeReader(plain.Bytes(), &requests))
+	require.ErrorIs(t, err, ErrNotSorted)
+
+	empty := writeIndex(t, nil, 1)
+	ix, err = Open(ctx, rangeReader(empty, &requests))
+	require.NoError(t, err)
+	require.NoError(t, ix.Scan(ctx, "a", "z", func(record []byte) error {
+		t.Fatalf("unexpected record %s", record)
+		return nil
+	}))
+
+	w := NewWriter(io.Discard)
+	require.NoError(t, w.Add("b", []byte("{}")))
+	require.Error(t, w.Add("a", []byte("{}")))
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local stores objects as files under a directory, with keys as paths.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// path is the file for key. Keys are built from request parameters, so any
+// that would lead outside of l.dir are rejected.
+func (l *Local) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidKey, key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file and renames it into place, so
+// that readers never see it half written.
+func (l *Local) Put(ctx context.Context, key string, body io.Reader) (string, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("creating dir for %s: %w", key, err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file for %s: %w", key, err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, body)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		f.Close()
+		return "", fmt.Errorf("writing %s: %w", key, err)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", fmt.Errorf("renaming %s: %w", key, err)
+	}
+
+	return "", nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("getting %s: %w", key, ErrNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", key, err)
+	}
+
+	return f, nil
+}
+
+func (l *Local) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := l.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	f := rc.(*os.File)
+
+	if offset < 0 {
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("getting size of %s: %w", key, err)
+		}
+		offset = max(stat.Size()+offset, 0)
+		length = -1
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("seeking in %s: %w", key, err)
+	}
+
+	if length < 0 {
+		return f, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(t.TempDir())
+
+	_, err := s.Get(ctx, GzIndexKey("sha256:abc"))
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetRange(ctx, FileIndexKey("sha256:abc"), -10, -1)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = s.Put(ctx, GzIndexKey("sha256:abc"), strings.NewReader("0123456789"))
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		offset, length int64
+		expected       string
+	}{
+		{0, -1, "0123456789"},
+		{3, 4, "3456"},
+		{8, -1, "89"},
+		{-4, -1, "6789"},
+		{-100, -1, "0123456789"},
+	} {
+		r, err := s.GetRange(ctx, GzIndexKey("sha256:abc"), tt.offset, tt.length)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, tt.expected, string(got))
+	}
+}
+
+func TestLocalInvalidKey(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocal(filepath.Join(t.TempDir(), "storage"))
+
+	for _, key := range []string{
+		"../outside",
+		ImageIndexKey("test/image", "../../../.."),
+		GzIndexKey("../../sha256:abc"),
+		"/etc/passwd",
+		"",
+	} {
+		_, err := s.Put(ctx, key, strings.NewReader("data"))
+		require.ErrorIs(t, err, ErrInvalidKey, key)
+		_, err = s.Get(ctx, key)
+		require.ErrorIs(t, err, ErrInvalidKey, key)
+		_, err = s.GetRange(ctx, key, 0, -1)
+		require.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}
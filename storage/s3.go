@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// S3 stores objects in a bucket.
+type S3 struct {
+	api      *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+}
+
+func NewS3(api *s3.Client, bucket string) *S3 {
+	return &S3{
+		api:      api,
+		uploader: manager.NewUploader(api),
+		bucket:   bucket,
+	}
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader) (string, error) {
+	upload, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		Body:   body,
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploading %s: %w", key, err)
+	}
+
+	return aws.ToString(upload.VersionID), nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.get(ctx, key, nil)
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		rng := fmt.Sprintf("bytes=%d", offset)
+		return s.get(ctx, key, &rng)
+	}
+
+	// there is no way to ask for an empty range
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length >= 0 {
+		rng += fmt.Sprintf("%d", offset+length-1)
+	}
+	return s.get(ctx, key, &rng)
+}
+
+func (s *S3) get(ctx context.Context, key string, rng *string) (io.ReadCloser, error) {
+	get, err := s.api.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		Range:  rng,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", key, notFound(err))
+	}
+
+	return get.Body, nil
+}
+
+// notFound adds ErrNotFound to the chain of errors for missing objects.
+func notFound(err error) error {
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchKey" {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
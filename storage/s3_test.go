@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3EmptyRange(t *testing.T) {
+	// an empty range never reaches the bucket
+	s := &S3{}
+
+	r, err := s.GetRange(context.Background(), FileIndexKey("sha256:abc"), 10, 0)
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Empty(t, got)
+}
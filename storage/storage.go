@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned when an object doesn't exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrInvalidKey is returned for keys that can't name an object, like ones
+// that would escape a Local's directory.
+var ErrInvalidKey = errors.New("invalid key")
+
+// Storage holds the artifacts produced by indexing: the gzip and file indexes
+// of each layer, and the merged file index and path history of each image,
+// which are sortedindexes.
+type Storage interface {
+	// Put stores an object and returns its version ID, which is empty for
+	// unversioned storage.
+	Put(ctx context.Context, key string, body io.Reader) (string, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes at offset of an object, or through to the
+	// end of it if length is negative. A negative offset reads the last
+	// -offset bytes, or the whole object if it is smaller.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+func LayerPrefix(layer string) string {
+	return fmt.Sprintf("layers/%s/", layer)
+}
+
+func GzIndexKey(layer string) string {
+	return LayerPrefix(layer) + "index.gzi"
+}
+
+func FileIndexKey(layer string) string {
+	return LayerPrefix(layer) + "files.json.gz"
+}
+
+func ImagePrefix(repo, digest string) string {
+	return fmt.Sprintf("images/%s/%s/", repo, digest)
+}
+
+func ImageIndexKey(repo, digest string) string {
+	return ImagePrefix(repo, digest) + "index.json.gz"
+}
+
+func HistoryIndexKey(repo, digest string) string {
+	return ImagePrefix(repo, digest) + "history.json.gz"
+}
+
+// PackagesKey is where the packages found in an image are cached, as they
+// take reading many files to find.
+func PackagesKey(repo, digest string) string {
+	return ImagePrefix(repo, digest) + "packages.json"
+}
+
+// VulnsKey is where the advisories matched to an image's packages are
+// cached.
+func VulnsKey(repo, digest string) string {
+	return ImagePrefix(repo, digest) + "vulns.json"
+}
+
+// SecretsIndexKey is where the secret scan's findings are, keyed by path.
+func SecretsIndexKey(repo, digest string) string {
+	return ImagePrefix(repo, digest) + "secrets.json.gz"
+}
@@ -0,0 +1,110 @@
+package targzi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies how a layer blob is compressed.
+type Compression string
+
+const (
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+	CompressionNone Compression = "none"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// both the POSIX "ustar\x0000" and GNU "ustar  \x00" variants
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// CompressionFromMediaType maps OCI and Docker layer media types to their
+// compression. It returns "" for media types it doesn't recognise, in which
+// case SniffCompression should be used on the blob itself.
+func CompressionFromMediaType(mediaType string) Compression {
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip"), strings.HasSuffix(mediaType, ".tar.gzip"):
+		return CompressionGzip
+	case strings.HasSuffix(mediaType, "+zstd"), strings.HasSuffix(mediaType, ".tar.zstd"):
+		return CompressionZstd
+	case strings.HasSuffix(mediaType, ".tar"):
+		return CompressionNone
+	default:
+		return ""
+	}
+}
+
+// SniffCompression identifies the compression of a blob from its magic
+// bytes. The returned reader yields the full blob, including the peeked bytes.
+func SniffCompression(r io.Reader) (Compression, io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(tarMagicOffset + len(tarMagic))
+	if err != nil && err != io.EOF {
+		return "", nil, fmt.Errorf("peeking at layer: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return CompressionGzip, br, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return CompressionZstd, br, nil
+	case len(head) >= 4 && binary.LittleEndian.Uint32(head)&zstdSkippableMask == zstdSkippableMagic:
+		// seekable zstd writers may lead with a skippable frame
+		return CompressionZstd, br, nil
+	case len(head) > tarMagicOffset && bytes.HasPrefix(head[tarMagicOffset:], tarMagic):
+		return CompressionNone, br, nil
+	default:
+		return "", nil, fmt.Errorf("unrecognised layer compression (magic %x)", head)
+	}
+}
+
+// Decompress returns the uncompressed tar stream of a layer blob of any
+// supported compression.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	compression, r, err := SniffCompression(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// compressionCodes is how each compression is recorded in a serialized index.
+var compressionCodes = map[Compression]uint8{
+	CompressionGzip: 0,
+	CompressionZstd: 1,
+}
+
+func compressionFromCode(code uint8) (Compression, error) {
+	for c, cc := range compressionCodes {
+		if cc == code {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown compression code %d", code)
+}
@@ -0,0 +1,253 @@
+package targzi
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/klauspost/compress/gzip"
+)
+
+// Chunk locates part of a regular file in an eStargz layer. Every chunk is
+// its own gzip member, so it can be decompressed without any index.
+type Chunk struct {
+	Offset         int64 // of the chunk within the file
+	Compressed     int64 // of the chunk's gzip member within the layer blob
+	CompressedSize int64
+}
+
+// ChunkRange returns the chunks that must be fetched to read length bytes at
+// offset of a file.
+func ChunkRange(chunks []Chunk, offset, length int64) []Chunk {
+	first := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].Offset > offset
+	}) - 1
+	last := sort.Search(len(chunks), func(i int) bool {
+		return chunks[i].Offset >= offset+length
+	})
+
+	if first < 0 || last <= first {
+		return nil
+	}
+	return chunks[first:last]
+}
+
+// ReadStargzTOC reads the table of contents of an eStargz layer and its
+// offset from the layer's footer, touching nothing but the end of the blob.
+// It returns an error for layers that aren't eStargz.
+func ReadStargzTOC(sr *io.SectionReader) (*estargz.JTOC, int64, error) {
+	decompressors := []estargz.Decompressor{new(estargz.GzipDecompressor), new(estargz.LegacyGzipDecompressor)}
+
+	footerSize := int64(0)
+	for _, d := range decompressors {
+		footerSize = max(footerSize, d.FooterSize())
+	}
+	if sr.Size() < footerSize {
+		return nil, 0, fmt.Errorf("layer is too small to be eStargz")
+	}
+
+	footer := make([]byte, footerSize)
+	_, err := sr.ReadAt(footer, sr.Size()-footerSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading footer: %w", err)
+	}
+
+	for _, d := range decompressors {
+		fSize := d.FooterSize()
+		_, tocOffset, tocSize, err := d.ParseFooter(footer[footerSize-fSize:])
+		if err != nil {
+			continue
+		}
+
+		if tocSize <= 0 {
+			tocSize = sr.Size() - tocOffset - fSize
+		}
+		if tocOffset < 0 || tocSize <= 0 || tocOffset+tocSize > sr.Size() {
+			return nil, 0, fmt.Errorf("footer has invalid toc offset %d", tocOffset)
+		}
+
+		toc, _, err := d.ParseTOC(io.NewSectionReader(sr, tocOffset, tocSize))
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsing toc: %w", err)
+		}
+
+		return toc, tocOffset, nil
+	}
+
+	return nil, 0, fmt.Errorf("layer has no eStargz footer")
+}
+
+// BuildStargzIndex writes a file index for an eStargz layer using only its
+// table of contents. Tar offsets are unknown without decompressing the layer,
+// so entries instead carry the chunks of each regular file.
+func BuildStargzIndex(root string, toc *estargz.JTOC, tocOffset int64, fileCounter *int64) (*Index, error) {
+	dir, err := os.MkdirTemp(root, "targzi*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+
+	// a chunk's gzip member ends where the next one with an offset begins,
+	// and the last ends at the toc
+	ends := make([]int64, len(toc.Entries))
+	end := tocOffset
+	for idx := len(toc.Entries) - 1; idx >= 0; idx-- {
+		ends[idx] = end
+		if toc.Entries[idx].Offset != 0 {
+			end = toc.Entries[idx].Offset
+		}
+	}
+
+	entries := []*Entry{}
+	var current *Entry
+	for idx, te := range toc.Entries {
+		if te.Type == "chunk" {
+			if current == nil {
+				return nil, fmt.Errorf("chunk of %q has no preceding file", te.Name)
+			}
+			current.Chunks = append(current.Chunks, Chunk{
+				Offset:         te.ChunkOffset,
+				Compressed:     te.Offset,
+				CompressedSize: ends[idx] - te.Offset,
+			})
+			continue
+		}
+
+		hdr, err := stargzHeader(te)
+		if err != nil {
+			return nil, err
+		}
+
+		parent := cleanHeaderName(hdr)
+		current = &Entry{Hdr: *hdr, Parent: parent}
+		entries = append(entries, current)
+
+		if te.Type == "reg" {
+			current.Digest = te.Digest
+		}
+
+		if te.Type == "reg" && te.Size > 0 {
+			current.Chunks = []Chunk{{
+				Offset:         0,
+				Compressed:     te.Offset,
+				CompressedSize: ends[idx] - te.Offset,
+			}}
+		}
+
+		atomic.AddInt64(fileCounter, 1)
+	}
+
+	sortEntries(entries)
+
+	fileIndexPath := fmt.Sprintf("%s/files.json.gz", dir)
+	err = writeFileIndex(fileIndexPath, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{
+		Entries:       entries,
+		FileIndexPath: fileIndexPath,
+	}, nil
+}
+
+var stargzTypes = map[string]byte{
+	"dir":      tar.TypeDir,
+	"reg":      tar.TypeReg,
+	"symlink":  tar.TypeSymlink,
+	"hardlink": tar.TypeLink,
+	"char":     tar.TypeChar,
+	"block":    tar.TypeBlock,
+	"fifo":     tar.TypeFifo,
+}
+
+// stargzHeader reconstructs the tar header that a TOC entry was made from.
+func stargzHeader(te *estargz.TOCEntry) (*tar.Header, error) {
+	typeflag, ok := stargzTypes[te.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown toc entry type %q for %q", te.Type, te.Name)
+	}
+
+	hdr := &tar.Header{
+		Name:     te.Name,
+		Typeflag: typeflag,
+		Linkname: te.LinkName,
+		Size:     te.Size,
+		Mode:     te.Mode,
+		Uid:      te.UID,
+		Gid:      te.GID,
+		Uname:    te.Uname,
+		Gname:    te.Gname,
+		Devmajor: int64(te.DevMajor),
+		Devminor: int64(te.DevMinor),
+	}
+
+	if te.ModTime3339 != "" {
+		mtime, err := time.Parse(time.RFC3339, te.ModTime3339)
+		if err != nil {
+			return nil, fmt.Errorf("parsing mtime of %q: %w", te.Name, err)
+		}
+		hdr.ModTime = mtime
+	}
+
+	if len(te.Xattrs) > 0 {
+		hdr.PAXRecords = map[string]string{}
+		for k, v := range te.Xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+k] = string(v)
+		}
+	}
+
+	return hdr, nil
+}
+
+// OpenChunks decompresses length bytes at offset of a file, given the
+// compressed bytes of the chunks returned by ChunkRange.
+func OpenChunks(compressed io.Reader, chunks []Chunk, offset, length int64) (io.ReadCloser, error) {
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no chunks for range %d+%d", offset, length)
+	}
+
+	// consecutive chunks are consecutive gzip members
+	gzr, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("opening chunk: %w", err)
+	}
+
+	_, err = io.CopyN(io.Discard, gzr, offset-chunks[0].Offset)
+	if err != nil {
+		gzr.Close()
+		return nil, fmt.Errorf("skipping to offset: %w", unexpected(err))
+	}
+
+	return &chunkReader{gzr: gzr, remaining: length}, nil
+}
+
+type chunkReader struct {
+	gzr       *gzip.Reader
+	remaining int64
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.gzr.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *chunkReader) Close() error {
+	return c.gzr.Close()
+}
@@ -0,0 +1,132 @@
+package targzi
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/klauspost/compress/gzip"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// stargzCompressor writes the TOC and footer the way estargz does. Its own
+// footer writer relies on the exact output of compress/gzip for an empty
+// stream, which newer Go releases no longer produce.
+type stargzCompressor struct {
+	*estargz.GzipCompressor
+}
+
+func (c stargzCompressor) WriteTOCAndFooter(w io.Writer, off int64, toc *estargz.JTOC, diffHash hash.Hash) (digest.Digest, error) {
+	tocJSON, err := json.Marshal(toc)
+	if err != nil {
+		return "", err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: estargz.TOCTarName, Size: int64(len(tocJSON))})
+	if err != nil {
+		return "", err
+	}
+	tw.Write(tocJSON)
+	tw.Close()
+	gz.Close()
+
+	// a gzip header with the toc offset in its extra field, an empty
+	// stored block and an empty trailer
+	subfield := fmt.Sprintf("%016xSTARGZ", off)
+	footer := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff}
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(4+len(subfield)))
+	footer = append(footer, 'S', 'G')
+	footer = binary.LittleEndian.AppendUint16(footer, uint16(len(subfield)))
+	footer = append(footer, subfield...)
+	footer = append(footer, 1, 0, 0, 0xff, 0xff)
+	footer = append(footer, make([]byte, 8)...)
+
+	_, err = w.Write(footer)
+	return digest.FromBytes(tocJSON), err
+}
+
+func TestBuildStargzIndex(t *testing.T) {
+	raw := testLayer(t, 11)
+
+	contents := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[filepath.Clean(hdr.Name)] = body
+	}
+
+	buf := &bytes.Buffer{}
+	w := estargz.NewWriterWithCompressor(buf, stargzCompressor{estargz.NewGzipCompressor()})
+	w.ChunkSize = 64 << 10
+	require.NoError(t, w.AppendTar(bytes.NewReader(raw)))
+	_, err := w.Close()
+	require.NoError(t, err)
+	stargz := buf.Bytes()
+
+	toc, tocOffset, err := ReadStargzTOC(io.NewSectionReader(bytes.NewReader(stargz), 0, int64(len(stargz))))
+	require.NoError(t, err)
+
+	var fileCount int64
+	index, err := BuildStargzIndex(t.TempDir(), toc, tocOffset, &fileCount)
+	require.NoError(t, err)
+	require.Empty(t, index.GzIndexPath)
+
+	read, err := ReadIndex(filepath.Dir(index.FileIndexPath))
+	require.NoError(t, err)
+
+	files := 0
+	for _, entry := range read.Entries {
+		body, ok := contents[entry.Hdr.Name]
+		if entry.Hdr.Typeflag != tar.TypeReg || !ok {
+			continue
+		}
+		files++
+		size := entry.Hdr.Size
+		require.EqualValues(t, len(body), size, entry.Hdr.Name)
+		require.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(body)), entry.Digest, entry.Hdr.Name)
+		if size == 0 {
+			require.Empty(t, entry.Chunks)
+			continue
+		}
+
+		for _, rng := range [][2]int64{{0, size}, {size / 3, size / 2}, {size - 1, 1}} {
+			offset, length := rng[0], rng[1]
+			if length == 0 {
+				continue
+			}
+
+			chunks := ChunkRange(entry.Chunks, offset, length)
+			require.NotEmpty(t, chunks)
+			last := chunks[len(chunks)-1]
+			compressed := stargz[chunks[0].Compressed : last.Compressed+last.CompressedSize]
+
+			r, err := OpenChunks(bytes.NewReader(compressed), chunks, offset, length)
+			require.NoError(t, err)
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.True(t, bytes.Equal(body[offset:offset+length], got), "%s %d+%d", entry.Hdr.Name, offset, length)
+		}
+	}
+	require.NotZero(t, files)
+
+	_, _, err = ReadStargzTOC(io.NewSectionReader(bytes.NewReader(raw), 0, int64(len(raw))))
+	require.Error(t, err)
+}
@@ -0,0 +1,352 @@
+package targzi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// DefaultSpan is the minimum distance in uncompressed bytes between two
+// access points in a gzip index.
+const DefaultSpan = 10 << 20
+
+var gzIndexMagic = [8]byte{'T', 'G', 'Z', 'I', 'D', 'X', 0, 2}
+
+// version 1 indexes predate zstd support and are always gzip
+var gzIndexMagicV1 = [8]byte{'T', 'G', 'Z', 'I', 'D', 'X', 0, 1}
+
+// gztool indexes start with eight zero bytes and then "gzipindx", or
+// "gzipindX" when they also record line numbers
+var gztoolMagic = []byte("gzipind")
+
+// ErrGztoolIndex is returned for indexes written by gztool, which layers were
+// indexed with before GzIndex. They can't be read, so the layer has to be
+// indexed again.
+var ErrGztoolIndex = errors.New("layer index was written by gztool and must be rebuilt")
+
+// GzIndex is a set of access points into a compressed layer. For gzip each
+// access point marks a deflate block boundary and carries the 32 KiB of
+// uncompressed data preceding it, which is enough to start decompressing from
+// that point without reading anything before it. For zstd the access points
+// are frame boundaries (see zstd.go) and have empty windows.
+//
+// The serialized form is a fixed header, a table of points and then the
+// flate-compressed windows, so that the point table can be read without
+// loading any windows.
+type GzIndex struct {
+	Compression  Compression
+	Uncompressed int64
+	Compressed   int64
+	Points       []AccessPoint
+}
+
+type AccessPoint struct {
+	Compressed   int64
+	Uncompressed int64
+	Bits         uint8
+	Window       []byte
+
+	windowOffset int64
+	windowLength int64
+}
+
+type gzIndexHeader struct {
+	Magic        [8]byte
+	Compression  uint8
+	Uncompressed int64
+	Compressed   int64
+	Count        uint32
+}
+
+type gzIndexHeaderV1 struct {
+	Magic        [8]byte
+	Uncompressed int64
+	Compressed   int64
+	Count        uint32
+}
+
+type gzIndexPoint struct {
+	Compressed   int64
+	Uncompressed int64
+	Bits         uint8
+	WindowOffset int64
+	WindowLength int64
+}
+
+// Spans returns the access points in the same shape gztool used to report
+// them: numbered from 1, ordered by offset.
+func (g *GzIndex) Spans() []IndexSpan {
+	spans := make([]IndexSpan, 0, len(g.Points))
+	for idx, p := range g.Points {
+		spans = append(spans, IndexSpan{
+			Number:       idx + 1,
+			Uncompressed: int(p.Uncompressed),
+			Compressed:   int(p.Compressed),
+		})
+	}
+	return spans
+}
+
+// Point returns the last access point at or before the uncompressed offset
+// that is reachable from a compressed stream starting at skipped.
+func (g *GzIndex) Point(skipped, uncompressedOffset int64) (*AccessPoint, error) {
+	var found *AccessPoint
+	for idx := range g.Points {
+		p := &g.Points[idx]
+		if p.Uncompressed > uncompressedOffset {
+			break
+		}
+		if p.Compressed >= skipped {
+			found = p
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no access point for offset %d from compressed offset %d", uncompressedOffset, skipped)
+	}
+	return found, nil
+}
+
+func (g *GzIndex) WriteTo(w io.Writer) (int64, error) {
+	windows := &bytes.Buffer{}
+	points := make([]gzIndexPoint, 0, len(g.Points))
+
+	fw, err := flate.NewWriter(nil, flate.BestCompression)
+	if err != nil {
+		return 0, fmt.Errorf("creating window compressor: %w", err)
+	}
+
+	for _, p := range g.Points {
+		start := windows.Len()
+		fw.Reset(windows)
+		_, err = fw.Write(p.Window)
+		if err != nil {
+			return 0, fmt.Errorf("compressing window: %w", err)
+		}
+		err = fw.Close()
+		if err != nil {
+			return 0, fmt.Errorf("compressing window: %w", err)
+		}
+
+		points = append(points, gzIndexPoint{
+			Compressed:   p.Compressed,
+			Uncompressed: p.Uncompressed,
+			Bits:         p.Bits,
+			WindowOffset: int64(start),
+			WindowLength: int64(windows.Len() - start),
+		})
+	}
+
+	compression := g.Compression
+	if compression == "" {
+		compression = CompressionGzip
+	}
+
+	code, ok := compressionCodes[compression]
+	if !ok {
+		return 0, fmt.Errorf("cannot index compression %q", compression)
+	}
+
+	cw := &countWriter{Writer: w}
+	hdr := gzIndexHeader{
+		Magic:        gzIndexMagic,
+		Compression:  code,
+		Uncompressed: g.Uncompressed,
+		Compressed:   g.Compressed,
+		Count:        uint32(len(points)),
+	}
+
+	err = binary.Write(cw, binary.BigEndian, hdr)
+	if err != nil {
+		return cw.n, fmt.Errorf("writing index header: %w", err)
+	}
+
+	err = binary.Write(cw, binary.BigEndian, points)
+	if err != nil {
+		return cw.n, fmt.Errorf("writing index points: %w", err)
+	}
+
+	_, err = windows.WriteTo(cw)
+	if err != nil {
+		return cw.n, fmt.Errorf("writing index windows: %w", err)
+	}
+
+	return cw.n, nil
+}
+
+// ReadGzIndex reads the point table of a serialized index. Windows are not
+// loaded; use LoadWindow on the point that is actually needed.
+func ReadGzIndex(r io.Reader) (*GzIndex, error) {
+	var magic [8]byte
+	_, err := io.ReadFull(r, magic[:])
+	if err != nil {
+		return nil, fmt.Errorf("reading index header: %w", err)
+	}
+
+	hdr := gzIndexHeader{Magic: magic}
+	var hdrSize int
+	switch magic {
+	case gzIndexMagic:
+		err = binary.Read(r, binary.BigEndian, &hdr.Compression)
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, &hdr.Uncompressed)
+		}
+		hdrSize = binary.Size(hdr)
+	case gzIndexMagicV1:
+		err = binary.Read(r, binary.BigEndian, &hdr.Uncompressed)
+		hdr.Compression = compressionCodes[CompressionGzip]
+		hdrSize = binary.Size(gzIndexHeaderV1{})
+	case [8]byte{}:
+		var id [8]byte
+		_, err = io.ReadFull(r, id[:])
+		if err == nil && bytes.HasPrefix(id[:], gztoolMagic) {
+			return nil, ErrGztoolIndex
+		}
+		return nil, fmt.Errorf("not a layer index (magic %q)", magic[:])
+	default:
+		return nil, fmt.Errorf("not a layer index (magic %q)", magic[:])
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &hdr.Compressed)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.BigEndian, &hdr.Count)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading index header: %w", err)
+	}
+
+	compression, err := compressionFromCode(hdr.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]gzIndexPoint, hdr.Count)
+	err = binary.Read(r, binary.BigEndian, points)
+	if err != nil {
+		return nil, fmt.Errorf("reading index points: %w", err)
+	}
+
+	base := int64(hdrSize) + int64(binary.Size(points))
+
+	g := &GzIndex{
+		Compression:  compression,
+		Uncompressed: hdr.Uncompressed,
+		Compressed:   hdr.Compressed,
+		Points:       make([]AccessPoint, 0, len(points)),
+	}
+
+	for _, p := range points {
+		g.Points = append(g.Points, AccessPoint{
+			Compressed:   p.Compressed,
+			Uncompressed: p.Uncompressed,
+			Bits:         p.Bits,
+			windowOffset: base + p.WindowOffset,
+			windowLength: p.WindowLength,
+		})
+	}
+
+	return g, nil
+}
+
+// LoadWindow populates p.Window from the serialized index that p was read from.
+func (p *AccessPoint) LoadWindow(r io.ReaderAt) error {
+	if p.Window != nil {
+		return nil
+	}
+
+	fr := flate.NewReader(io.NewSectionReader(r, p.windowOffset, p.windowLength))
+	defer fr.Close()
+
+	window, err := io.ReadAll(fr)
+	if err != nil {
+		return fmt.Errorf("decompressing window: %w", err)
+	}
+
+	p.Window = window
+	return nil
+}
+
+// NewReader returns the uncompressed stream starting at this access point.
+// compressed must begin one byte before p.Compressed. Closing the stream
+// releases the decompressor but does not close compressed.
+func (g *GzIndex) NewReader(p *AccessPoint, compressed io.Reader) (io.ReadCloser, error) {
+	switch g.Compression {
+	case CompressionZstd:
+		return resumeZstd(compressed)
+	default:
+		return resumeInflater(compressed, p.Bits, p.Window)
+	}
+}
+
+func OpenGzIndex(path string) (*GzIndex, *os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening gzip index: %w", err)
+	}
+
+	g, err := ReadGzIndex(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return g, f, nil
+}
+
+// IndexingReader decompresses a gzip stream while recording access points
+// at least span uncompressed bytes apart.
+type IndexingReader struct {
+	f     *inflater
+	span  int64
+	index GzIndex
+}
+
+func NewIndexingReader(gz io.Reader, span int64) *IndexingReader {
+	ir := &IndexingReader{f: newInflater(gz), span: span, index: GzIndex{Compression: CompressionGzip}}
+	ir.f.onBlock = ir.onBlock
+	return ir
+}
+
+func (ir *IndexingReader) onBlock() {
+	points := ir.index.Points
+	if len(points) > 0 && ir.f.total-points[len(points)-1].Uncompressed < ir.span {
+		return
+	}
+
+	compressed, bits := ir.f.blockPosition()
+	ir.index.Points = append(points, AccessPoint{
+		Compressed:   compressed,
+		Uncompressed: ir.f.total,
+		Bits:         bits,
+		Window:       ir.f.window(),
+	})
+}
+
+func (ir *IndexingReader) Read(p []byte) (int, error) {
+	return ir.f.Read(p)
+}
+
+// Index returns the access points recorded so far. It is complete once Read
+// has returned io.EOF.
+func (ir *IndexingReader) Index() *GzIndex {
+	ir.index.Uncompressed = ir.f.total
+	ir.index.Compressed = ir.f.in
+	return &ir.index
+}
+
+type countWriter struct {
+	io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
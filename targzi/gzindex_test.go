@@ -0,0 +1,333 @@
+package targzi
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// Extract records subsegments, which would otherwise complain loudly
+	// about the missing segment on every call
+	xray.SetLogger(xraylog.NullLogger)
+}
+
+// testLayer builds a tar stream that looks like a typical image layer: a
+// directory tree with text files, incompressible binaries, empty files and
+// links.
+func testLayer(t *testing.T, seed int64) []byte {
+	t.Helper()
+
+	rnd := rand.New(rand.NewSource(seed))
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	mtime := time.Unix(1660000000, 0)
+
+	words := strings.Fields("the quick brown fox jumps over the lazy dog lorem ipsum dolor sit amet usr lib bin etc")
+
+	for d := 0; d < 8; d++ {
+		dir := fmt.Sprintf("usr/share/dir%d/", d)
+		err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime})
+		require.NoError(t, err)
+
+		for f := 0; f < 20; f++ {
+			var body []byte
+			switch f % 4 {
+			case 0:
+				body = make([]byte, rnd.Intn(200_000))
+				rnd.Read(body)
+			case 1:
+				sb := &strings.Builder{}
+				for sb.Len() < rnd.Intn(300_000) {
+					sb.WriteString(words[rnd.Intn(len(words))])
+					sb.WriteByte(" \n"[rnd.Intn(2)])
+				}
+				body = []byte(sb.String())
+			case 2:
+				body = nil
+			case 3:
+				err = tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%slink%d", dir, f), Typeflag: tar.TypeSymlink, Linkname: "../dir0/file0", ModTime: mtime})
+				require.NoError(t, err)
+				continue
+			}
+
+			err = tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("%sfile%d", dir, f), Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body)), ModTime: mtime})
+			require.NoError(t, err)
+			_, err = tw.Write(body)
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func gzipLevel(t *testing.T, raw []byte, level, members int) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	chunk := (len(raw) + members - 1) / members
+	for start := 0; start < len(raw); start += chunk {
+		gzw, err := gzip.NewWriterLevel(buf, level)
+		require.NoError(t, err)
+		gzw.Name = "layer.tar"
+		_, err = gzw.Write(raw[start:min(start+chunk, len(raw))])
+		require.NoError(t, err)
+		require.NoError(t, gzw.Close())
+	}
+
+	return buf.Bytes()
+}
+
+var testCompressions = []struct {
+	name    string
+	level   int
+	members int
+}{
+	{name: "default", level: gzip.DefaultCompression, members: 1},
+	{name: "fastest", level: gzip.BestSpeed, members: 1},
+	{name: "stored", level: gzip.NoCompression, members: 1},
+	{name: "huffman", level: gzip.HuffmanOnly, members: 1},
+	{name: "multimember", level: gzip.DefaultCompression, members: 5},
+}
+
+func TestInflaterMatchesGzip(t *testing.T) {
+	raw := testLayer(t, 1)
+
+	for _, tc := range testCompressions {
+		t.Run(tc.name, func(t *testing.T) {
+			gz := gzipLevel(t, raw, tc.level, tc.members)
+
+			got, err := io.ReadAll(newInflater(bytes.NewReader(gz)))
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(raw, got))
+		})
+	}
+}
+
+func TestInflaterDetectsCorruption(t *testing.T) {
+	raw := testLayer(t, 2)
+	gz := gzipLevel(t, raw, gzip.DefaultCompression, 1)
+	gz[len(gz)-6] ^= 0xff // inside the crc32 trailer
+
+	_, err := io.ReadAll(newInflater(bytes.NewReader(gz)))
+	require.Error(t, err)
+}
+
+func TestGzIndexRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	raw := testLayer(t, 3)
+
+	for _, tc := range testCompressions {
+		t.Run(tc.name, func(t *testing.T) {
+			gz := gzipLevel(t, raw, tc.level, tc.members)
+
+			var fileCount int64
+			index, err := buildIndex(t.TempDir(), bytes.NewReader(gz), "", &fileCount, 256<<10)
+			require.NoError(t, err)
+			require.EqualValues(t, len(index.Entries), fileCount)
+
+			spans, err := index.Spans()
+			require.NoError(t, err)
+			require.Greater(t, len(spans), 4)
+			require.Equal(t, 1, spans[0].Number)
+			require.Equal(t, 0, spans[0].Uncompressed)
+
+			expected := map[string][]byte{}
+			tr := tar.NewReader(bytes.NewReader(raw))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				body, err := io.ReadAll(tr)
+				require.NoError(t, err)
+				expected[hdr.Name] = body
+			}
+
+			checked := 0
+			for _, entry := range index.Entries {
+				if entry.Hdr.Typeflag != tar.TypeReg {
+					continue
+				}
+
+				// this mirrors how the API turns an entry into a range request
+				start, end := SpanRange(spans, entry.Offset, int(entry.Hdr.Size))
+				if end == 0 {
+					end = len(gz) - 1
+				}
+				end++ // slice bounds are exclusive, unlike HTTP ranges
+
+				extracted, err := Extract(ctx, bytes.NewReader(gz[start-1:end]), index.GzIndexPath, start, entry.Offset, int(entry.Hdr.Size))
+				require.NoError(t, err)
+
+				body := &bytes.Buffer{}
+				if checked%2 == 0 {
+					_, err = extracted.WriteTo(body)
+				} else {
+					_, err = io.Copy(body, struct{ io.Reader }{extracted})
+				}
+				require.NoError(t, err)
+				require.True(t, bytes.Equal(expected[entry.Hdr.Name], body.Bytes()), entry.Hdr.Name)
+				checked++
+			}
+			require.Greater(t, checked, 50)
+		})
+	}
+}
+
+func TestGzIndexSerialization(t *testing.T) {
+	raw := testLayer(t, 4)
+	gz := gzipLevel(t, raw, gzip.DefaultCompression, 1)
+
+	ir := NewIndexingReader(bytes.NewReader(gz), 128<<10)
+	_, err := io.Copy(io.Discard, ir)
+	require.NoError(t, err)
+	built := ir.Index()
+	require.EqualValues(t, len(raw), built.Uncompressed)
+	require.EqualValues(t, len(gz), built.Compressed)
+
+	buf := &bytes.Buffer{}
+	_, err = built.WriteTo(buf)
+	require.NoError(t, err)
+
+	read, err := ReadGzIndex(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, built.Spans(), read.Spans())
+
+	for idx := range read.Points {
+		p := &read.Points[idx]
+		require.NoError(t, p.LoadWindow(bytes.NewReader(buf.Bytes())))
+		require.True(t, bytes.Equal(built.Points[idx].Window, p.Window))
+		require.Equal(t, built.Points[idx].Bits, p.Bits)
+
+		r, err := read.NewReader(p, bytes.NewReader(gz[p.Compressed-1:]))
+		require.NoError(t, err)
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(raw[p.Uncompressed:], rest))
+	}
+
+	_, err = ReadGzIndex(bytes.NewReader(gz))
+	require.Error(t, err)
+}
+
+func TestReadGztoolIndex(t *testing.T) {
+	for _, id := range []string{"gzipindx", "gzipindX"} {
+		gzi := append(make([]byte, 8), id...)
+		gzi = append(gzi, make([]byte, 16)...)
+
+		_, err := ReadGzIndex(bytes.NewReader(gzi))
+		require.ErrorIs(t, err, ErrGztoolIndex)
+	}
+
+	_, err := ReadGzIndex(bytes.NewReader(make([]byte, 32)))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrGztoolIndex)
+}
+
+func TestExtractTruncated(t *testing.T) {
+	ctx := context.Background()
+
+	raw := testLayer(t, 5)
+	gz := gzipLevel(t, raw, gzip.DefaultCompression, 1)
+
+	var fileCount int64
+	index, err := BuildIndex(t.TempDir(), bytes.NewReader(gz), &fileCount)
+	require.NoError(t, err)
+
+	var biggest *Entry
+	for _, entry := range index.Entries {
+		if biggest == nil || entry.Hdr.Size > biggest.Hdr.Size {
+			biggest = entry
+		}
+	}
+
+	extracted, err := Extract(ctx, bytes.NewReader(gz[9:len(gz)/2]), index.GzIndexPath, 10, biggest.Offset, len(raw))
+	require.NoError(t, err)
+
+	_, err = io.Copy(io.Discard, extracted)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestExtractSubRange(t *testing.T) {
+	ctx := context.Background()
+	raw := testLayer(t, 6)
+	gz := gzipLevel(t, raw, gzip.BestSpeed, 1)
+
+	var fileCount int64
+	index, err := buildIndex(t.TempDir(), bytes.NewReader(gz), "", &fileCount, 64<<10)
+	require.NoError(t, err)
+
+	spans, err := index.Spans()
+	require.NoError(t, err)
+
+	// a range of the whole (uncompressed) tar that straddles several spans
+	for _, rng := range [][2]int{{0, 10}, {100_000, 300_000}, {len(raw) - 1000, 1000}, {len(raw) / 2, 1}} {
+		offset, length := rng[0], rng[1]
+		start, end := SpanRange(spans, offset, length)
+		if end == 0 {
+			end = len(gz) - 1
+		}
+
+		extracted, err := Extract(ctx, bytes.NewReader(gz[start-1:end+1]), index.GzIndexPath, start, offset, length)
+		require.NoError(t, err)
+		body, err := io.ReadAll(extracted)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(raw[offset:offset+length], body), "range %d+%d", offset, length)
+	}
+}
+
+func TestBuildIndexUncompressed(t *testing.T) {
+	raw := testLayer(t, 10)
+
+	var fileCount int64
+	index, err := BuildIndex(t.TempDir(), bytes.NewReader(raw), &fileCount)
+	require.NoError(t, err)
+	require.Empty(t, index.GzIndexPath)
+	require.EqualValues(t, len(index.Entries), fileCount)
+
+	read, err := ReadIndex(filepath.Dir(index.FileIndexPath))
+	require.NoError(t, err)
+	require.Empty(t, read.GzIndexPath)
+	require.Len(t, read.Entries, len(index.Entries))
+
+	contents := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[filepath.Clean(hdr.Name)] = body
+	}
+
+	for _, entry := range read.Entries {
+		require.True(t, entry.Uncompressed)
+		require.Empty(t, entry.Spans)
+		if entry.Hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		body := raw[entry.Offset : entry.Offset+int(entry.Hdr.Size)]
+		require.True(t, bytes.Equal(contents[entry.Hdr.Name], body), entry.Hdr.Name)
+		require.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(body)), entry.Digest, entry.Hdr.Name)
+	}
+}
@@ -0,0 +1,735 @@
+package targzi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// inflater is a gzip/DEFLATE decoder that, unlike compress/gzip, can report
+// the bit position of every deflate block boundary and can resume decoding
+// from such a boundary given the preceding 32 KiB of output. That is the
+// minimum needed to build and use zran-style access points. Concatenated
+// gzip members are decoded as one continuous stream.
+
+const (
+	windowSize = 1 << 15
+	maxCodeLen = 15
+	fastBits   = 9
+)
+
+var errCorrupt = errors.New("corrupt deflate stream")
+
+var (
+	lenBase   = [...]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lenExtra  = [...]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase  = [...]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra = [...]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	clenOrder = [...]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+type huffman struct {
+	fast   [1 << fastBits]uint16 // symbol<<4 | length, zero means "use the slow path"
+	count  [maxCodeLen + 1]uint16
+	symbol [288]uint16
+}
+
+func (h *huffman) init(lengths []uint8) error {
+	*h = huffman{}
+
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	left := 1
+	for l := 1; l <= maxCodeLen; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return errCorrupt
+		}
+	}
+
+	var offs [maxCodeLen + 1]uint16
+	for l := 1; l < maxCodeLen; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+
+	var next [maxCodeLen + 1]int
+	code := 0
+	for l := 1; l <= maxCodeLen; l++ {
+		code = (code + int(h.count[l-1])) << 1
+		next[l] = code
+	}
+
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+
+		h.symbol[offs[l]] = uint16(sym)
+		offs[l]++
+
+		c := next[l]
+		next[l]++
+		if l > fastBits {
+			continue
+		}
+
+		rev := 0
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		for i := rev; i < len(h.fast); i += 1 << l {
+			h.fast[i] = uint16(sym)<<4 | uint16(l)
+		}
+	}
+
+	return nil
+}
+
+var (
+	fixedOnce sync.Once
+	fixedLit  huffman
+	fixedDist huffman
+)
+
+func fixedTables() (*huffman, *huffman) {
+	fixedOnce.Do(func() {
+		lengths := make([]uint8, 288)
+		for i := range lengths {
+			switch {
+			case i < 144:
+				lengths[i] = 8
+			case i < 256:
+				lengths[i] = 9
+			case i < 280:
+				lengths[i] = 7
+			default:
+				lengths[i] = 8
+			}
+		}
+		_ = fixedLit.init(lengths)
+
+		dists := make([]uint8, 30)
+		for i := range dists {
+			dists[i] = 5
+		}
+		_ = fixedDist.init(dists)
+	})
+
+	return &fixedLit, &fixedDist
+}
+
+type inflateState int
+
+const (
+	stateMemberHeader inflateState = iota
+	stateBlockHeader
+	stateStored
+	stateHuffman
+	stateTrailer
+	stateNextMember
+	stateDone
+)
+
+type flateReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type inflater struct {
+	r   flateReader
+	in  int64 // compressed bytes pulled into bitbuf, relative to the start of r
+	err error // sticky input error, only surfaced when bits are actually needed
+
+	bitbuf uint64
+	nbits  uint
+
+	hist   [windowSize]byte
+	wrPos  int
+	rdPos  int
+	full   bool
+	total  int64 // uncompressed bytes written to hist so far
+	outErr error
+
+	state    inflateState
+	final    bool
+	stored   int
+	lit      *huffman
+	dist     *huffman
+	dynLit   huffman
+	dynDist  huffman
+	copyLen  int
+	copyDist int
+
+	verify      bool
+	crc         uint32
+	crcPos      int
+	memberStart int64
+
+	// onBlock is invoked immediately before each deflate block header is
+	// decoded, at which point blockPosition describes a valid access point.
+	onBlock func()
+}
+
+func byteReader(r io.Reader) flateReader {
+	if br, ok := r.(flateReader); ok {
+		return br
+	}
+	return bufio.NewReaderSize(r, 1<<16)
+}
+
+// newInflater decodes a gzip stream from its very first byte.
+func newInflater(r io.Reader) *inflater {
+	return &inflater{r: byteReader(r), state: stateMemberHeader}
+}
+
+// resumeInflater decodes from an access point. r must begin at the byte that
+// contains the first bit of the block, i.e. one byte before the point's
+// compressed offset, and bits is the number of high bits of that byte that
+// belong to the block.
+func resumeInflater(r io.Reader, bits uint8, window []byte) (*inflater, error) {
+	f := &inflater{r: byteReader(r), state: stateBlockHeader}
+
+	b, err := f.r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("reading first byte of block: %w", err)
+	}
+	f.in = 1
+	if bits > 0 {
+		f.bitbuf = uint64(b >> (8 - bits))
+		f.nbits = uint(bits)
+	}
+
+	if len(window) > windowSize {
+		window = window[len(window)-windowSize:]
+	}
+	copy(f.hist[:], window)
+	f.wrPos = len(window)
+	f.rdPos = f.wrPos
+	f.crcPos = f.wrPos
+
+	return f, nil
+}
+
+// blockPosition returns the position of the next unread bit in the zran
+// convention: the offset of the first whole unread byte and the number of
+// bits of the byte before it that are still unread.
+func (f *inflater) blockPosition() (compressed int64, bits uint8) {
+	bitpos := f.in*8 - int64(f.nbits)
+	compressed = (bitpos + 7) / 8
+	return compressed, uint8(compressed*8 - bitpos)
+}
+
+// window returns a copy of the most recent (up to 32 KiB) output.
+func (f *inflater) window() []byte {
+	if !f.full {
+		return append([]byte(nil), f.hist[:f.wrPos]...)
+	}
+
+	w := make([]byte, 0, windowSize)
+	w = append(w, f.hist[f.wrPos:]...)
+	return append(w, f.hist[:f.wrPos]...)
+}
+
+func (f *inflater) fill() {
+	for f.nbits <= 56 && f.err == nil {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			f.err = err
+			return
+		}
+		f.bitbuf |= uint64(b) << f.nbits
+		f.nbits += 8
+		f.in++
+	}
+}
+
+func (f *inflater) need(n uint) error {
+	if f.nbits >= n {
+		return nil
+	}
+
+	f.fill()
+	if f.nbits >= n {
+		return nil
+	}
+
+	if f.err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return f.err
+}
+
+func (f *inflater) bits(n uint) (int, error) {
+	if err := f.need(n); err != nil {
+		return 0, err
+	}
+
+	v := int(f.bitbuf & (1<<n - 1))
+	f.bitbuf >>= n
+	f.nbits -= n
+	return v, nil
+}
+
+func (f *inflater) readByte() (byte, error) {
+	v, err := f.bits(8)
+	return byte(v), err
+}
+
+func (f *inflater) alignByte() {
+	drop := f.nbits % 8
+	f.bitbuf >>= drop
+	f.nbits -= drop
+}
+
+func (f *inflater) decodeSym(h *huffman) (int, error) {
+	if f.nbits < maxCodeLen {
+		f.fill()
+	}
+
+	if e := h.fast[f.bitbuf&(1<<fastBits-1)]; e != 0 && uint(e&15) <= f.nbits {
+		f.bitbuf >>= e & 15
+		f.nbits -= uint(e & 15)
+		return int(e >> 4), nil
+	}
+
+	code, first, index := 0, 0, 0
+	for l := uint(1); l <= maxCodeLen; l++ {
+		if l > f.nbits {
+			if f.err == io.EOF || f.err == nil {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, f.err
+		}
+
+		code |= int(f.bitbuf>>(l-1)) & 1
+		count := int(h.count[l])
+		if code-count < first {
+			f.bitbuf >>= l
+			f.nbits -= l
+			return int(h.symbol[index+(code-first)]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+
+	return 0, errCorrupt
+}
+
+func (f *inflater) Read(p []byte) (int, error) {
+	chunk, err := f.next(int64(len(p)))
+	return copy(p, chunk), err
+}
+
+// Close does nothing; an inflater holds no resources beyond its buffers.
+func (f *inflater) Close() error {
+	return nil
+}
+
+// next returns up to max bytes of decoded output without copying them out of
+// the window. The returned slice is only valid until the next call.
+func (f *inflater) next(max int64) ([]byte, error) {
+	for {
+		if f.rdPos < f.wrPos {
+			end := f.wrPos
+			if int64(end-f.rdPos) > max {
+				end = f.rdPos + int(max)
+			}
+			chunk := f.hist[f.rdPos:end]
+			f.rdPos = end
+			return chunk, nil
+		}
+
+		if f.outErr != nil {
+			return nil, f.outErr
+		}
+
+		if f.wrPos == windowSize {
+			f.updateCRC()
+			f.wrPos, f.rdPos, f.crcPos = 0, 0, 0
+			f.full = true
+		}
+
+		if err := f.step(); err != nil {
+			f.outErr = err
+		}
+	}
+}
+
+func (f *inflater) updateCRC() {
+	if f.verify {
+		f.crc = crc32.Update(f.crc, crc32.IEEETable, f.hist[f.crcPos:f.wrPos])
+	}
+	f.crcPos = f.wrPos
+}
+
+// step decodes until the window is full, or a state transition happens.
+func (f *inflater) step() error {
+	switch f.state {
+	case stateMemberHeader:
+		return f.memberHeader()
+	case stateBlockHeader:
+		return f.blockHeader()
+	case stateStored:
+		return f.storedBlock()
+	case stateHuffman:
+		return f.huffmanBlock()
+	case stateTrailer:
+		return f.trailer()
+	case stateNextMember:
+		f.alignByte()
+		if f.nbits == 0 {
+			f.fill()
+		}
+		if f.nbits == 0 && f.err == io.EOF {
+			f.state = stateDone
+			return nil
+		}
+		f.state = stateMemberHeader
+		return nil
+	default:
+		return io.EOF
+	}
+}
+
+func (f *inflater) memberHeader() error {
+	var hdr [10]byte
+	for i := range hdr {
+		b, err := f.readByte()
+		if err != nil {
+			return fmt.Errorf("reading gzip header: %w", err)
+		}
+		hdr[i] = b
+	}
+
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 {
+		return fmt.Errorf("invalid gzip header")
+	}
+
+	flg := hdr[3]
+	if flg&4 != 0 {
+		xlen, err := f.bits(16)
+		if err != nil {
+			return fmt.Errorf("reading gzip extra length: %w", err)
+		}
+		for i := 0; i < xlen; i++ {
+			if _, err := f.readByte(); err != nil {
+				return fmt.Errorf("reading gzip extra field: %w", err)
+			}
+		}
+	}
+
+	for _, mask := range []byte{8, 16} {
+		if flg&mask == 0 {
+			continue
+		}
+		for {
+			b, err := f.readByte()
+			if err != nil {
+				return fmt.Errorf("reading gzip header string: %w", err)
+			}
+			if b == 0 {
+				break
+			}
+		}
+	}
+
+	if flg&2 != 0 {
+		if _, err := f.bits(16); err != nil {
+			return fmt.Errorf("reading gzip header crc: %w", err)
+		}
+	}
+
+	f.updateCRC()
+	f.verify = true
+	f.crc = 0
+	f.memberStart = f.total
+	f.state = stateBlockHeader
+	return nil
+}
+
+func (f *inflater) blockHeader() error {
+	if f.onBlock != nil {
+		f.onBlock()
+	}
+
+	hdr, err := f.bits(3)
+	if err != nil {
+		return fmt.Errorf("reading block header: %w", err)
+	}
+	f.final = hdr&1 == 1
+
+	switch hdr >> 1 {
+	case 0:
+		f.alignByte()
+		n, err := f.bits(16)
+		if err != nil {
+			return fmt.Errorf("reading stored block length: %w", err)
+		}
+		nn, err := f.bits(16)
+		if err != nil {
+			return fmt.Errorf("reading stored block length: %w", err)
+		}
+		if n != ^nn&0xffff {
+			return errCorrupt
+		}
+		f.stored = n
+		f.state = stateStored
+	case 1:
+		f.lit, f.dist = fixedTables()
+		f.state = stateHuffman
+	case 2:
+		if err := f.dynamicTables(); err != nil {
+			return err
+		}
+		f.lit, f.dist = &f.dynLit, &f.dynDist
+		f.state = stateHuffman
+	default:
+		return errCorrupt
+	}
+
+	return nil
+}
+
+func (f *inflater) dynamicTables() error {
+	hlit, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	hdist, err := f.bits(5)
+	if err != nil {
+		return err
+	}
+	hclen, err := f.bits(4)
+	if err != nil {
+		return err
+	}
+
+	nlit, ndist := hlit+257, hdist+1
+	if nlit > 286 || ndist > 30 {
+		return errCorrupt
+	}
+
+	var clens [19]uint8
+	for i := 0; i < hclen+4; i++ {
+		v, err := f.bits(3)
+		if err != nil {
+			return err
+		}
+		clens[clenOrder[i]] = uint8(v)
+	}
+
+	var clh huffman
+	if err := clh.init(clens[:]); err != nil {
+		return err
+	}
+
+	lengths := make([]uint8, nlit+ndist)
+	for i := 0; i < len(lengths); {
+		sym, err := f.decodeSym(&clh)
+		if err != nil {
+			return err
+		}
+
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+
+		var val uint8
+		var rep int
+		switch sym {
+		case 16:
+			if i == 0 {
+				return errCorrupt
+			}
+			val = lengths[i-1]
+			rep, err = f.bits(2)
+			rep += 3
+		case 17:
+			rep, err = f.bits(3)
+			rep += 3
+		default:
+			rep, err = f.bits(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+rep > len(lengths) {
+			return errCorrupt
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = val
+			i++
+		}
+	}
+
+	if lengths[256] == 0 {
+		return errCorrupt
+	}
+
+	if err := f.dynLit.init(lengths[:nlit]); err != nil {
+		return err
+	}
+	return f.dynDist.init(lengths[nlit:])
+}
+
+func (f *inflater) endBlock() {
+	if f.final {
+		f.state = stateTrailer
+	} else {
+		f.state = stateBlockHeader
+	}
+}
+
+func (f *inflater) storedBlock() error {
+	// drain whole bytes left over in the bit buffer before touching r
+	for f.stored > 0 && f.wrPos < windowSize && f.nbits >= 8 {
+		f.hist[f.wrPos] = byte(f.bitbuf)
+		f.bitbuf >>= 8
+		f.nbits -= 8
+		f.wrPos++
+		f.total++
+		f.stored--
+	}
+
+	if f.stored > 0 && f.wrPos < windowSize {
+		n := min(f.stored, windowSize-f.wrPos)
+		n, err := io.ReadFull(f.r, f.hist[f.wrPos:f.wrPos+n])
+		f.in += int64(n)
+		f.wrPos += n
+		f.total += int64(n)
+		f.stored -= n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+
+	if f.stored == 0 {
+		f.endBlock()
+	}
+	return nil
+}
+
+func (f *inflater) huffmanBlock() error {
+	for f.wrPos < windowSize {
+		if f.copyLen > 0 {
+			f.copyMatch()
+			continue
+		}
+
+		sym, err := f.decodeSym(f.lit)
+		if err != nil {
+			return err
+		}
+
+		if sym < 256 {
+			f.hist[f.wrPos] = byte(sym)
+			f.wrPos++
+			f.total++
+			continue
+		}
+
+		if sym == 256 {
+			f.endBlock()
+			return nil
+		}
+
+		sym -= 257
+		if sym >= len(lenBase) {
+			return errCorrupt
+		}
+		extra, err := f.bits(lenExtra[sym])
+		if err != nil {
+			return err
+		}
+		length := lenBase[sym] + extra
+
+		dsym, err := f.decodeSym(f.dist)
+		if err != nil {
+			return err
+		}
+		if dsym >= len(distBase) {
+			return errCorrupt
+		}
+		extra, err = f.bits(distExtra[dsym])
+		if err != nil {
+			return err
+		}
+		dist := distBase[dsym] + extra
+
+		if !f.full && dist > f.wrPos {
+			return errCorrupt
+		}
+
+		f.copyLen, f.copyDist = length, dist
+	}
+
+	return nil
+}
+
+func (f *inflater) copyMatch() {
+	src := f.wrPos - f.copyDist
+	if src < 0 {
+		src += windowSize
+	}
+
+	for f.copyLen > 0 && f.wrPos < windowSize {
+		n := min(f.copyLen, windowSize-f.wrPos, windowSize-src)
+		if f.copyDist >= n {
+			copy(f.hist[f.wrPos:f.wrPos+n], f.hist[src:src+n])
+		} else {
+			for i := 0; i < n; i++ {
+				f.hist[f.wrPos+i] = f.hist[src+i]
+			}
+		}
+
+		f.wrPos += n
+		f.total += int64(n)
+		f.copyLen -= n
+		src += n
+		if src == windowSize {
+			src = 0
+		}
+	}
+}
+
+func (f *inflater) trailer() error {
+	f.alignByte()
+
+	var tr [8]byte
+	for i := range tr {
+		b, err := f.readByte()
+		if err != nil {
+			return fmt.Errorf("reading gzip trailer: %w", err)
+		}
+		tr[i] = b
+	}
+
+	f.updateCRC()
+	if f.verify {
+		if binary.LittleEndian.Uint32(tr[:4]) != f.crc {
+			return fmt.Errorf("gzip checksum mismatch")
+		}
+		if binary.LittleEndian.Uint32(tr[4:]) != uint32(f.total-f.memberStart) {
+			return fmt.Errorf("gzip size mismatch")
+		}
+	}
+
+	f.state = stateNextMember
+	return nil
+}
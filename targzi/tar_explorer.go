@@ -1,46 +1,39 @@
 package targzi
 
 import (
-	"browseimage/s3select"
+	"browseimage/storage"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"os"
-	"strings"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type TarExplorer struct {
-	s3          *s3.Client
-	downloader  *manager.Downloader
-	bucket      string
+	storage     storage.Storage
 	gzIndexPath string
 }
 
-func NewTarExplorer(s3 *s3.Client, bucket string) *TarExplorer {
-	return &TarExplorer{
-		s3:         s3,
-		downloader: manager.NewDownloader(s3),
-		bucket:     bucket,
-	}
+func NewTarExplorer(storage storage.Storage) *TarExplorer {
+	return &TarExplorer{storage: storage}
 }
 
-func (te *TarExplorer) FileContents(ctx context.Context, key, file string) ([]byte, error) {
+func (te *TarExplorer) FileContents(ctx context.Context, layer, file string) ([]byte, error) {
 	if te.gzIndexPath == "" {
 		indexFile, err := os.CreateTemp("", "index*")
 		if err != nil {
 			return nil, fmt.Errorf("making file for index: %w", err)
 		}
 
-		_, err = te.downloader.Download(ctx, indexFile, &s3.GetObjectInput{
-			Bucket: &te.bucket,
-			Key:    aws.String(fmt.Sprintf("%s/index.gzi", key)),
-		})
+		body, err := te.storage.Get(ctx, storage.GzIndexKey(layer))
+		if err != nil {
+			return nil, fmt.Errorf("downloading index file: %w", err)
+		}
+
+		_, err = io.Copy(indexFile, body)
+		body.Close()
 		if err != nil {
 			return nil, fmt.Errorf("downloading index file: %w", err)
 		}
@@ -53,10 +46,9 @@ func (te *TarExplorer) FileContents(ctx context.Context, key, file string) ([]by
 		te.gzIndexPath = indexFile.Name()
 	}
 
-	// Escape single quotes for S3 Select SQL (S3 Select uses '' escaping, not backslash)
-	escapedFile := strings.ReplaceAll(file, "'", "''")
-	query := fmt.Sprintf("SELECT * FROM s3object s WHERE s.Hdr.Name = '%s'", escapedFile)
-	entries, err := s3select.Select[Entry](ctx, te.s3, te.bucket, fmt.Sprintf("%s/files.json.gz", key), query)
+	entries, err := te.filter(ctx, layer, func(e *Entry) bool {
+		return e.Hdr.Name == file
+	})
 	if err != nil {
 		return nil, fmt.Errorf("querying file index: %w", err)
 	}
@@ -84,21 +76,7 @@ func (te *TarExplorer) FileContents(ctx context.Context, key, file string) ([]by
 		return nil, fmt.Errorf("reading spans: %w", err)
 	}
 
-	//span := IndexSpan{}
-	start := 0
-	end := 0
-	firstSpan := entry.Spans[0]
-	lastSpan := entry.Spans[len(entry.Spans)-1]
-	for idx, s := range spans {
-		if s.Number == firstSpan {
-			start = s.Compressed
-		}
-		if s.Number == lastSpan {
-			if idx+1 < len(spans) {
-				end = spans[idx+1].Compressed
-			}
-		}
-	}
+	start, end := SpanRange(spans, entry.Offset, int(entry.Hdr.Size))
 
 	rangeHdr := fmt.Sprintf("bytes=%d-", start-1)
 	if end > 0 {
@@ -116,15 +94,38 @@ func (te *TarExplorer) FileContents(ctx context.Context, key, file string) ([]by
 	if err != nil {
 		return nil, fmt.Errorf("extracting: %w", err)
 	}
+	defer extracted.Close()
 
-	return extracted, nil
+	return io.ReadAll(extracted)
 }
 
-func (te *TarExplorer) ListDirectory(ctx context.Context, key, dir string) ([]Entry, error) {
-	// Escape single quotes for S3 Select SQL (S3 Select uses '' escaping, not backslash)
-	escapedDir := strings.ReplaceAll(dir, "'", "''")
-	query := fmt.Sprintf("SELECT * FROM s3object s WHERE s.Parent = '%s'", escapedDir)
-	return s3select.Select[Entry](ctx, te.s3, te.bucket, fmt.Sprintf("%s/files.json.gz", key), query)
+func (te *TarExplorer) ListDirectory(ctx context.Context, layer, dir string) ([]Entry, error) {
+	return te.filter(ctx, layer, func(e *Entry) bool {
+		return e.Parent == dir
+	})
+}
+
+// filter reads a layer's whole file index, as layer indexes aren't sorted
+// for range requests the way image indexes are.
+func (te *TarExplorer) filter(ctx context.Context, layer string, match func(e *Entry) bool) ([]Entry, error) {
+	body, err := te.storage.Get(ctx, storage.FileIndexKey(layer))
+	if err != nil {
+		return nil, fmt.Errorf("getting file index: %w", err)
+	}
+	defer body.Close()
+
+	entries := []Entry{}
+	err = ReadFileIndex(body, func(e *Entry) error {
+		if match(e) {
+			entries = append(entries, *e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
 }
 
 type transport struct {
@@ -3,20 +3,20 @@ package targzi
 import (
 	"archive/tar"
 	"bufio"
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-xray-sdk-go/xray"
 	"github.com/klauspost/compress/gzip"
 	"io"
+	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 )
 
@@ -25,11 +25,24 @@ type Entry struct {
 	Spans  []int
 	Hdr    tar.Header
 	Parent string
+
+	// Uncompressed is set for entries of layers that aren't compressed at
+	// all. Offset is then also the entry's offset into the layer blob, and
+	// there are no spans.
+	Uncompressed bool `json:",omitempty"`
+
+	// Chunks is set for regular files in eStargz layers, which are indexed
+	// from their table of contents alone. Offset and Spans are then unknown.
+	Chunks []Chunk `json:",omitempty"`
+
+	// Digest is the SHA-256 of a regular file's contents, as
+	// "sha256:<hex>".
+	Digest string `json:",omitempty"`
 }
 
 type Index struct {
 	Entries       []*Entry
-	GzIndexPath   string
+	GzIndexPath   string // empty for uncompressed layers
 	FileIndexPath string
 }
 
@@ -40,13 +53,38 @@ type IndexSpan struct {
 }
 
 func (i *Index) Spans() ([]IndexSpan, error) {
+	if i.GzIndexPath == "" {
+		return nil, nil
+	}
 	return Spans(i.GzIndexPath)
 }
 
+// SpanRange returns the compressed bytes that must be fetched to extract
+// length bytes at uncompressedOffset. start is the compressed offset of the
+// span containing the first byte (the skipped argument to Extract) and end is
+// the compressed offset of the first span after the last byte, or 0 if the
+// range runs to the end of the layer. The HTTP range to request is therefore
+// "bytes=<start-1>-<end>", or "bytes=<start-1>-" when end is 0.
+func SpanRange(spans []IndexSpan, uncompressedOffset, length int) (start, end int) {
+	for _, s := range spans {
+		if s.Uncompressed <= uncompressedOffset {
+			start = s.Compressed
+		} else if s.Uncompressed > uncompressedOffset+length {
+			end = s.Compressed
+			break
+		}
+	}
+
+	return start, end
+}
+
 func ReadIndex(dir string) (*Index, error) {
 	gzIndexPath := fmt.Sprintf("%s/index.gzi", dir)
 	_, err := Spans(gzIndexPath)
-	if err != nil {
+	if errors.Is(err, fs.ErrNotExist) {
+		// uncompressed layers have no gzip index
+		gzIndexPath = ""
+	} else if err != nil {
 		return nil, fmt.Errorf("reading index spans: %w", err)
 	}
 
@@ -57,92 +95,250 @@ func ReadIndex(dir string) (*Index, error) {
 	}
 	defer findex.Close()
 
-	gzr, err := gzip.NewReader(findex)
+	entries := []*Entry{}
+	err = ReadFileIndex(findex, func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("gunzipping file index: %w", err)
+		return nil, err
 	}
-	defer gzr.Close()
 
-	entries := []*Entry{}
+	return &Index{
+		Entries:       entries,
+		GzIndexPath:   gzIndexPath,
+		FileIndexPath: fileIndexPath,
+	}, nil
+}
+
+// ReadFileIndex calls fn for each entry of a gzipped JSON lines file index,
+// as written by BuildIndex.
+func ReadFileIndex(r io.Reader, fn func(e *Entry) error) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gunzipping file index: %w", err)
+	}
+	defer gzr.Close()
 
 	scan := bufio.NewScanner(gzr)
+	scan.Buffer(nil, 1<<20)
 	for scan.Scan() {
-		e := Entry{}
-		err = json.Unmarshal(scan.Bytes(), &e)
+		e := &Entry{}
+		err = json.Unmarshal(scan.Bytes(), e)
 		if err != nil {
-			return nil, fmt.Errorf("unmarshalling entry: %w", err)
+			return fmt.Errorf("unmarshalling entry: %w", err)
 		}
 
-		entries = append(entries, &e)
+		err = fn(e)
+		if err != nil {
+			return err
+		}
 	}
 
-	return &Index{
-		Entries:       entries,
-		GzIndexPath:   gzIndexPath,
-		FileIndexPath: fileIndexPath,
-	}, nil
+	err = scan.Err()
+	if err != nil {
+		return fmt.Errorf("reading file index: %w", err)
+	}
+
+	return nil
 }
 
-func (i *Index) Extract(ctx context.Context, gz io.Reader, skipped, uncompressedOffset, length int) ([]byte, error) {
+func (i *Index) Extract(ctx context.Context, gz io.Reader, skipped, uncompressedOffset, length int) (*Extraction, error) {
 	return Extract(ctx, gz, i.GzIndexPath, skipped, uncompressedOffset, length)
 }
 
-func Extract(ctx context.Context, gz io.Reader, gzIndexPath string, skipped, uncompressedOffset, length int) ([]byte, error) {
-	_, seg := xray.BeginSubsegment(ctx, "gztool")
-	defer seg.Close(nil)
+// Extract returns length bytes of uncompressed data starting at
+// uncompressedOffset. gz must be the compressed layer starting one byte
+// before the compressed offset skipped, which is itself the offset of one of
+// the index's spans. Data is decompressed lazily as the returned Extraction
+// is read.
+func Extract(ctx context.Context, gz io.Reader, gzIndexPath string, skipped, uncompressedOffset, length int) (*Extraction, error) {
+	_, seg := xray.BeginSubsegment(ctx, "extract")
+
+	gzIndex, f, err := OpenGzIndex(gzIndexPath)
+	if err != nil {
+		seg.Close(err)
+		return nil, err
+	}
+	defer f.Close()
 
-	cmd := exec.Command(
-		"gztool",
-		"-I", gzIndexPath,
-		"-n", fmt.Sprintf("%d", skipped),
-		"-b", fmt.Sprintf("%d", uncompressedOffset),
-		"-r", fmt.Sprintf("%d", length),
-	)
+	point, err := gzIndex.Point(int64(skipped), int64(uncompressedOffset))
+	if err != nil {
+		seg.Close(err)
+		return nil, err
+	}
 
-	stdout := &bytes.Buffer{}
-	cmd.Stdout = stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = gz
+	err = point.LoadWindow(f)
+	if err != nil {
+		seg.Close(err)
+		return nil, fmt.Errorf("loading window: %w", err)
+	}
 
-	err := cmd.Run()
+	// gz starts one byte before skipped, just like the point itself
+	_, err = io.CopyN(io.Discard, gz, point.Compressed-int64(skipped))
 	if err != nil {
-		return nil, fmt.Errorf("running gztool: %w", err)
+		seg.Close(err)
+		return nil, fmt.Errorf("seeking to access point: %w", err)
 	}
 
-	return stdout.Bytes(), nil
+	r, err := gzIndex.NewReader(point, gz)
+	if err != nil {
+		seg.Close(err)
+		return nil, fmt.Errorf("resuming decompression: %w", err)
+	}
+
+	_, err = io.CopyN(io.Discard, r, int64(uncompressedOffset)-point.Uncompressed)
+	if err != nil {
+		r.Close()
+		seg.Close(err)
+		return nil, fmt.Errorf("skipping to offset: %w", err)
+	}
+
+	return &Extraction{f: r, remaining: int64(length), seg: seg}, nil
 }
 
+// Extraction streams the uncompressed bytes of a single range of a layer.
+// Reading past the end of the requested range returns io.EOF; running out of
+// compressed input before then returns io.ErrUnexpectedEOF.
+type Extraction struct {
+	f         io.ReadCloser
+	remaining int64
+	seg       *xray.Segment
+	closeOnce sync.Once
+}
+
+func (e *Extraction) Read(p []byte) (int, error) {
+	if e.remaining <= 0 {
+		e.Close()
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > e.remaining {
+		p = p[:e.remaining]
+	}
+
+	n, err := e.f.Read(p)
+	e.remaining -= int64(n)
+	if err == io.EOF && e.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		e.closeWith(err)
+	}
+
+	return n, err
+}
+
+// WriteTo writes gzip data directly out of the decompression window, avoiding
+// the intermediate buffer that io.Copy would otherwise allocate.
+func (e *Extraction) WriteTo(w io.Writer) (int64, error) {
+	f, ok := e.f.(*inflater)
+	if !ok {
+		n, err := io.Copy(w, struct{ io.Reader }{e})
+		if err == nil {
+			e.Close()
+		}
+		return n, err
+	}
+
+	var written int64
+	for e.remaining > 0 {
+		chunk, err := f.next(e.remaining)
+		if len(chunk) > 0 {
+			n, werr := w.Write(chunk)
+			written += int64(n)
+			e.remaining -= int64(n)
+			if werr != nil {
+				e.Close()
+				return written, werr
+			}
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			e.closeWith(err)
+			return written, err
+		}
+	}
+
+	e.Close()
+	return written, nil
+}
+
+// Close releases the decompressor and ends the X-Ray subsegment covering the
+// extraction. It does not close the compressed reader passed to Extract.
+func (e *Extraction) Close() error {
+	e.closeWith(nil)
+	return nil
+}
+
+func (e *Extraction) closeWith(err error) {
+	e.closeOnce.Do(func() {
+		e.f.Close()
+		e.seg.Close(err)
+	})
+}
+
+// BuildIndex indexes a compressed layer blob. The compression is detected
+// from the blob itself.
 func BuildIndex(root string, gz io.Reader, fileCounter *int64) (*Index, error) {
+	return buildIndex(root, gz, "", fileCounter, DefaultSpan)
+}
+
+// BuildIndexWithCompression is BuildIndex for callers that already know the
+// layer's compression, e.g. from its media type.
+func BuildIndexWithCompression(root string, r io.Reader, compression Compression, fileCounter *int64) (*Index, error) {
+	return buildIndex(root, r, compression, fileCounter, DefaultSpan)
+}
+
+// indexingReader decompresses a layer while recording access points into it.
+type indexingReader interface {
+	io.Reader
+	Index() *GzIndex
+}
+
+func newIndexingReader(r io.Reader, compression Compression, span int64) (indexingReader, error) {
+	switch compression {
+	case CompressionGzip:
+		return NewIndexingReader(r, span), nil
+	case CompressionZstd:
+		return newZstdIndexingReader(r, span)
+	default:
+		return nil, fmt.Errorf("cannot index compression %q", compression)
+	}
+}
+
+func buildIndex(root string, gz io.Reader, compression Compression, fileCounter *int64, span int64) (*Index, error) {
 	dir, err := os.MkdirTemp(root, "targzi*")
 	if err != nil {
 		return nil, fmt.Errorf("creating temp dir: %w", err)
 	}
 
 	fileIndexPath := fmt.Sprintf("%s/files.json.gz", dir)
-	findex, err := os.Create(fileIndexPath)
-	if err != nil {
-		return nil, fmt.Errorf("creating file index: %w", err)
-	}
-	defer findex.Close()
 
-	gzIndexPath := fmt.Sprintf("%s/index.gzi", dir)
-
-	cmd := exec.Command("gztool", "-I", gzIndexPath, "-b", "0")
-	cmd.Stdin = gz
-	cmd.Stderr = os.Stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("creating stdout pipe: %w", err)
+	if compression == "" {
+		compression, gz, err = SniffCompression(gz)
+		if err != nil {
+			return nil, fmt.Errorf("detecting layer compression: %w", err)
+		}
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return nil, fmt.Errorf("starting gztool: %w", err)
+	// uncompressed layers need no gzip index: tar offsets are blob offsets
+	var ir indexingReader
+	tarStream := gz
+	if compression != CompressionNone {
+		ir, err = newIndexingReader(gz, compression, span)
+		if err != nil {
+			return nil, fmt.Errorf("starting decompression: %w", err)
+		}
+		tarStream = ir
 	}
 
 	entries := []*Entry{}
 
-	off := &offsetReporter{Reader: stdout}
+	off := &offsetReporter{Reader: tarStream}
 	tr := tar.NewReader(off)
 	for {
 		hdr, err := tr.Next()
@@ -156,31 +352,99 @@ func BuildIndex(root string, gz io.Reader, fileCounter *int64) (*Index, error) {
 		//idx := strings.LastIndex(hdr.Name, "/")
 		//hdr.Name = hdr.Name[:idx] + "#" + hdr.Name[idx+1:]
 
-		hdr.Name = filepath.Clean(hdr.Name)
-		if hdr.Name == "." {
-			hdr.Name = "/"
-		}
+		parent := cleanHeaderName(hdr)
 
-		parent := filepath.Dir(hdr.Name)
-		if parent == "." {
-			parent = "/"
-		} else if parent != "/" {
-			parent += "/"
+		entry := &Entry{
+			Hdr:          *hdr,
+			Offset:       off.offset,
+			Parent:       parent,
+			Uncompressed: ir == nil,
+		}
+		entries = append(entries, entry)
+
+		// the tar reader would read past the contents anyway
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			_, err = io.Copy(h, tr)
+			if err != nil {
+				return nil, fmt.Errorf("hashing %s: %w", hdr.Name, err)
+			}
+			entry.Digest = fmt.Sprintf("sha256:%x", h.Sum(nil))
 		}
 
-		if hdr.Name != "/" && hdr.FileInfo().IsDir() {
-			hdr.Name += "/"
+		atomic.AddInt64(fileCounter, 1)
+	}
+
+	sortEntries(entries)
+
+	// the tar reader stops at the end-of-archive marker, but the index (and
+	// the gzip or zstd checksum) needs the whole stream
+	_, err = io.Copy(io.Discard, tarStream)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing remainder: %w", err)
+	}
+
+	gzIndexPath := ""
+	if ir != nil {
+		gzIndexPath = fmt.Sprintf("%s/index.gzi", dir)
+		gzIndex := ir.Index()
+		err = writeGzIndex(gzIndexPath, gzIndex)
+		if err != nil {
+			return nil, err
 		}
 
-		entries = append(entries, &Entry{
-			Hdr:    *hdr,
-			Offset: off.offset,
-			Parent: parent,
-		})
+		assignSpans(entries, gzIndex.Spans())
+	}
 
-		atomic.AddInt64(fileCounter, 1)
+	err = writeFileIndex(fileIndexPath, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{
+		Entries:       entries,
+		GzIndexPath:   gzIndexPath,
+		FileIndexPath: fileIndexPath,
+	}, nil
+}
+
+// cleanHeaderName normalises hdr.Name the way file indexes store names:
+// relative, with a trailing slash for directories and "/" for the root. It
+// returns the name of the parent directory.
+func cleanHeaderName(hdr *tar.Header) string {
+	hdr.Name = filepath.Clean(hdr.Name)
+	if hdr.Name == "." {
+		hdr.Name = "/"
+	}
+
+	parent := Parent(hdr.Name)
+
+	if hdr.Name != "/" && hdr.FileInfo().IsDir() {
+		hdr.Name += "/"
+	}
+
+	return parent
+}
+
+// Parent returns the Parent of an entry named name. Like entry names,
+// parents other than the root end in a slash.
+func Parent(name string) string {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" {
+		return "/"
 	}
 
+	parent := filepath.Dir(name)
+	if parent == "." {
+		return "/"
+	} else if parent != "/" {
+		parent += "/"
+	}
+	return parent
+}
+
+// sortEntries orders entries breadth-first, and by name within a depth.
+func sortEntries(entries []*Entry) {
 	sort.Slice(entries, func(i, j int) bool {
 		iname := strings.TrimSuffix(entries[i].Hdr.Name, "/")
 		isplit := strings.Split(iname, "/")
@@ -204,17 +468,35 @@ func BuildIndex(root string, gz io.Reader, fileCounter *int64) (*Index, error) {
 		//
 		////return entries[i].Hdr.Name < entries[j].Hdr.Name
 	})
+}
 
-	err = cmd.Wait()
+// writeFileIndex writes entries as gzipped JSON lines.
+func writeFileIndex(path string, entries []*Entry) error {
+	findex, err := os.Create(path)
 	if err != nil {
-		return nil, fmt.Errorf("gztool exit: %w", err)
+		return fmt.Errorf("creating file index: %w", err)
 	}
+	defer findex.Close()
 
-	spans, err := Spans(gzIndexPath)
+	gzw := gzip.NewWriter(findex)
+
+	for _, entry := range entries {
+		j, _ := json.Marshal(entry)
+		gzw.Write(j)
+		gzw.Write([]byte{'\n'})
+	}
+
+	err = gzw.Close()
 	if err != nil {
-		return nil, fmt.Errorf("Spans: %w", err)
+		return fmt.Errorf("closing file index: %w", err)
 	}
 
+	return findex.Close()
+}
+
+// assignSpans records on each entry the numbers of the spans its contents
+// fall in.
+func assignSpans(entries []*Entry, spans []IndexSpan) {
 	sort.Slice(spans, func(i, j int) bool {
 		return spans[i].Uncompressed > spans[j].Uncompressed
 	})
@@ -234,55 +516,31 @@ func BuildIndex(root string, gz io.Reader, fileCounter *int64) (*Index, error) {
 
 		sort.Ints(entry.Spans)
 	}
+}
 
-	gzw := gzip.NewWriter(findex)
-
-	for _, entry := range entries {
-		j, _ := json.Marshal(entry)
-		gzw.Write(j)
-		gzw.Write([]byte{'\n'})
-	}
-
-	err = gzw.Close()
+func Spans(path string) ([]IndexSpan, error) {
+	gzIndex, f, err := OpenGzIndex(path)
 	if err != nil {
-		return nil, fmt.Errorf("closing file index: %w", err)
+		return nil, err
 	}
+	defer f.Close()
 
-	return &Index{
-		Entries:       entries,
-		GzIndexPath:   gzIndexPath,
-		FileIndexPath: fileIndexPath,
-	}, nil
+	return gzIndex.Spans(), nil
 }
 
-func Spans(path string) ([]IndexSpan, error) {
-	stdout := &bytes.Buffer{}
-
-	cmd := exec.Command("gztool", "-ll", path)
-	cmd.Stdout = stdout
-	cmd.Stderr = io.Discard
-	err := cmd.Run()
+func writeGzIndex(path string, gzIndex *GzIndex) error {
+	f, err := os.Create(path)
 	if err != nil {
-		return nil, fmt.Errorf("running gztool introspection: %w", err)
+		return fmt.Errorf("creating gzip index: %w", err)
 	}
+	defer f.Close()
 
-	spans := []IndexSpan{}
-
-	re := regexp.MustCompile(`#(\d+): @ (\d+) / (\d+)`)
-	matches := re.FindAllStringSubmatch(stdout.String(), -1)
-	for _, m := range matches {
-		number, _ := strconv.Atoi(m[1])
-		compressed, _ := strconv.Atoi(m[2])
-		uncompressed, _ := strconv.Atoi(m[3])
-
-		spans = append(spans, IndexSpan{
-			Number:       number,
-			Uncompressed: uncompressed,
-			Compressed:   compressed,
-		})
+	_, err = gzIndex.WriteTo(f)
+	if err != nil {
+		return fmt.Errorf("writing gzip index: %w", err)
 	}
 
-	return spans, nil
+	return f.Close()
 }
 
 type offsetReporter struct {
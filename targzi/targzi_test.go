@@ -1,10 +1,10 @@
 package targzi
 
 import (
+	"browseimage/storage"
 	"context"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -22,16 +22,11 @@ func TestFileContents(t *testing.T) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile("ak2-dev"))
 	require.NoError(t, err)
 
-	api := s3.NewFromConfig(cfg)
-	te := &TarExplorer{
-		s3:         api,
-		downloader: manager.NewDownloader(api),
-		bucket:     "browseimage-bucket-1oax5dhlqcoxp",
-	}
+	te := NewTarExplorer(storage.NewS3(s3.NewFromConfig(cfg), "browseimage-bucket-1oax5dhlqcoxp"))
 
 	path := "etc/apt/apt.conf.d/docker-autoremove-suggests"
 	//path := "usr/share/common-licenses/LGPL-2.1"
-	contents, err := te.FileContents(ctx, "layers/sha256:a603fa5e3b4127f210503aaa6189abf6286ee5a73deeaab460f8f33ebc6b64e2", path)
+	contents, err := te.FileContents(ctx, "sha256:a603fa5e3b4127f210503aaa6189abf6286ee5a73deeaab460f8f33ebc6b64e2", path)
 	require.NoError(t, err)
 
 	fmt.Println(string(contents))
@@ -43,10 +38,7 @@ func TestSelect(t *testing.T) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile("ak2-dev"))
 	require.NoError(t, err)
 
-	dl := &TarExplorer{
-		s3:     s3.NewFromConfig(cfg),
-		bucket: "browseimage-bucket-1oax5dhlqcoxp",
-	}
+	dl := NewTarExplorer(storage.NewS3(s3.NewFromConfig(cfg), "browseimage-bucket-1oax5dhlqcoxp"))
 
 	entries, err := dl.ListDirectory(ctx, "test", "usr/bin")
 	require.NoError(t, err)
@@ -204,7 +196,8 @@ func TestBuildIndex(t *testing.T) {
 	require.NoError(t, err)
 
 	wd, _ := os.Getwd()
-	index, err := BuildIndex(wd, in)
+	var fileCount int64
+	index, err := BuildIndex(wd, in, &fileCount)
 	require.NoError(t, err)
 	require.NotNil(t, index)
 
@@ -0,0 +1,246 @@
+package targzi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstd frames are independent of each other, so every frame start is a
+// natural access point that needs no window. Layers produced by the usual
+// tools are often a single frame, in which case the index has one point at
+// the start of the blob and extraction has to decompress from there.
+//
+// To keep the range arithmetic shared with gzip (callers always fetch from
+// one byte before a span's compressed offset), a zstd point's Compressed is
+// one past the first byte of its frame.
+
+const (
+	zstdFrameMagic     = 0xfd2fb528
+	zstdSkippableMagic = 0x184d2a50
+	zstdSkippableMask  = 0xfffffff0
+)
+
+// zstdFrames splits a zstd stream into its frames by parsing frame and block
+// headers, without decompressing anything.
+type zstdFrames struct {
+	r   *bufio.Reader
+	off int64 // compressed offset of the next unread byte
+}
+
+// next skips any skippable frames and returns a reader for exactly the bytes
+// of the next frame, or io.EOF at the end of the stream.
+func (z *zstdFrames) next() (*zstdFrame, error) {
+	for {
+		head, err := z.r.Peek(4)
+		if err == io.EOF && len(head) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd frame magic: %w", err)
+		}
+
+		magic := binary.LittleEndian.Uint32(head)
+		if magic&zstdSkippableMask == zstdSkippableMagic {
+			head, err = z.r.Peek(8)
+			if err != nil {
+				return nil, fmt.Errorf("reading skippable frame header: %w", err)
+			}
+			n := 8 + int64(binary.LittleEndian.Uint32(head[4:]))
+			discarded, err := z.r.Discard(int(n))
+			z.off += int64(discarded)
+			if err != nil {
+				return nil, fmt.Errorf("skipping skippable frame: %w", err)
+			}
+			continue
+		}
+
+		if magic != zstdFrameMagic {
+			return nil, fmt.Errorf("invalid zstd frame magic %#x at offset %d", magic, z.off)
+		}
+
+		head, err = z.r.Peek(5)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd frame header: %w", err)
+		}
+
+		fhd := head[4]
+		singleSegment := fhd&0x20 != 0
+		size := 5
+		if !singleSegment {
+			size++ // window descriptor
+		}
+		size += []int{0, 1, 2, 4}[fhd&3]
+		switch fhd >> 6 {
+		case 0:
+			if singleSegment {
+				size++
+			}
+		case 1:
+			size += 2
+		case 2:
+			size += 4
+		case 3:
+			size += 8
+		}
+
+		return &zstdFrame{
+			frames:    z,
+			start:     z.off,
+			remaining: int64(size),
+			checksum:  fhd&4 != 0,
+		}, nil
+	}
+}
+
+type zstdFrame struct {
+	frames    *zstdFrames
+	start     int64
+	remaining int64
+	checksum  bool
+	last      bool
+	done      bool
+}
+
+func (f *zstdFrame) Read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+
+		if f.last {
+			f.done = true
+			if f.checksum {
+				f.remaining = 4
+			}
+			continue
+		}
+
+		head, err := f.frames.r.Peek(3)
+		if err != nil {
+			return 0, fmt.Errorf("reading zstd block header: %w", unexpected(err))
+		}
+
+		bh := uint32(head[0]) | uint32(head[1])<<8 | uint32(head[2])<<16
+		f.last = bh&1 != 0
+		size := int64(bh >> 3)
+		switch (bh >> 1) & 3 {
+		case 1: // RLE: a single byte, repeated size times
+			size = 1
+		case 3:
+			return 0, fmt.Errorf("reserved zstd block type at offset %d", f.frames.off)
+		}
+
+		f.remaining = 3 + size
+	}
+
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+
+	n, err := f.frames.r.Read(p)
+	f.remaining -= int64(n)
+	f.frames.off += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// zstdIndexingReader decompresses a zstd stream frame by frame, recording
+// an access point at the first frame boundary at least span uncompressed
+// bytes after the previous point.
+type zstdIndexingReader struct {
+	frames *zstdFrames
+	dec    *zstd.Decoder
+	span   int64
+	total  int64
+	index  GzIndex
+	err    error
+}
+
+func newZstdIndexingReader(r io.Reader, span int64) (*zstdIndexingReader, error) {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd decoder: %w", err)
+	}
+
+	zr := &zstdIndexingReader{
+		frames: &zstdFrames{r: bufio.NewReaderSize(r, 1<<16)},
+		dec:    dec,
+		span:   span,
+		index:  GzIndex{Compression: CompressionZstd},
+	}
+
+	err = zr.nextFrame()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty zstd stream")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return zr, nil
+}
+
+func (zr *zstdIndexingReader) nextFrame() error {
+	frame, err := zr.frames.next()
+	if err != nil {
+		return err
+	}
+
+	points := zr.index.Points
+	if len(points) == 0 || zr.total-points[len(points)-1].Uncompressed >= zr.span {
+		zr.index.Points = append(points, AccessPoint{
+			Compressed:   frame.start + 1,
+			Uncompressed: zr.total,
+			Window:       []byte{},
+		})
+	}
+
+	return zr.dec.Reset(frame)
+}
+
+func (zr *zstdIndexingReader) Read(p []byte) (int, error) {
+	for zr.err == nil {
+		n, err := zr.dec.Read(p)
+		zr.total += int64(n)
+		if n > 0 || (err != nil && err != io.EOF) {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+
+		zr.err = zr.nextFrame()
+	}
+
+	return 0, zr.err
+}
+
+func (zr *zstdIndexingReader) Index() *GzIndex {
+	zr.index.Uncompressed = zr.total
+	zr.index.Compressed = zr.frames.off
+	return &zr.index
+}
+
+// resumeZstd decodes from an access point. r must begin one byte before
+// p.Compressed, which for zstd is the first byte of the frame.
+func resumeZstd(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("creating zstd decoder: %w", err)
+	}
+
+	return dec.IOReadCloser(), nil
+}
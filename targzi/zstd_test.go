@@ -0,0 +1,143 @@
+package targzi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+// zstdFramed compresses raw as a sequence of independent frames, the way
+// seekable zstd writers do. A skippable frame is prepended when skippable is
+// set.
+func zstdFramed(t *testing.T, raw []byte, frames int, skippable bool) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	if skippable {
+		hdr := make([]byte, 8)
+		binary.LittleEndian.PutUint32(hdr, zstdSkippableMagic|3)
+		binary.LittleEndian.PutUint32(hdr[4:], 5)
+		buf.Write(hdr)
+		buf.WriteString("hello")
+	}
+
+	chunk := (len(raw) + frames - 1) / frames
+	for start := 0; start < len(raw); start += chunk {
+		zw, err := zstd.NewWriter(buf, zstd.WithEncoderCRC(start%2 == 0))
+		require.NoError(t, err)
+		_, err = zw.Write(raw[start:min(start+chunk, len(raw))])
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func TestZstdFrames(t *testing.T) {
+	raw := testLayer(t, 7)
+	zst := zstdFramed(t, raw, 7, true)
+
+	frames := &zstdFrames{r: bufio.NewReader(bytes.NewReader(zst))}
+	var got []byte
+	count := 0
+	for {
+		frame, err := frames.next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(frame)
+		require.NoError(t, err)
+		require.Equal(t, zst[frame.start:frame.start+int64(len(body))], body)
+
+		dec, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		plain, err := io.ReadAll(dec)
+		dec.Close()
+		require.NoError(t, err)
+
+		got = append(got, plain...)
+		count++
+	}
+
+	require.Equal(t, 7, count)
+	require.EqualValues(t, len(zst), frames.off)
+	require.True(t, bytes.Equal(raw, got))
+}
+
+func TestZstdExtract(t *testing.T) {
+	ctx := context.Background()
+	raw := testLayer(t, 8)
+
+	for _, frames := range []int{1, 3, 40} {
+		zst := zstdFramed(t, raw, frames, frames > 1)
+
+		var fileCount int64
+		index, err := buildIndex(t.TempDir(), bytes.NewReader(zst), "", &fileCount, 64<<10)
+		require.NoError(t, err)
+
+		spans, err := index.Spans()
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(spans), frames)
+
+		for _, entry := range index.Entries {
+			if entry.Hdr.Size == 0 {
+				continue
+			}
+
+			offset, length := entry.Offset, int(entry.Hdr.Size)
+			start, end := SpanRange(spans, offset, length)
+			if end == 0 {
+				end = len(zst) - 1
+			}
+
+			extracted, err := Extract(ctx, bytes.NewReader(zst[start-1:end+1]), index.GzIndexPath, start, offset, length)
+			require.NoError(t, err)
+			body, err := io.ReadAll(extracted)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(raw[offset:offset+length], body), "%d frames: %s", frames, entry.Hdr.Name)
+
+			// reaching the end of the range releases the decoder
+			_, err = extracted.f.Read(make([]byte, 1))
+			require.Error(t, err)
+		}
+	}
+}
+
+func TestSniffCompression(t *testing.T) {
+	raw := testLayer(t, 9)
+
+	for name, blob := range map[Compression][]byte{
+		CompressionGzip: gzipLevel(t, raw, 6, 1),
+		CompressionZstd: zstdFramed(t, raw, 1, false),
+	} {
+		compression, r, err := SniffCompression(bytes.NewReader(blob))
+		require.NoError(t, err)
+		require.Equal(t, name, compression)
+
+		d, err := Decompress(r)
+		require.NoError(t, err)
+		got, err := io.ReadAll(d)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(raw, got))
+	}
+
+	compression, _, err := SniffCompression(bytes.NewReader(raw))
+	require.NoError(t, err)
+	require.Equal(t, CompressionNone, compression)
+
+	_, _, err = SniffCompression(bytes.NewReader(raw[1:]))
+	require.Error(t, err)
+
+	require.Equal(t, CompressionZstd, CompressionFromMediaType("application/vnd.oci.image.layer.v1.tar+zstd"))
+	require.Equal(t, CompressionGzip, CompressionFromMediaType("application/vnd.docker.image.rootfs.diff.tar.gzip"))
+	require.Equal(t, CompressionNone, CompressionFromMediaType("application/vnd.oci.image.layer.v1.tar"))
+	require.Equal(t, Compression(""), CompressionFromMediaType("application/octet-stream"))
+}
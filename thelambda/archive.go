@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/klauspost/compress/gzip"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// maxArchiveSize caps the file contents in one archive, to keep a single
+// request from pulling most of an image through the lambda.
+const maxArchiveSize = 512 << 20
+
+// archiveItem is one entry of an archive. source is the entry its contents
+// come from, which for a hard link is the link's target.
+type archiveItem struct {
+	hdr    tar.Header
+	source *layerreader.EntryWithLayer
+}
+
+// handleArchive streams every entry under a path as a tar, gzipped tar or zip
+// archive.
+func (h *handler) handleArchive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	prefix := q.Get("path")
+	key := storage.ImageIndexKey(image, digest)
+
+	format := q.Get("format")
+	if format == "" {
+		format = "tar"
+	}
+
+	var contentType string
+	switch format {
+	case "tar":
+		contentType = "application/x-tar"
+	case "tar.gz":
+		contentType = "application/gzip"
+	case "zip":
+		contentType = "application/zip"
+	default:
+		http.Error(w, "format must be tar, tar.gz or zip", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := layerreader.LoadImageIndex(ctx, h.storage, key, prefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	layerreader.SortByName(entries)
+
+	byName := map[string]*layerreader.EntryWithLayer{}
+	for _, e := range entries {
+		byName[strings.TrimPrefix(e.Hdr.Name, "/")] = e
+	}
+
+	items := []archiveItem{}
+	size := int64(0)
+	for _, e := range entries {
+		item := archiveItem{hdr: e.Hdr, source: e}
+		item.hdr.Name = strings.TrimPrefix(e.Hdr.Name, "/")
+		if item.hdr.Name == "" {
+			continue // the root directory
+		}
+
+		if e.Hdr.Typeflag == tar.TypeLink {
+			// the target may be outside the archive, so hard links become
+			// copies of their target
+			target := strings.TrimPrefix(path.Clean(e.Hdr.Linkname), "/")
+			item.source = byName[target]
+			if item.source == nil || item.source.Hdr.Typeflag != tar.TypeReg {
+				item.source, err = layerreader.Resolve(ctx, layerreader.ImageIndexLookup(h.storage, key), e.Hdr.Name)
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, layerreader.ErrLinkLoop) || (err == nil && item.source.Hdr.Typeflag != tar.TypeReg) {
+					slog.WarnContext(ctx, "skipping hard link without a regular file target", "name", e.Hdr.Name, "target", target, "error", err)
+					continue
+				} else if err != nil {
+					panic(fmt.Sprintf("%+v", err))
+				}
+			}
+
+			item.hdr.Typeflag = tar.TypeReg
+			item.hdr.Linkname = ""
+			item.hdr.Size = item.source.Hdr.Size
+		}
+
+		size += item.hdr.Size
+		items = append(items, item)
+	}
+
+	if size > maxArchiveSize {
+		http.Error(w, fmt.Sprintf("archive would be %d bytes, over the limit of %d", size, maxArchiveSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = auth.WithScopes(ctx, ref.Scope("pull"))
+
+	indexes := h.newLayerIndexes()
+	defer indexes.Close()
+
+	open := func(e *layerreader.EntryWithLayer) (io.ReadCloser, error) {
+		fe, err := h.newFileExtractor(ctx, ref.Context(), *e, indexes)
+		if err != nil {
+			return nil, err
+		}
+		return fe.open(httpRange{start: 0, length: e.Hdr.Size})
+	}
+
+	filename := path.Base(strings.TrimSuffix(prefix, "/"))
+	if filename == "." || filename == "/" {
+		filename = path.Base(image)
+	}
+
+	// digests are immutable, so neither are archives of them
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+format))
+	w.Header().Set("Cache-Control", "max-age=86400")
+
+	err = writeArchive(w, format, items, open)
+	if err != nil {
+		// headers are already on the wire, so all we can do is cut the body short
+		slog.ErrorContext(ctx, "streaming archive", "error", err)
+	}
+}
+
+// writeArchive writes items to w in the given format, reading the contents
+// of regular files with open.
+func writeArchive(w io.Writer, format string, items []archiveItem, open func(e *layerreader.EntryWithLayer) (io.ReadCloser, error)) error {
+	var aw archiveWriter
+	switch format {
+	case "tar":
+		aw = &tarArchive{tw: tar.NewWriter(w)}
+	case "tar.gz":
+		gzw := gzip.NewWriter(w)
+		aw = &tarArchive{tw: tar.NewWriter(gzw), gzw: gzw}
+	case "zip":
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("unknown archive format %q", format)
+	}
+
+	for _, item := range items {
+		var body io.ReadCloser
+		if item.hdr.Typeflag == tar.TypeReg && item.hdr.Size > 0 {
+			rc, err := open(item.source)
+			if err != nil {
+				return fmt.Errorf("opening %s: %w", item.hdr.Name, err)
+			}
+			body = rc
+		}
+
+		err := aw.add(&item.hdr, body)
+		if body != nil {
+			body.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", item.hdr.Name, err)
+		}
+	}
+
+	return aw.Close()
+}
+
+type archiveWriter interface {
+	// add writes an entry, reading the contents of regular files from body
+	add(hdr *tar.Header, body io.Reader) error
+	Close() error
+}
+
+type tarArchive struct {
+	tw  *tar.Writer
+	gzw *gzip.Writer // nil for plain tar
+}
+
+func (a *tarArchive) add(hdr *tar.Header, body io.Reader) error {
+	// let the writer pick a format that fits, rather than whatever the
+	// layer happened to use
+	hdr.Format = tar.FormatUnknown
+	if hdr.Typeflag != tar.TypeReg {
+		hdr.Size = 0
+	}
+
+	err := a.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		_, err = io.Copy(a.tw, body)
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	err := a.tw.Close()
+	if err == nil && a.gzw != nil {
+		err = a.gzw.Close()
+	}
+	return err
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(hdr *tar.Header, body io.Reader) error {
+	zh := &zip.FileHeader{
+		Name:     hdr.Name,
+		Modified: hdr.ModTime,
+		Method:   zip.Deflate,
+		Extra:    zipUnixOwner(hdr.Uid, hdr.Gid),
+	}
+	zh.SetMode(hdr.FileInfo().Mode())
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+	case tar.TypeDir:
+		zh.Method = zip.Store
+	case tar.TypeSymlink:
+		// zip stores the target of a symlink as its contents
+		body = strings.NewReader(hdr.Linkname)
+	default:
+		// devices and fifos have no zip equivalent
+		return nil
+	}
+
+	fw, err := a.zw.CreateHeader(zh)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		_, err = io.Copy(fw, body)
+	}
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// zipUnixOwner encodes ownership in the Info-ZIP "new Unix" extra field,
+// which unzip restores when run as root.
+func zipUnixOwner(uid, gid int) []byte {
+	extra := make([]byte, 0, 15)
+	extra = binary.LittleEndian.AppendUint16(extra, 0x7875)
+	extra = binary.LittleEndian.AppendUint16(extra, 11)
+	extra = append(extra, 1, 4)
+	extra = binary.LittleEndian.AppendUint32(extra, uint32(uid))
+	extra = append(extra, 4)
+	extra = binary.LittleEndian.AppendUint32(extra, uint32(gid))
+	return extra
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"browseimage/layerreader"
+	"browseimage/targzi"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteArchive(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	passwd := &layerreader.EntryWithLayer{Entry: targzi.Entry{Hdr: tar.Header{
+		Name: "etc/passwd", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5, Uid: 0, ModTime: mtime,
+	}}}
+
+	items := []archiveItem{
+		{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime}},
+		{hdr: passwd.Hdr, source: passwd},
+		{hdr: tar.Header{Name: "etc/passwd-", Typeflag: tar.TypeReg, Mode: 0o600, Size: 5, Uid: 1000, Gid: 100, ModTime: mtime}, source: passwd},
+		{hdr: tar.Header{Name: "etc/mtab", Typeflag: tar.TypeSymlink, Linkname: "/proc/mounts", Mode: 0o777, ModTime: mtime}},
+	}
+
+	open := func(e *layerreader.EntryWithLayer) (io.ReadCloser, error) {
+		require.Equal(t, passwd, e)
+		return io.NopCloser(strings.NewReader("root\n")), nil
+	}
+
+	for _, format := range []string{"tar", "tar.gz"} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, writeArchive(buf, format, items, open))
+
+		var r io.Reader = buf
+		if format == "tar.gz" {
+			gzr, err := gzip.NewReader(buf)
+			require.NoError(t, err)
+			r = gzr
+		}
+
+		tr := tar.NewReader(r)
+		for _, item := range items {
+			hdr, err := tr.Next()
+			require.NoError(t, err)
+			require.Equal(t, item.hdr.Name, hdr.Name)
+			require.Equal(t, item.hdr.Typeflag, hdr.Typeflag)
+			require.Equal(t, item.hdr.Mode, hdr.Mode)
+			require.Equal(t, item.hdr.Uid, hdr.Uid)
+			require.Equal(t, item.hdr.Linkname, hdr.Linkname)
+			require.True(t, mtime.Equal(hdr.ModTime))
+
+			body, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			if item.hdr.Typeflag == tar.TypeReg {
+				require.Equal(t, "root\n", string(body))
+			}
+		}
+		_, err := tr.Next()
+		require.Equal(t, io.EOF, err)
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeArchive(buf, "zip", items, open))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, len(items))
+
+	modes := map[string]fs.FileMode{}
+	bodies := map[string]string{}
+	for _, f := range zr.File {
+		require.True(t, mtime.Equal(f.Modified), f.Name)
+		modes[f.Name] = f.Mode()
+
+		rc, err := f.Open()
+		require.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		bodies[f.Name] = string(body)
+	}
+
+	require.Equal(t, fs.ModeDir|0o755, modes["etc/"])
+	require.Equal(t, fs.FileMode(0o600), modes["etc/passwd-"])
+	require.Equal(t, fs.ModeSymlink|0o777, modes["etc/mtab"])
+	require.Equal(t, "root\n", bodies["etc/passwd-"])
+	require.Equal(t, "/proc/mounts", bodies["etc/mtab"])
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+)
+
+type awsBackend struct {
+	dynamodb *dynamodb.Client
+	table    string
+	sfn      *sfn.Client
+	machine  string
+}
+
+func (a *awsBackend) imageInfo(ctx context.Context, key *bitypes.ImageInfoKey) (*bitypes.ImageInfoItem, []bitypes.LayerProgress, error) {
+	p := dynamodb.NewQueryPaginator(a.dynamodb, &dynamodb.QueryInput{
+		TableName:              &a.table,
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("pk = :pk and begins_with(sk, :sk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("image#%s", key.Repo)},
+			":sk": &types.AttributeValueMemberS{Value: fmt.Sprintf("digest#%s", key.Digest)},
+		},
+	})
+
+	imageInfo := &bitypes.ImageInfoItem{}
+	progresses := []bitypes.LayerProgress{}
+
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("querying image info: %w", err)
+		}
+
+		for _, item := range page.Items {
+			sk := strings.Split(item["sk"].(*types.AttributeValueMemberS).Value, "#")
+			if len(sk) == 2 {
+				err = attributevalue.UnmarshalMap(item, imageInfo)
+				if err != nil {
+					return nil, nil, fmt.Errorf("unmarshalling image info: %w", err)
+				}
+			} else if len(sk) == 4 {
+				lp := bitypes.LayerProgress{}
+				err = attributevalue.UnmarshalMap(item, &lp)
+				if err != nil {
+					return nil, nil, fmt.Errorf("unmarshalling layer progress: %w", err)
+				}
+				progresses = append(progresses, lp)
+			} else {
+				return nil, nil, fmt.Errorf("unexpected dynamo item: %+v", item)
+			}
+		}
+	}
+
+	// image was not in dynamodb
+	if imageInfo.Digest == "" {
+		return nil, nil, nil
+	}
+
+	return imageInfo, progresses, nil
+}
+
+func (a *awsBackend) startIndexing(ctx context.Context, item *bitypes.ImageInfoItem) error {
+	_, err := a.dynamodb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &a.table,
+		Item:      item.DynamoItem(),
+		// outdated items are replaced, see bitypes.ImageInfoVersion
+		ConditionExpression:      aws.String("attribute_not_exists(pk) OR #v < :v"),
+		ExpressionAttributeNames: map[string]string{"#v": "v"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":v": &types.AttributeValueMemberN{Value: strconv.Itoa(bitypes.ImageInfoVersion)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("putting image info: %w", err)
+	}
+
+	sfnInput, _ := json.Marshal(item.ImageInfoKey)
+
+	_, err = a.sfn.StartExecution(ctx, &sfn.StartExecutionInput{
+		StateMachineArn: &a.machine,
+		Name:            &item.ExecutionId,
+		TraceHeader:     aws.String(os.Getenv("_X_AMZN_TRACE_ID")),
+		Input:           aws.String(string(sfnInput)),
+	})
+	if err != nil {
+		return fmt.Errorf("starting execution: %w", err)
+	}
+
+	_, err = a.dynamodb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                &a.table,
+		Key:                      item.Key(),
+		UpdateExpression:         aws.String("SET #status = :status"),
+		ConditionExpression:      aws.String("ExecutionId = :executionId"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":executionId": &types.AttributeValueMemberS{Value: item.ExecutionId},
+			":status":      &types.AttributeValueMemberS{Value: "RUNNING"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("updating image status: %w", err)
+	}
+
+	return nil
+}
+
+func (a *awsBackend) indexInfo(ctx context.Context, key *bitypes.IndexInfoKey) (*bitypes.IndexInfoItem, error) {
+	output, err := a.dynamodb.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &a.table,
+		Key:            key.Key(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting index info: %w", err)
+	}
+
+	// index was not in dynamodb
+	if output.Item == nil {
+		return nil, nil
+	}
+
+	item := &bitypes.IndexInfoItem{}
+	err = attributevalue.UnmarshalMap(output.Item, item)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling index info: %w", err)
+	}
+
+	return item, nil
+}
+
+func (a *awsBackend) putIndexInfo(ctx context.Context, item *bitypes.IndexInfoItem) error {
+	// indexes are immutable, so racing requests put the same item
+	_, err := a.dynamodb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &a.table,
+		Item:      item.DynamoItem(),
+	})
+	if err != nil {
+		return fmt.Errorf("putting index info: %w", err)
+	}
+
+	return nil
+}
+
+func (a *awsBackend) putVulns(ctx context.Context, key *bitypes.ImageInfoKey, vulns *bitypes.Vulns) error {
+	av, err := attributevalue.Marshal(vulns)
+	if err != nil {
+		return fmt.Errorf("marshalling vulns: %w", err)
+	}
+
+	_, err = a.dynamodb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           &a.table,
+		Key:                 key.Key(),
+		UpdateExpression:    aws.String("SET Vulns = :Vulns"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":Vulns": av,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("updating image vulns: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"context"
+)
+
+// backend stores image metadata and runs indexing jobs. The AWS backend uses
+// DynamoDB and Step Functions, the local one goroutines and a directory.
+type backend interface {
+	// imageInfo returns a nil item for images that haven't been indexed.
+	imageInfo(ctx context.Context, key *bitypes.ImageInfoKey) (*bitypes.ImageInfoItem, []bitypes.LayerProgress, error)
+	startIndexing(ctx context.Context, item *bitypes.ImageInfoItem) error
+	// indexInfo returns a nil item for indexes that haven't been recorded.
+	indexInfo(ctx context.Context, key *bitypes.IndexInfoKey) (*bitypes.IndexInfoItem, error)
+	putIndexInfo(ctx context.Context, item *bitypes.IndexInfoItem) error
+	// putVulns saves the summary of an image's vulnerabilities on its item.
+	putVulns(ctx context.Context, key *bitypes.ImageInfoKey, vulns *bitypes.Vulns) error
+}
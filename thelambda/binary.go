@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"browseimage/bininfo"
+	"browseimage/extract"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	// binaryBudget bounds how long reading a binary's headers can take
+	binaryBudget = 30 * time.Second
+	// binaryBlockSize is how much of a binary each read fetches, as headers,
+	// sections and build info are small and scattered through it
+	binaryBlockSize = 64 << 10
+)
+
+type binaryOutput struct {
+	// Path is the file described, after following links
+	Path  string
+	Layer string
+	Size  int64
+	*bininfo.Info
+}
+
+// handleBinary describes the executable or shared library at path.
+func (h *handler) handleBinary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	path := q.Get("path")
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := storage.ImageIndexKey(image, digest)
+	entry, err := layerreader.Resolve(ctx, layerreader.ImageIndexLookup(h.storage, key), path)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, layerreader.ErrLinkLoop) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	if entry.Hdr.Typeflag != tar.TypeReg {
+		http.Error(w, fmt.Sprintf("%s is not a regular file", entry.Hdr.Name), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(auth.WithScopes(ctx, ref.Scope("pull")), binaryBudget)
+	defer cancel()
+
+	indexes := h.newLayerIndexes()
+	defer indexes.Close()
+
+	ra := layerreader.NewReaderAt(ctx, extract.Reader(h.http, ref.Context(), indexes), entry, binaryBlockSize)
+	info, err := bininfo.Read(ra)
+	if readErr := ra.Err(); errors.Is(readErr, context.DeadlineExceeded) {
+		http.Error(w, "reading the binary took too long", http.StatusServiceUnavailable)
+		return
+	} else if readErr != nil {
+		panic(fmt.Sprintf("%+v", readErr))
+	} else if err != nil {
+		// the file itself is the problem
+		http.Error(w, fmt.Sprintf("%s: %s", entry.Hdr.Name, err), http.StatusBadRequest)
+		return
+	}
+
+	output := binaryOutput{Path: entry.Hdr.Name, Layer: entry.Layer, Size: entry.Hdr.Size, Info: info}
+
+	j, _ := json.Marshal(output)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(j)
+}
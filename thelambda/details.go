@@ -0,0 +1,148 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// imageDetails is the parts of an image's manifest and config people look
+// at, decoded, so clients don't have to parse them.
+type imageDetails struct {
+	Architecture string            `json:",omitempty"`
+	OS           string            `json:",omitempty"`
+	Variant      string            `json:",omitempty"`
+	Created      *time.Time        `json:",omitempty"`
+	Author       string            `json:",omitempty"`
+	Entrypoint   []string          `json:",omitempty"`
+	Cmd          []string          `json:",omitempty"`
+	Env          []envVar          `json:",omitempty"`
+	WorkingDir   string            `json:",omitempty"`
+	User         string            `json:",omitempty"`
+	ExposedPorts []string          `json:",omitempty"`
+	Volumes      []string          `json:",omitempty"`
+	StopSignal   string            `json:",omitempty"`
+	Labels       map[string]string `json:",omitempty"`
+	// Annotations are the manifest's
+	Annotations map[string]string `json:",omitempty"`
+	History     []historyEntry
+}
+
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// historyEntry is a step of the build, with the layer it made if it wasn't
+// an empty layer.
+type historyEntry struct {
+	Created    *time.Time `json:",omitempty"`
+	CreatedBy  string     `json:",omitempty"`
+	Comment    string     `json:",omitempty"`
+	EmptyLayer bool
+	// LayerIndex is the position of the layer in the manifest, or -1 for
+	// empty layers
+	LayerIndex int
+	Layer      string `json:",omitempty"`
+	DiffID     string `json:",omitempty"`
+	// Size is the layer's compressed size, and UncompressedSize the size of
+	// its files, unknown for images indexed before it was recorded
+	Size             int64 `json:",omitempty"`
+	UncompressedSize int64 `json:",omitempty"`
+}
+
+// newImageDetails decodes a manifest and config. Efficiency, which can be
+// nil, has the uncompressed sizes of the layers.
+func newImageDetails(rawManifest, rawConfig []byte, efficiency *bitypes.Efficiency) (*imageDetails, error) {
+	manifest, err := v1.ParseManifest(bytes.NewReader(rawManifest))
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	config, err := v1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+
+	details := &imageDetails{
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		Variant:      config.Variant,
+		Created:      optionalTime(config.Created.Time),
+		Author:       config.Author,
+		Entrypoint:   config.Config.Entrypoint,
+		Cmd:          config.Config.Cmd,
+		WorkingDir:   config.Config.WorkingDir,
+		User:         config.Config.User,
+		StopSignal:   config.Config.StopSignal,
+		Labels:       config.Config.Labels,
+		Annotations:  manifest.Annotations,
+		History:      []historyEntry{},
+	}
+
+	for _, env := range config.Config.Env {
+		name, value, _ := strings.Cut(env, "=")
+		details.Env = append(details.Env, envVar{Name: name, Value: value})
+	}
+	for port := range config.Config.ExposedPorts {
+		details.ExposedPorts = append(details.ExposedPorts, port)
+	}
+	sort.Strings(details.ExposedPorts)
+	for volume := range config.Config.Volumes {
+		details.Volumes = append(details.Volumes, volume)
+	}
+	sort.Strings(details.Volumes)
+
+	layer := func(entry historyEntry, idx int) historyEntry {
+		desc := manifest.Layers[idx]
+		entry.LayerIndex = idx
+		entry.Layer = desc.Digest.String()
+		entry.Size = desc.Size
+		if idx < len(config.RootFS.DiffIDs) {
+			entry.DiffID = config.RootFS.DiffIDs[idx].String()
+		}
+		if efficiency != nil {
+			entry.UncompressedSize = efficiency.LayerBytes[entry.Layer]
+		}
+		return entry
+	}
+
+	// empty layers don't have a layer in the manifest, so the others are
+	// matched up with the layers in order
+	next := 0
+	for _, hist := range config.History {
+		entry := historyEntry{
+			Created:    optionalTime(hist.Created.Time),
+			CreatedBy:  hist.CreatedBy,
+			Comment:    hist.Comment,
+			EmptyLayer: hist.EmptyLayer,
+			LayerIndex: -1,
+		}
+		if !hist.EmptyLayer && next < len(manifest.Layers) {
+			entry = layer(entry, next)
+			next++
+		}
+		details.History = append(details.History, entry)
+	}
+
+	// images built without history, or with too little of it, still have
+	// all their layers listed
+	for ; next < len(manifest.Layers); next++ {
+		details.History = append(details.History, layer(historyEntry{}, next))
+	}
+
+	return details, nil
+}
+
+// optionalTime is nil for the zero time, which configs have when they leave
+// times out, so that it's left out of JSON too.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
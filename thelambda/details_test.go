@@ -0,0 +1,84 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewImageDetails(t *testing.T) {
+	hash := func(c string) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: strings.Repeat(c, 64)}
+	}
+
+	manifest, err := json.Marshal(&v1.Manifest{
+		SchemaVersion: 2,
+		Config:        v1.Descriptor{Digest: hash("f")},
+		Layers: []v1.Descriptor{
+			{Digest: hash("a"), Size: 100},
+			{Digest: hash("b"), Size: 200},
+			{Digest: hash("c"), Size: 300},
+		},
+		Annotations: map[string]string{"org.opencontainers.image.base.name": "debian:12"},
+	})
+	require.NoError(t, err)
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	config, err := json.Marshal(&v1.ConfigFile{
+		Architecture: "arm64",
+		Created:      v1.Time{Time: created},
+		OS:           "linux",
+		Variant:      "v8",
+		Config: v1.Config{
+			Entrypoint:   []string{"/docker-entrypoint.sh"},
+			Cmd:          []string{"nginx", "-g", "daemon off;"},
+			Env:          []string{"PATH=/usr/bin:/bin", "EMPTY=", "A=b=c"},
+			User:         "101",
+			ExposedPorts: map[string]struct{}{"80/tcp": {}, "443/tcp": {}},
+			Labels:       map[string]string{"maintainer": "someone"},
+		},
+		RootFS: v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{hash("1"), hash("2"), hash("3")}},
+		History: []v1.History{
+			{CreatedBy: "ADD rootfs.tar /", Created: v1.Time{Time: created}},
+			{CreatedBy: "ENV PATH=/usr/bin:/bin", EmptyLayer: true},
+			{CreatedBy: "RUN apt-get install nginx", Comment: "buildkit.dockerfile.v0"},
+		},
+	})
+	require.NoError(t, err)
+
+	details, err := newImageDetails(manifest, config, &bitypes.Efficiency{LayerBytes: map[string]int64{hash("a").String(): 1000, hash("b").String(): 2000}})
+	require.NoError(t, err)
+
+	require.Equal(t, "arm64", details.Architecture)
+	require.Equal(t, "v8", details.Variant)
+	require.Equal(t, []string{"/docker-entrypoint.sh"}, details.Entrypoint)
+	require.Equal(t, []string{"nginx", "-g", "daemon off;"}, details.Cmd)
+	require.Equal(t, []envVar{{"PATH", "/usr/bin:/bin"}, {"EMPTY", ""}, {"A", "b=c"}}, details.Env)
+	require.Equal(t, "101", details.User)
+	require.Equal(t, []string{"443/tcp", "80/tcp"}, details.ExposedPorts)
+	require.Equal(t, map[string]string{"maintainer": "someone"}, details.Labels)
+	require.Equal(t, map[string]string{"org.opencontainers.image.base.name": "debian:12"}, details.Annotations)
+
+	require.Equal(t, []historyEntry{
+		{Created: &created, CreatedBy: "ADD rootfs.tar /", LayerIndex: 0, Layer: hash("a").String(), DiffID: hash("1").String(), Size: 100, UncompressedSize: 1000},
+		{CreatedBy: "ENV PATH=/usr/bin:/bin", EmptyLayer: true, LayerIndex: -1},
+		{CreatedBy: "RUN apt-get install nginx", Comment: "buildkit.dockerfile.v0", LayerIndex: 1, Layer: hash("b").String(), DiffID: hash("2").String(), Size: 200, UncompressedSize: 2000},
+		// more layers than history
+		{LayerIndex: 2, Layer: hash("c").String(), DiffID: hash("3").String(), Size: 300},
+	}, details.History)
+
+	// times the config leaves out are left out too
+	j, err := json.Marshal(details)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(j), `"Created":"2024-05-01T12:00:00Z"`))
+	require.NotContains(t, string(j), "0001-01-01")
+	require.Equal(t, &created, details.Created)
+
+	_, err = newImageDetails(manifest, []byte("not json"), nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// handleDiff streams the paths that changed between two digests of an image,
+// or two platforms of an index, as JSON lines, in order of path.
+func (h *handler) handleDiff(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	from := q.Get("from")
+	to := q.Get("to")
+	prefix := q.Get("prefix")
+
+	if from == "" || to == "" {
+		http.Error(w, "from and to digests are required", http.StatusBadRequest)
+		return
+	}
+
+	// with an index, from and to can be platforms of it, like linux/amd64
+	if digest := q.Get("index"); digest != "" {
+		index, err := h.backend.indexInfo(ctx, &bitypes.IndexInfoKey{Repo: image, Digest: digest})
+		if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		} else if index == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		for _, p := range []*string{&from, &to} {
+			*p, err = platformDigest(index, *p)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	for _, digest := range []string{from, to} {
+		_, err := v1.NewHash(digest)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid digest: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	load := func(digest string) []*layerreader.EntryWithLayer {
+		entries, err := layerreader.LoadImageIndex(ctx, h.storage, storage.ImageIndexKey(image, digest), prefix)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		} else if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		}
+
+		layerreader.SortByName(entries)
+		return entries
+	}
+
+	fromEntries := load(from)
+	toEntries := load(to)
+	if fromEntries == nil || toEntries == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// digests are immutable, so neither is the diff between them
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "max-age=86400")
+
+	enc := json.NewEncoder(w)
+	err := layerreader.Diff(fromEntries, toEntries, func(c *layerreader.Change) error {
+		return enc.Encode(c)
+	})
+	if err != nil {
+		// headers are already on the wire, so all we can do is cut the body short
+		slog.ErrorContext(ctx, "streaming diff", "error", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+const (
+	defaultGrepMaxSize = 1 << 20
+	maxGrepMaxSize     = 16 << 20
+	maxGrepMatches     = 1000
+	grepBudget         = 20 * time.Second
+	grepConcurrency    = 8
+
+	// files with a NUL byte this close to the start are treated as binary,
+	// the same heuristic as GNU grep and git
+	binarySniffSize = 8000
+	maxGrepLineSize = 64 << 10
+)
+
+type grepMatch struct {
+	Path string
+	Line int
+	Text string
+}
+
+type grepSummary struct {
+	FilesSearched int
+	// FilesSkipped counts binaries, files over the size cap and files that
+	// couldn't be read
+	FilesSkipped int
+	Matches      int
+	// Truncated is set when the match limit or time budget cut the search
+	// short
+	Truncated bool
+}
+
+type grepFileResult struct {
+	matches []grepMatch
+	skipped bool
+	err     error
+}
+
+// handleGrep searches the contents of regular files in an image for a
+// regular expression, streaming matches as JSON lines in order of path and
+// then a summary line.
+func (h *handler) handleGrep(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	prefix := q.Get("prefix")
+
+	if q.Get("q") == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	re, err := regexp.Compile(q.Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxSize := int64(defaultGrepMaxSize)
+	if s := q.Get("maxSize"); s != "" {
+		maxSize, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || maxSize <= 0 {
+			http.Error(w, "maxSize must be a positive number", http.StatusBadRequest)
+			return
+		}
+		maxSize = min(maxSize, maxGrepMaxSize)
+	}
+
+	entries, err := layerreader.LoadImageIndex(ctx, h.storage, storage.ImageIndexKey(image, digest), prefix)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+	layerreader.SortByName(entries)
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = auth.WithScopes(ctx, ref.Scope("pull"))
+
+	summary := grepSummary{}
+	files := []*layerreader.EntryWithLayer{}
+	for _, e := range entries {
+		if e.Hdr.Typeflag != tar.TypeReg || e.Hdr.Size == 0 {
+			continue
+		}
+		if e.Hdr.Size > maxSize {
+			summary.FilesSkipped++
+			continue
+		}
+		files = append(files, e)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, grepBudget)
+	defer cancel()
+
+	indexes := h.newLayerIndexes()
+	defer indexes.Close()
+
+	// files are searched concurrently, but each result has its own channel
+	// so matches still come out in order of path
+	results := make([]chan grepFileResult, len(files))
+	for i := range results {
+		results[i] = make(chan grepFileResult, 1)
+	}
+
+	go func() {
+		sem := make(chan struct{}, grepConcurrency)
+		for i, e := range files {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- grepFileResult{err: ctx.Err()}
+				continue
+			}
+
+			go func(i int, e *layerreader.EntryWithLayer) {
+				defer func() { <-sem }()
+				results[i] <- h.grepFile(ctx, ref.Context(), *e, indexes, re)
+			}(i, e)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+
+	failed := false
+	searched := 0
+	for ; searched < len(results); searched++ {
+		res := <-results[searched]
+		if res.err != nil {
+			if ctx.Err() != nil {
+				summary.Truncated = true
+				break
+			}
+
+			slog.WarnContext(ctx, "grepping file", "error", res.err)
+			summary.FilesSkipped++
+			continue
+		} else if res.skipped {
+			summary.FilesSkipped++
+			continue
+		}
+
+		summary.FilesSearched++
+		for _, m := range res.matches {
+			if summary.Matches == maxGrepMatches {
+				summary.Truncated = true
+				break
+			}
+
+			err = enc.Encode(m)
+			if err != nil {
+				// headers are already on the wire, so all we can do is cut the body short
+				slog.ErrorContext(ctx, "streaming grep matches", "error", err)
+				failed = true
+				break
+			}
+			summary.Matches++
+		}
+
+		if summary.Truncated || failed {
+			break
+		}
+	}
+
+	// stop any searches still running, and wait for them before the
+	// indexes they use are removed
+	cancel()
+	for _, ch := range results[min(searched+1, len(results)):] {
+		<-ch
+	}
+	if failed {
+		return
+	}
+
+	err = enc.Encode(struct{ Summary grepSummary }{summary})
+	if err != nil {
+		slog.ErrorContext(ctx, "streaming grep summary", "error", err)
+	}
+}
+
+func (h *handler) grepFile(ctx context.Context, repo name.Repository, entry layerreader.EntryWithLayer, indexes *layerIndexes, re *regexp.Regexp) grepFileResult {
+	fe, err := h.newFileExtractor(ctx, repo, entry, indexes)
+	if err != nil {
+		return grepFileResult{err: fmt.Errorf("%s: %w", entry.Hdr.Name, err)}
+	}
+
+	extracted, err := fe.open(httpRange{start: 0, length: entry.Hdr.Size})
+	if err != nil {
+		return grepFileResult{err: fmt.Errorf("%s: %w", entry.Hdr.Name, err)}
+	}
+	defer extracted.Close()
+
+	matches := []grepMatch{}
+	binary, err := grepLines(extracted, re, func(line int, text string) {
+		matches = append(matches, grepMatch{Path: entry.Hdr.Name, Line: line, Text: text})
+	})
+	if err != nil {
+		return grepFileResult{err: fmt.Errorf("%s: %w", entry.Hdr.Name, err)}
+	}
+
+	return grepFileResult{matches: matches, skipped: binary}
+}
+
+// grepLines calls fn with the number and text of every line of r matching
+// re, stopping early if r turns out to be binary. Lines too long to be
+// useful in a result are skipped.
+func grepLines(r io.Reader, re *regexp.Regexp, fn func(line int, text string)) (binary bool, err error) {
+	br := bufio.NewReaderSize(r, maxGrepLineSize)
+
+	head, err := br.Peek(binarySniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return false, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true, nil
+	}
+
+	for number := 1; ; number++ {
+		line, isPrefix, err := br.ReadLine()
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if isPrefix {
+			for isPrefix && err == nil {
+				_, isPrefix, err = br.ReadLine()
+			}
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			continue
+		}
+
+		if bytes.IndexByte(line, 0) >= 0 {
+			return true, nil
+		}
+		if re.Match(line) {
+			fn(number, string(line))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrepLines(t *testing.T) {
+	re := regexp.MustCompile(`root|daemon`)
+
+	grep := func(body string) ([]int, bool) {
+		lines := []int{}
+		binary, err := grepLines(strings.NewReader(body), re, func(line int, text string) {
+			require.True(t, re.MatchString(text))
+			lines = append(lines, line)
+		})
+		require.NoError(t, err)
+		return lines, binary
+	}
+
+	lines, binary := grep("root:x:0:0\r\nbin:x:1:1\ndaemon:x:2:2\n\nnobody:x:65534:65534")
+	require.False(t, binary)
+	require.Equal(t, []int{1, 3}, lines)
+
+	// overlong lines are skipped but still counted
+	lines, binary = grep("root\n" + strings.Repeat("root", maxGrepLineSize) + "\ndaemon")
+	require.False(t, binary)
+	require.Equal(t, []int{1, 3}, lines)
+
+	_, binary = grep("\x7fELF\x02\x01\x01\x00root")
+	require.True(t, binary)
+
+	// a NUL past the sniffed prefix is still caught
+	_, binary = grep(strings.Repeat("root\n", binarySniffSize) + "\x00")
+	require.True(t, binary)
+}
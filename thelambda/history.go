@@ -0,0 +1,121 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+type historyStepOutput struct {
+	layerreader.HistoryStep
+	// LayerIndex is the position of the layer in the manifest, or -1 if it
+	// couldn't be found
+	LayerIndex int
+	// CreatedBy and Comment are from the config's history entry for the
+	// layer
+	CreatedBy string `json:",omitempty"`
+	Comment   string `json:",omitempty"`
+}
+
+type historyOutput struct {
+	Name  string
+	Steps []historyStepOutput
+}
+
+// handleHistory returns every layer that touched a path, from the bottom of
+// the image up, with the command that built each.
+func (h *handler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	path := q.Get("path")
+
+	history, err := layerreader.LookupHistory(ctx, h.storage, storage.HistoryIndexKey(image, digest), path)
+	if errors.Is(err, storage.ErrNotFound) {
+		// also images indexed before histories were recorded
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	} else if history == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	item, _, err := h.backend.imageInfo(ctx, &bitypes.ImageInfoKey{Repo: image, Digest: digest})
+	if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	var manifest *v1.Manifest
+	var config *v1.ConfigFile
+	if item != nil {
+		manifest, err = v1.ParseManifest(bytes.NewReader(item.Manifest))
+		if err != nil {
+			slog.WarnContext(ctx, "parsing manifest", "error", err)
+		}
+		config, err = v1.ParseConfigFile(bytes.NewReader(item.RawConfig))
+		if err != nil {
+			slog.WarnContext(ctx, "parsing config", "error", err)
+		}
+	}
+
+	output := historyOutput{Name: history.Name, Steps: correlateHistory(history.Steps, manifest, config)}
+
+	j, _ := json.Marshal(output)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(j)
+}
+
+// correlateHistory finds the manifest position and config history entry of
+// the layer of each step. Either of manifest and config may be nil.
+func correlateHistory(steps []layerreader.HistoryStep, manifest *v1.Manifest, config *v1.ConfigFile) []historyStepOutput {
+	// history entries for empty layers don't have a layer in the manifest
+	layerHistory := []v1.History{}
+	if config != nil {
+		for _, hist := range config.History {
+			if !hist.EmptyLayer {
+				layerHistory = append(layerHistory, hist)
+			}
+		}
+	}
+
+	output := make([]historyStepOutput, 0, len(steps))
+	next := 0
+	for _, step := range steps {
+		out := historyStepOutput{HistoryStep: step, LayerIndex: -1}
+
+		// the same layer can appear more than once, and steps are in layer
+		// order, so search on from the previous step's layer
+		if manifest != nil {
+			for idx := next; idx < len(manifest.Layers); idx++ {
+				if manifest.Layers[idx].Digest.String() == step.Layer {
+					out.LayerIndex = idx
+					next = idx
+					break
+				}
+			}
+		}
+
+		if out.LayerIndex >= 0 && out.LayerIndex < len(layerHistory) {
+			out.CreatedBy = layerHistory[out.LayerIndex].CreatedBy
+			out.Comment = layerHistory[out.LayerIndex].Comment
+		}
+
+		output = append(output, out)
+	}
+
+	return output
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"browseimage/layerreader"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelateHistory(t *testing.T) {
+	hash := func(hex string) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: hex}
+	}
+
+	manifest := &v1.Manifest{Layers: []v1.Descriptor{
+		{Digest: hash("aaaa")},
+		{Digest: hash("bbbb")},
+		{Digest: hash("aaaa")},
+	}}
+	config := &v1.ConfigFile{History: []v1.History{
+		{CreatedBy: "ADD rootfs.tar /"},
+		{CreatedBy: "ENV PATH=/bin", EmptyLayer: true},
+		{CreatedBy: "RUN apt-get install"},
+		{CreatedBy: "COPY rootfs.tar /"},
+	}}
+
+	steps := []layerreader.HistoryStep{
+		{Layer: "sha256:aaaa", Action: layerreader.HistoryCreated},
+		{Layer: "sha256:bbbb", Action: layerreader.HistoryOverwritten},
+		{Layer: "sha256:aaaa", Action: layerreader.HistoryOverwritten},
+		{Layer: "sha256:cccc", Action: layerreader.HistoryWhiteout},
+	}
+
+	output := correlateHistory(steps, manifest, config)
+	require.Len(t, output, 4)
+	require.Equal(t, 0, output[0].LayerIndex)
+	require.Equal(t, "ADD rootfs.tar /", output[0].CreatedBy)
+	require.Equal(t, 1, output[1].LayerIndex)
+	require.Equal(t, "RUN apt-get install", output[1].CreatedBy)
+	require.Equal(t, 2, output[2].LayerIndex)
+	require.Equal(t, "COPY rootfs.tar /", output[2].CreatedBy)
+	require.Equal(t, -1, output[3].LayerIndex)
+	require.Empty(t, output[3].CreatedBy)
+
+	output = correlateHistory(steps, nil, nil)
+	require.Equal(t, -1, output[0].LayerIndex)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// platformOutput is an image of an index, and how far indexing it has got.
+type platformOutput struct {
+	Digest      string
+	Platform    *v1.Platform `json:",omitempty"`
+	Attestation bool         `json:",omitempty"`
+	// Status is empty for images that haven't been indexed
+	Status    bitypes.ImageInfoStatus `json:",omitempty"`
+	TotalSize int64                   `json:",omitempty"`
+}
+
+// indexInfo returns the index at key's digest, recording it the first time
+// it's asked for, or nil if the digest is an image.
+func (h *handler) indexInfo(ctx context.Context, key *bitypes.ImageInfoKey) (*bitypes.IndexInfoItem, error) {
+	indexKey := &bitypes.IndexInfoKey{Repo: key.Repo, Digest: key.Digest}
+	item, err := h.backend.indexInfo(ctx, indexKey)
+	if err != nil || item != nil {
+		return item, err
+	}
+
+	ref, err := name.ParseReference(fmt.Sprintf("%s@%s", key.Repo, key.Digest))
+	if err != nil {
+		return nil, nil
+	}
+
+	desc, err := remote.Get(ref, h.remoteOptions(ctx)...)
+	if err != nil {
+		// indexing it as an image reports the same error where it's seen
+		slog.WarnContext(ctx, "getting manifest", "error", err)
+		return nil, nil
+	} else if !desc.MediaType.IsIndex() {
+		return nil, nil
+	}
+
+	im, err := v1.ParseIndexManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return nil, fmt.Errorf("parsing index manifest: %w", err)
+	}
+
+	item = &bitypes.IndexInfoItem{
+		IndexInfoKey: *indexKey,
+		MediaType:    string(desc.MediaType),
+		Manifests:    []bitypes.IndexManifest{},
+		Retrieved:    time.Now(),
+	}
+	for _, m := range im.Manifests {
+		item.Manifests = append(item.Manifests, bitypes.IndexManifest{
+			Digest:      m.Digest.String(),
+			MediaType:   string(m.MediaType),
+			Platform:    m.Platform,
+			Attestation: m.Annotations["vnd.docker.reference.type"] == "attestation-manifest",
+		})
+	}
+
+	err = h.backend.putIndexInfo(ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// handleIndex is handleInfo for an index. It starts indexing the platforms
+// in the platforms parameter, which is all or a comma-separated list like
+// linux/amd64,linux/arm64/v8, and lists the progress of every platform.
+func (h *handler) handleIndex(w http.ResponseWriter, r *http.Request, index *bitypes.IndexInfoItem) {
+	ctx := r.Context()
+
+	platforms := r.URL.Query().Get("platforms")
+	specs := []*v1.Platform{}
+	if platforms != "" && platforms != "all" {
+		for _, p := range strings.Split(platforms, ",") {
+			spec, err := v1.ParsePlatform(p)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid platform %q: %s", p, err), http.StatusBadRequest)
+				return
+			}
+			specs = append(specs, spec)
+		}
+	}
+
+	// nested indexes and attestations can't be indexed as images
+	indexable := func(m bitypes.IndexManifest) bool {
+		return !m.Attestation && types.MediaType(m.MediaType).IsImage()
+	}
+	wanted := func(m bitypes.IndexManifest) bool {
+		if !indexable(m) {
+			return false
+		}
+		if platforms == "all" {
+			return true
+		}
+		for _, spec := range specs {
+			if matchPlatform(spec, m.Platform) {
+				return true
+			}
+		}
+		return false
+	}
+
+	output := &HandleImageOutput{
+		Status:    bitypes.ImageInfoStatusSucceeded,
+		Repo:      index.Repo,
+		Digest:    index.Digest,
+		Retrieved: index.Retrieved,
+		Platforms: []platformOutput{},
+	}
+
+	// the index has succeeded once every platform that can be indexed has,
+	// and is pending while any of them hasn't been asked for
+	unindexed := false
+	for _, m := range index.Manifests {
+		key := &bitypes.ImageInfoKey{Repo: index.Repo, Digest: m.Digest}
+		item, _, err := h.backend.imageInfo(ctx, key)
+		if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		}
+
+		if item == nil && wanted(m) {
+			item = h.newImageInfoItem(key)
+			item.Index = index.Digest
+			item.Platform = m.Platform
+
+			err = h.backend.startIndexing(ctx, item)
+			if err != nil {
+				// most likely another request started it first, and either
+				// way the other platforms can still go ahead
+				slog.WarnContext(ctx, "starting indexing", "digest", m.Digest, "error", err)
+				item = nil
+			}
+		}
+
+		po := platformOutput{Digest: m.Digest, Platform: m.Platform, Attestation: m.Attestation}
+		if item != nil {
+			po.Status = item.Status
+			po.TotalSize = item.TotalSize
+
+			switch item.Status {
+			case bitypes.ImageInfoStatusFailed:
+				if output.Status != bitypes.ImageInfoStatusRunning {
+					output.Status = bitypes.ImageInfoStatusFailed
+				}
+			case bitypes.ImageInfoStatusPending, bitypes.ImageInfoStatusRunning:
+				output.Status = bitypes.ImageInfoStatusRunning
+			}
+		} else if indexable(m) {
+			unindexed = true
+		}
+		output.Platforms = append(output.Platforms, po)
+	}
+
+	if unindexed && output.Status == bitypes.ImageInfoStatusSucceeded {
+		output.Status = bitypes.ImageInfoStatusPending
+	}
+
+	maxAge := time.Second
+	if output.Status == bitypes.ImageInfoStatusSucceeded {
+		maxAge = time.Hour * 24
+	}
+
+	j, _ := json.Marshal(output)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge/time.Second))
+	w.Write(j)
+}
+
+// matchPlatform reports whether p is what spec asks for. Variants and OS
+// versions only have to match if spec has them.
+func matchPlatform(spec, p *v1.Platform) bool {
+	return p != nil &&
+		spec.OS == p.OS &&
+		spec.Architecture == p.Architecture &&
+		(spec.Variant == "" || spec.Variant == p.Variant) &&
+		(spec.OSVersion == "" || spec.OSVersion == p.OSVersion)
+}
+
+// platformDigest resolves a platform like linux/arm64 to the digest of its
+// image in index. Digests are returned as they are.
+func platformDigest(index *bitypes.IndexInfoItem, platform string) (string, error) {
+	if _, err := v1.NewHash(platform); err == nil {
+		return platform, nil
+	}
+
+	spec, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return "", fmt.Errorf("invalid platform %q: %w", platform, err)
+	}
+
+	for _, m := range index.Manifests {
+		if !m.Attestation && matchPlatform(spec, m.Platform) {
+			return m.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("index has no %s image", platform)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+
+	"github.com/glassechidna/go-emf/emf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// handleListLayerDirectory lists a directory of a single layer as it was
+// built, whiteouts included, rather than the image's merged filesystem.
+func (h *handler) handleListLayerDirectory(w http.ResponseWriter, r *http.Request, msi emf.MSI, image *bitypes.ImageInfoKey, layer, dir string) {
+	ctx := r.Context()
+
+	ok, err := h.imageHasLayer(ctx, image, layer)
+	if err != nil {
+		msi["StatusCode"] = emf.Dimension("500")
+		panic(fmt.Sprintf("%+v", err))
+	} else if !ok {
+		http.NotFound(w, r)
+		msi["StatusCode"] = emf.Dimension("404")
+		return
+	}
+
+	entries, err := targzi.NewTarExplorer(h.storage).ListDirectory(ctx, layer, dir)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			msi["StatusCode"] = emf.Dimension("404")
+			return
+		}
+
+		msi["StatusCode"] = emf.Dimension("500")
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	merged, err := layerreader.ListImageIndex(ctx, h.storage, storage.ImageIndexKey(image.Repo, image.Digest), dir)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			msi["StatusCode"] = emf.Dimension("404")
+			return
+		}
+
+		msi["StatusCode"] = emf.Dimension("500")
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	j, _ := json.Marshal(layerreader.AnnotateLayer(layer, entries, merged))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// imageHasLayer reports whether layer is one of the layers in the manifest of
+// image, which is false for images that haven't been indexed. Layers are
+// stored once for every image that has them, so this is what keeps one
+// image's layers from being read through another image.
+func (h *handler) imageHasLayer(ctx context.Context, image *bitypes.ImageInfoKey, layer string) (bool, error) {
+	item, _, err := h.backend.imageInfo(ctx, image)
+	if err != nil || item == nil || len(item.Manifest) == 0 {
+		return false, err
+	}
+
+	manifest, err := v1.ParseManifest(bytes.NewReader(item.Manifest))
+	if err != nil {
+		return false, fmt.Errorf("parsing manifest: %w", err)
+	}
+
+	for _, l := range manifest.Layers {
+		if l.Digest.String() == layer {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// layerEntry finds the entry named name in a single layer, without following
+// links.
+func (h *handler) layerEntry(ctx context.Context, layer, name string) (*layerreader.EntryWithLayer, error) {
+	entries, err := targzi.NewTarExplorer(h.storage).ListDirectory(ctx, layer, targzi.Parent(name))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Hdr.Name == name {
+			return &layerreader.EntryWithLayer{Entry: e, Layer: layer}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+}
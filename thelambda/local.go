@@ -0,0 +1,456 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"browseimage/secrets"
+	"browseimage/storage"
+	"browseimage/targzi"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// localScanBudget is how long the optional secret scan may spend reading
+// files, the same as the secret scanner function.
+const localScanBudget = 12 * time.Minute
+
+// localBackend keeps image metadata alongside the indexes in storage, and
+// indexes images in goroutines rather than a state machine. With local
+// storage it lets the whole site run as one process without an AWS account.
+type localBackend struct {
+	storage       storage.Storage
+	tmp           string
+	remoteOptions func(ctx context.Context) []remote.Option
+	concurrency   int
+	// secretsClient reads files for the secret scan after indexing, which is
+	// skipped if it is nil
+	secretsClient *auth.Client
+
+	mu   sync.Mutex
+	jobs map[bitypes.ImageInfoKey]*localJob
+}
+
+// localJob tracks the progress of an indexing job in memory. Jobs don't
+// survive a restart, so images left pending by one are indexed again.
+type localJob struct {
+	mu     sync.Mutex
+	layers []*localLayerProgress
+}
+
+type localLayerProgress struct {
+	digest         string
+	totalBytes     int64
+	completedBytes atomic.Int64
+	completedFiles int64
+}
+
+func newLocalBackend(storage storage.Storage, tmp string, remoteOptions func(ctx context.Context) []remote.Option) *localBackend {
+	return &localBackend{
+		storage:       storage,
+		tmp:           tmp,
+		remoteOptions: remoteOptions,
+		concurrency:   4,
+		jobs:          map[bitypes.ImageInfoKey]*localJob{},
+	}
+}
+
+func (l *localBackend) imageInfo(ctx context.Context, key *bitypes.ImageInfoKey) (*bitypes.ImageInfoItem, []bitypes.LayerProgress, error) {
+	// jobs save their final status and go away under the lock, so the two
+	// are always consistent here
+	l.mu.Lock()
+	item, err := l.readItem(ctx, key)
+	job := l.jobs[*key]
+	l.mu.Unlock()
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if job == nil {
+		if item.Status == bitypes.ImageInfoStatusPending || item.Status == bitypes.ImageInfoStatusRunning {
+			// the process that was indexing it has gone away
+			return nil, nil, nil
+		}
+		return item, nil, nil
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	progresses := []bitypes.LayerProgress{}
+	for _, lp := range job.layers {
+		progresses = append(progresses, bitypes.LayerProgress{
+			LayerProgressKey: bitypes.LayerProgressKey{
+				Repo:        key.Repo,
+				ImageDigest: key.Digest,
+				LayerDigest: lp.digest,
+			},
+			TotalBytes:     lp.totalBytes,
+			CompletedBytes: lp.completedBytes.Load(),
+			CompletedFiles: atomic.LoadInt64(&lp.completedFiles),
+		})
+	}
+
+	return item, progresses, nil
+}
+
+func (l *localBackend) startIndexing(ctx context.Context, item *bitypes.ImageInfoItem) error {
+	key := item.ImageInfoKey
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.jobs[key] != nil {
+		return nil
+	}
+
+	running := *item
+	running.Status = bitypes.ImageInfoStatusRunning
+	err := l.writeItem(ctx, &running)
+	if err != nil {
+		return err
+	}
+
+	job := &localJob{}
+	l.jobs[key] = job
+
+	go func() {
+		// the job outlives the request that started it
+		ctx := context.WithoutCancel(ctx)
+		start := time.Now()
+
+		err := l.index(ctx, job, &running)
+		if err != nil {
+			slog.ErrorContext(ctx, "indexing image", "error", err)
+			running.Status = bitypes.ImageInfoStatusFailed
+		} else {
+			running.Status = bitypes.ImageInfoStatusSucceeded
+		}
+		running.Duration = time.Since(start)
+
+		l.mu.Lock()
+		err = l.writeItem(ctx, &running)
+		if err != nil {
+			slog.ErrorContext(ctx, "saving image status", "error", err)
+		}
+		delete(l.jobs, key)
+		l.mu.Unlock()
+
+		if running.Status == bitypes.ImageInfoStatusSucceeded && l.secretsClient != nil {
+			l.scanSecrets(ctx, &running)
+		}
+	}()
+
+	return nil
+}
+
+// index does the work of the layer lister, remote layer reader and
+// concatenator functions.
+func (l *localBackend) index(ctx context.Context, job *localJob, item *bitypes.ImageInfoItem) error {
+	ref, err := name.ParseReference(fmt.Sprintf("%s@%s", item.Repo, item.Digest))
+	if err != nil {
+		return fmt.Errorf("parsing ref: %w", err)
+	}
+
+	img, err := remote.Image(ref, l.remoteOptions(ctx)...)
+	if err != nil {
+		return fmt.Errorf("getting image: %w", err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("getting layers: %w", err)
+	}
+
+	item.RawConfig, err = img.RawConfigFile()
+	if err != nil {
+		return fmt.Errorf("getting raw config: %w", err)
+	}
+
+	item.Manifest, err = img.RawManifest()
+	if err != nil {
+		return fmt.Errorf("getting manifest: %w", err)
+	}
+
+	progresses := []*localLayerProgress{}
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return fmt.Errorf("getting layer digest: %w", err)
+		}
+
+		size, err := layer.Size()
+		if err != nil {
+			return fmt.Errorf("getting layer size: %w", err)
+		}
+
+		item.TotalSize += size
+		progresses = append(progresses, &localLayerProgress{digest: digest.String(), totalBytes: size})
+	}
+
+	err = l.writeItem(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	job.mu.Lock()
+	job.layers = progresses
+	job.mu.Unlock()
+
+	sem := make(chan struct{}, l.concurrency)
+	errs := make([]error, len(layers))
+	wg := sync.WaitGroup{}
+	for idx, layer := range layers {
+		wg.Add(1)
+		go func(idx int, layer v1.Layer) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[idx] = l.indexLayer(ctx, layer, progresses[idx])
+		}(idx, layer)
+	}
+	wg.Wait()
+
+	err = errors.Join(errs...)
+	if err != nil {
+		return err
+	}
+
+	merger := layerreader.NewMerger()
+	for _, lp := range progresses {
+		err = l.readFileIndex(ctx, lp.digest, func(e *targzi.Entry) error {
+			merger.Add(ctx, lp.digest, *e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// the history goes first, so any image with an index has one
+	buf := &bytes.Buffer{}
+	err = merger.WriteHistory(buf)
+	if err != nil {
+		return fmt.Errorf("writing history index: %w", err)
+	}
+
+	_, err = l.storage.Put(ctx, storage.HistoryIndexKey(item.Repo, item.Digest), buf)
+	if err != nil {
+		return err
+	}
+
+	item.Efficiency = merger.Efficiency()
+
+	buf = &bytes.Buffer{}
+	err = merger.Write(buf)
+	if err != nil {
+		return fmt.Errorf("writing combined index: %w", err)
+	}
+
+	_, err = l.storage.Put(ctx, storage.ImageIndexKey(item.Repo, item.Digest), buf)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanSecrets adds the findings of the optional secret scan to an image that
+// has been indexed. Like the state machine, it runs once the image is saved as
+// indexed, which it still is if the scan fails.
+func (l *localBackend) scanSecrets(ctx context.Context, item *bitypes.ImageInfoItem) {
+	summary, err := secrets.ScanImage(ctx, l.storage, l.secretsClient, &item.ImageInfoKey, secrets.DefaultRules, localScanBudget)
+	if err != nil {
+		slog.ErrorContext(ctx, "scanning for secrets", "error", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the item may have changed since it was indexed
+	saved, err := l.readItem(ctx, &item.ImageInfoKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "reading image info", "error", err)
+		return
+	}
+
+	saved.Secrets = summary
+	err = l.writeItem(ctx, saved)
+	if err != nil {
+		slog.ErrorContext(ctx, "saving secret scan", "error", err)
+	}
+}
+
+// indexLayer stores the file and gzip indexes of a layer, unless an earlier
+// job has already done so.
+func (l *localBackend) indexLayer(ctx context.Context, layer v1.Layer, progress *localLayerProgress) error {
+	existing, err := l.storage.Get(ctx, storage.FileIndexKey(progress.digest))
+	if err == nil {
+		existing.Close()
+		progress.completedBytes.Store(progress.totalBytes)
+		return nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	mediaType, err := layer.MediaType()
+	if err != nil {
+		return fmt.Errorf("getting layer media type: %w", err)
+	}
+
+	compressed, err := layer.Compressed()
+	if err != nil {
+		return fmt.Errorf("getting layer reader: %w", err)
+	}
+	defer compressed.Close()
+
+	err = os.MkdirAll(l.tmp, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+
+	counter := &progressReader{r: compressed, count: &progress.completedBytes}
+	index, err := targzi.BuildIndexWithCompression(l.tmp, counter, targzi.CompressionFromMediaType(string(mediaType)), &progress.completedFiles)
+	if err != nil {
+		return fmt.Errorf("building index for layer %s: %w", progress.digest, err)
+	}
+	defer os.RemoveAll(filepath.Dir(index.FileIndexPath))
+
+	// the file index goes last, as its presence marks the layer as indexed
+	if index.GzIndexPath != "" {
+		err = l.put(ctx, storage.GzIndexKey(progress.digest), index.GzIndexPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return l.put(ctx, storage.FileIndexKey(progress.digest), index.FileIndexPath)
+}
+
+func (l *localBackend) put(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	_, err = l.storage.Put(ctx, key, f)
+	return err
+}
+
+func (l *localBackend) readFileIndex(ctx context.Context, layer string, fn func(e *targzi.Entry) error) error {
+	f, err := l.storage.Get(ctx, storage.FileIndexKey(layer))
+	if err != nil {
+		return fmt.Errorf("opening layer files index: %w", err)
+	}
+	defer f.Close()
+
+	err = targzi.ReadFileIndex(f, fn)
+	if err != nil {
+		return fmt.Errorf("reading layer files index: %w", err)
+	}
+
+	return nil
+}
+
+func infoKey(key *bitypes.ImageInfoKey) string {
+	return storage.ImagePrefix(key.Repo, key.Digest) + "info.json"
+}
+
+func (l *localBackend) readItem(ctx context.Context, key *bitypes.ImageInfoKey) (*bitypes.ImageInfoItem, error) {
+	body, err := l.storage.Get(ctx, infoKey(key))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	j, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("reading image info: %w", err)
+	}
+
+	item := &bitypes.ImageInfoItem{}
+	err = json.Unmarshal(j, item)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling image info: %w", err)
+	}
+
+	return item, nil
+}
+
+func (l *localBackend) writeItem(ctx context.Context, item *bitypes.ImageInfoItem) error {
+	j, _ := json.Marshal(item)
+	_, err := l.storage.Put(ctx, infoKey(&item.ImageInfoKey), bytes.NewReader(j))
+	return err
+}
+
+func indexInfoKey(key *bitypes.IndexInfoKey) string {
+	return storage.ImagePrefix(key.Repo, key.Digest) + "index.json"
+}
+
+func (l *localBackend) indexInfo(ctx context.Context, key *bitypes.IndexInfoKey) (*bitypes.IndexInfoItem, error) {
+	body, err := l.storage.Get(ctx, indexInfoKey(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	item := &bitypes.IndexInfoItem{}
+	err = json.NewDecoder(body).Decode(item)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling index info: %w", err)
+	}
+
+	return item, nil
+}
+
+func (l *localBackend) putIndexInfo(ctx context.Context, item *bitypes.IndexInfoItem) error {
+	j, _ := json.Marshal(item)
+	_, err := l.storage.Put(ctx, indexInfoKey(&item.IndexInfoKey), bytes.NewReader(j))
+	return err
+}
+
+func (l *localBackend) putVulns(ctx context.Context, key *bitypes.ImageInfoKey, vulns *bitypes.Vulns) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	item, err := l.readItem(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	item.Vulns = vulns
+	return l.writeItem(ctx, item)
+}
+
+// progressReader counts the bytes read through it.
+type progressReader struct {
+	r     io.Reader
+	count *atomic.Int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/extract"
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/oklog/ulid/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+
+	img, err := random.Image(1024, 3)
+	require.NoError(t, err)
+
+	repo := strings.TrimPrefix(srv.URL, "http://") + "/test/image"
+	ref, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	key := &bitypes.ImageInfoKey{Repo: repo, Digest: digest.String()}
+
+	dir := t.TempDir()
+	store := storage.NewLocal(dir)
+	b := newLocalBackend(store, filepath.Join(dir, "tmp"), func(ctx context.Context) []remote.Option {
+		return []remote.Option{remote.WithContext(ctx)}
+	})
+
+	item, _, err := b.imageInfo(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, item)
+
+	h := &handler{backend: b, storage: store, advisories: &advisories{storage: store, prefix: "advisories/"}}
+	index := storage.ImageIndexKey(key.Repo, key.Digest)
+	_, err = layerreader.ListImageIndex(ctx, store, index, "/")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	err = b.startIndexing(ctx, &bitypes.ImageInfoItem{ImageInfoKey: *key, Status: bitypes.ImageInfoStatusPending})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		item, _, err = b.imageInfo(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, item)
+		return item.Status != bitypes.ImageInfoStatusRunning
+	}, 10*time.Second, 10*time.Millisecond)
+	require.EqualValues(t, bitypes.ImageInfoStatusSucceeded, item.Status)
+	require.NotEmpty(t, item.Manifest)
+	require.NotNil(t, item.Efficiency)
+	require.Len(t, item.Efficiency.LayerBytes, 3)
+
+	entries, err := layerreader.ListImageIndex(ctx, store, index, "/")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	entries, err = layerreader.LookupImageIndex(ctx, store, index, entries[0].Hdr.Name)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	history, err := layerreader.LookupHistory(ctx, store, storage.HistoryIndexKey(key.Repo, key.Digest), entries[0].Hdr.Name)
+	require.NoError(t, err)
+	require.Len(t, history.Steps, 1)
+	require.Equal(t, layerreader.HistoryCreated, history.Steps[0].Action)
+
+	fromLayer, err := h.layerEntry(ctx, entries[0].Layer, entries[0].Hdr.Name)
+	require.NoError(t, err)
+	require.Equal(t, entries[0], *fromLayer)
+
+	// only the image's own layers can be viewed through it
+	ok, err := h.imageHasLayer(ctx, key, entries[0].Layer)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = h.imageHasLayer(ctx, key, "sha256:"+strings.Repeat("0", 64))
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = h.imageHasLayer(ctx, &bitypes.ImageInfoKey{Repo: key.Repo, Digest: "sha256:" + strings.Repeat("0", 64)}, entries[0].Layer)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	catalog, err := h.catalog(ctx, ref.Context(), key.Repo, key.Digest)
+	require.NoError(t, err)
+	require.Nil(t, catalog.Distro)
+	require.Empty(t, catalog.Packages)
+	cached, err := store.Get(ctx, storage.PackagesKey(key.Repo, key.Digest))
+	require.NoError(t, err)
+	cached.Close()
+
+	vulns, err := h.vulns(ctx, ref.Context(), key.Repo, key.Digest)
+	require.NoError(t, err)
+	require.Empty(t, vulns.Matches)
+	cached, err = store.Get(ctx, storage.VulnsKey(key.Repo, key.Digest))
+	require.NoError(t, err)
+	cached.Close()
+	item, _, err = h.backend.imageInfo(ctx, key)
+	require.NoError(t, err)
+	require.NotNil(t, item.Vulns)
+	require.Equal(t, vulns.Generated, item.Vulns.Generated)
+
+	cachedVulns, err := h.vulns(ctx, ref.Context(), key.Repo, key.Digest)
+	require.NoError(t, err)
+	require.Equal(t, vulns.Generated, cachedVulns.Generated)
+
+	gzi, err := extract.DownloadGzIndex(ctx, store, entries[0].Layer)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(gzi))
+}
+
+func TestLocalIndex(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+
+	platforms := []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}}
+	var idx v1.ImageIndex = empty.Index
+	for _, p := range platforms {
+		img, err := random.Image(1024, 2)
+		require.NoError(t, err)
+		p := p
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &p}})
+	}
+
+	// handlers take the image's tag off at the first colon, so the registry
+	// can't have a port
+	transport := &registryTransport{host: strings.TrimPrefix(srv.URL, "http://")}
+	repo := "registry.test/test/index"
+	ref, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, idx, remote.WithTransport(transport)))
+
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	store := storage.NewLocal(dir)
+	h := &handler{
+		storage:   store,
+		transport: transport,
+		entropy:   ulid.Monotonic(rand.New(rand.NewSource(1)), 0),
+	}
+	h.backend = newLocalBackend(store, filepath.Join(dir, "tmp"), h.remoteOptions)
+
+	info := func(query string) *HandleImageOutput {
+		w := httptest.NewRecorder()
+		h.handleInfo(w, httptest.NewRequest("GET", "/api/info?image="+repo+"&digest="+digest.String()+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		output := &HandleImageOutput{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), output))
+		require.Len(t, output.Platforms, 2)
+		return output
+	}
+
+	// as left behind by asking about the index before indexes were
+	// recognised
+	b := h.backend.(*localBackend)
+	require.NoError(t, b.writeItem(ctx, &bitypes.ImageInfoItem{
+		ImageInfoKey: bitypes.ImageInfoKey{Repo: repo, Digest: digest.String()},
+		Status:       bitypes.ImageInfoStatusFailed,
+		Version:      bitypes.ImageInfoVersion,
+	}))
+
+	// only the platform asked for is indexed, and the index isn't done
+	// while the other one isn't
+	output := info("&platforms=linux/arm64")
+	require.Empty(t, output.Platforms[0].Status)
+	require.NotEmpty(t, output.Platforms[1].Status)
+	require.Eventually(t, func() bool {
+		return info("").Platforms[1].Status == bitypes.ImageInfoStatusSucceeded
+	}, 10*time.Second, 10*time.Millisecond)
+	output = info("")
+	require.Equal(t, bitypes.ImageInfoStatusPending, output.Status)
+	require.Empty(t, output.Platforms[0].Status)
+
+	require.Eventually(t, func() bool {
+		output = info("&platforms=all")
+		return output.Status == bitypes.ImageInfoStatusSucceeded && output.Platforms[0].Status == bitypes.ImageInfoStatusSucceeded
+	}, 10*time.Second, 10*time.Millisecond)
+
+	item, _, err := h.backend.imageInfo(ctx, &bitypes.ImageInfoKey{Repo: repo, Digest: output.Platforms[1].Digest})
+	require.NoError(t, err)
+	require.Equal(t, digest.String(), item.Index)
+	require.Equal(t, "arm64", item.Platform.Architecture)
+
+	w := httptest.NewRecorder()
+	h.handleDiff(w, httptest.NewRequest("GET", "/api/diff?image="+repo+"&index="+digest.String()+"&from=linux/amd64&to=linux/arm64", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	// random layers have different files
+	require.NotEmpty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.handleDiff(w, httptest.NewRequest("GET", "/api/diff?image="+repo+"&index="+digest.String()+"&from=linux/amd64&to=windows/amd64", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestLocalOutdatedItem(t *testing.T) {
+	ctx := context.Background()
+
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	transport := &registryTransport{host: strings.TrimPrefix(srv.URL, "http://")}
+	repo := "registry.test/test/image"
+	ref, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img, remote.WithTransport(transport)))
+
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	key := &bitypes.ImageInfoKey{Repo: repo, Digest: digest.String()}
+
+	dir := t.TempDir()
+	store := storage.NewLocal(dir)
+	h := &handler{
+		storage:   store,
+		transport: transport,
+		entropy:   ulid.Monotonic(rand.New(rand.NewSource(1)), 0),
+	}
+	b := newLocalBackend(store, filepath.Join(dir, "tmp"), h.remoteOptions)
+	h.backend = b
+
+	// as left behind by the gztool indexer
+	require.NoError(t, b.writeItem(ctx, &bitypes.ImageInfoItem{ImageInfoKey: *key, Status: bitypes.ImageInfoStatusSucceeded, Version: 1}))
+
+	w := httptest.NewRecorder()
+	h.handleInfo(w, httptest.NewRequest("GET", "/api/info?image="+repo+"&digest="+digest.String(), nil))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var item *bitypes.ImageInfoItem
+	require.Eventually(t, func() bool {
+		item, _, err = b.imageInfo(ctx, key)
+		require.NoError(t, err)
+		return item != nil && item.Status == bitypes.ImageInfoStatusSucceeded
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, bitypes.ImageInfoVersion, item.Version)
+
+	w = httptest.NewRecorder()
+	h.handleInfo(w, httptest.NewRequest("GET", "/api/info?image="+repo+"&digest="+digest.String(), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// listing an image whose index can't be read indexes it again too
+	require.NoError(t, b.writeItem(ctx, &bitypes.ImageInfoItem{ImageInfoKey: *key, Status: bitypes.ImageInfoStatusSucceeded, Version: 1}))
+	_, err = store.Put(ctx, storage.ImageIndexKey(repo, digest.String()), strings.NewReader("not an index"))
+	require.NoError(t, err)
+
+	dirURL := "/api/dir?image=" + repo + "&digest=" + digest.String()
+	w = httptest.NewRecorder()
+	h.handleListDirectory(w, httptest.NewRequest("GET", dirURL, nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	require.Eventually(t, func() bool {
+		item, _, err = b.imageInfo(ctx, key)
+		require.NoError(t, err)
+		return item != nil && item.Status == bitypes.ImageInfoStatusSucceeded
+	}, 10*time.Second, 10*time.Millisecond)
+
+	w = httptest.NewRecorder()
+	h.handleListDirectory(w, httptest.NewRequest("GET", dirURL, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+// registryTransport sends every request to the test registry at host.
+type registryTransport struct {
+	host string
+}
+
+func (rt *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = rt.host
+	return http.DefaultTransport.RoundTrip(req)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRanges is the most ranges /api/file will serve in one multipart response.
+const maxRanges = 16
+
+// httpRange is a single byte range of a file, already resolved against the
+// file's size.
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+var (
+	errMalformedRange     = errors.New("malformed range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// parseRange parses a Range header as described by RFC 9110 section 14.1.2.
+// Malformed headers return errMalformedRange, which callers should treat as
+// if no Range header was sent. Ranges that start beyond the end of the file
+// are dropped, and if none remain errUnsatisfiableRange is returned.
+func parseRange(header string, size int64) ([]httpRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errMalformedRange
+	}
+
+	ranges := []httpRange{}
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errMalformedRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		r := httpRange{}
+		if first == "" {
+			// suffix range: the final N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errMalformedRange
+			}
+			if n == 0 {
+				continue
+			}
+			n = min(n, size)
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errMalformedRange
+			}
+
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errMalformedRange
+				}
+				end = min(end, size-1)
+			}
+
+			if start >= size {
+				continue
+			}
+			r.start = start
+			r.length = end - start + 1
+		}
+
+		if r.length > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []httpRange
+		err      error
+	}{
+		{header: "", expected: nil},
+		{header: "bytes=0-99", expected: []httpRange{{start: 0, length: 100}}},
+		{header: "bytes=900-", expected: []httpRange{{start: 900, length: 100}}},
+		{header: "bytes=-64", expected: []httpRange{{start: 936, length: 64}}},
+		{header: "bytes=-5000", expected: []httpRange{{start: 0, length: 1000}}},
+		{header: "bytes=990-2000", expected: []httpRange{{start: 990, length: 10}}},
+		{header: "bytes=0-0, -1", expected: []httpRange{{start: 0, length: 1}, {start: 999, length: 1}}},
+		{header: "bytes= 0-3 ,10-19", expected: []httpRange{{start: 0, length: 4}, {start: 10, length: 10}}},
+		{header: "bytes=5000-6000, 10-19", expected: []httpRange{{start: 10, length: 10}}},
+		{header: "bytes=1000-", err: errUnsatisfiableRange},
+		{header: "bytes=-0", err: errUnsatisfiableRange},
+		{header: "items=0-10", err: errMalformedRange},
+		{header: "bytes=10-5", err: errMalformedRange},
+		{header: "bytes=abc", err: errMalformedRange},
+		{header: "bytes=-x", err: errMalformedRange},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.header, func(t *testing.T) {
+			ranges, err := parseRange(tc.header, 1000)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, ranges)
+		})
+	}
+}
+
+func TestContentRange(t *testing.T) {
+	require.Equal(t, "bytes 10-19/1000", httpRange{start: 10, length: 10}.contentRange(1000))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"browseimage/extract"
+	"browseimage/layerreader"
+	"browseimage/sbom"
+	"browseimage/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// sbomBudget bounds how long finding an image's packages can take, as the
+// lambda itself is cut off after a few minutes
+const sbomBudget = 60 * time.Second
+
+// handleSBOM describes the packages installed in an image as an SPDX or
+// CycloneDX JSON document.
+func (h *handler) handleSBOM(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+
+	format := q.Get("format")
+	var write func(io.Writer, *sbom.Document) error
+	switch format {
+	case "", "spdx-json":
+		format = "spdx-json"
+		write = sbom.WriteSPDX
+	case "cyclonedx-json":
+		write = sbom.WriteCycloneDX
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format %q, want spdx-json or cyclonedx-json", format), http.StatusBadRequest)
+		return
+	}
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	catalog, err := h.catalog(ctx, ref.Context(), image, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "finding packages took too long", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	buf := &bytes.Buffer{}
+	err = write(buf, &sbom.Document{Repo: image, Digest: digest, Created: time.Now(), Catalog: catalog})
+	if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.TrimPrefix(digest, "sha256:")+"."+format))
+	w.Write(buf.Bytes())
+}
+
+// catalog returns the packages found in an image, finding them the first
+// time it's asked and caching them after.
+func (h *handler) catalog(ctx context.Context, repo name.Repository, image, digest string) (*sbom.Catalog, error) {
+	key := storage.PackagesKey(image, digest)
+
+	cached, err := h.storage.Get(ctx, key)
+	if err == nil {
+		defer cached.Close()
+
+		catalog := &sbom.Catalog{}
+		err = json.NewDecoder(cached).Decode(catalog)
+		if err == nil {
+			return catalog, nil
+		}
+		slog.WarnContext(ctx, "decoding cached packages, finding them again", "key", key, "error", err)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("getting cached packages: %w", err)
+	}
+
+	entries, err := layerreader.LoadImageIndex(ctx, h.storage, storage.ImageIndexKey(image, digest), "")
+	if err != nil {
+		return nil, fmt.Errorf("loading image index: %w", err)
+	}
+
+	ctx = auth.WithScopes(ctx, repo.Scope("pull"))
+	ctx, cancel := context.WithTimeout(ctx, sbomBudget)
+	defer cancel()
+
+	indexes := h.newLayerIndexes()
+	defer indexes.Close()
+
+	catalog, err := sbom.Find(ctx, entries, extract.Reader(h.http, repo, indexes))
+	if err != nil {
+		return nil, fmt.Errorf("finding packages: %w", err)
+	}
+
+	j, err := json.Marshal(catalog)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling packages: %w", err)
+	}
+	_, err = h.storage.Put(ctx, key, bytes.NewReader(j))
+	if err != nil {
+		return nil, fmt.Errorf("caching packages: %w", err)
+	}
+
+	return catalog, nil
+}
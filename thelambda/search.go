@@ -0,0 +1,76 @@
+package main
+
+import (
+	"browseimage/layerreader"
+	"browseimage/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+type searchOutput struct {
+	Results []*layerreader.EntryWithLayer
+	// Next is the cursor for the next page, if there is one
+	Next string `json:",omitempty"`
+}
+
+// handleSearch finds paths in an image matching a glob or substring, a page
+// at a time in the order of the image index, where the children of each
+// directory are together. The cursor is the last path of the previous page.
+func (h *handler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	cursor := q.Get("cursor")
+
+	matcher, err := layerreader.NewPathMatcher(q.Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxSearchLimit)
+	}
+
+	output := searchOutput{Results: []*layerreader.EntryWithLayer{}}
+	err = layerreader.ScanImageIndex(ctx, h.storage, storage.ImageIndexKey(image, digest), cursor, func(e *layerreader.EntryWithLayer) bool {
+		if !matcher.Match(e.Hdr.Name) {
+			return true
+		}
+
+		if len(output.Results) == limit {
+			output.Next = output.Results[limit-1].Hdr.Name
+			return false
+		}
+		output.Results = append(output.Results, e)
+		return true
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	j, _ := json.Marshal(output)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	w.Write(j)
+}
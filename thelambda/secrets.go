@@ -0,0 +1,35 @@
+package main
+
+import (
+	"browseimage/secrets"
+	"browseimage/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// handleSecrets lists the files under path with findings from the secret
+// scan, so each can be opened at the flagged line.
+func (h *handler) handleSecrets(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+	path := q.Get("path")
+
+	files, err := secrets.Lookup(ctx, h.storage, storage.SecretsIndexKey(image, digest), path)
+	if errors.Is(err, storage.ErrNotFound) {
+		// the image hasn't been scanned
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	j, _ := json.Marshal(files)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
@@ -1,35 +1,38 @@
 package main
 
 import (
+	"archive/tar"
 	"browseimage/bitypes"
+	"browseimage/extract"
 	"browseimage/handlehttp"
 	"browseimage/layerreader"
 	"browseimage/logging"
-	"browseimage/s3select"
-	"browseimage/targzi"
+	"browseimage/storage"
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"math/rand"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sfn"
 	"github.com/aws/aws-xray-sdk-go/instrumentation/awsv2"
 	"github.com/aws/aws-xray-sdk-go/xray"
-	"github.com/aws/smithy-go"
 	"github.com/glassechidna/go-emf/emf"
 	"github.com/glassechidna/go-emf/emf/unit"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -48,27 +51,49 @@ func main() {
 
 	emf.Namespace = "browseimage"
 
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+	h := &handler{
+		entropy: ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0),
 	}
 
-	awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+	if dir := os.Getenv("STORAGE_DIR"); dir != "" {
+		// local mode: no AWS account needed
+		h.http = &auth.Client{
+			Client: http.DefaultClient,
+			Cache:  auth.DefaultCache,
+		}
+		h.transport = http.DefaultTransport
+		h.storage = storage.NewLocal(dir)
+		b := newLocalBackend(h.storage, filepath.Join(dir, "tmp"), h.remoteOptions)
+		if os.Getenv("SCAN_SECRETS") == "true" {
+			b.secretsClient = h.http
+		}
+		h.backend = b
+	} else {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		}
 
-	h := &handler{
-		s3:     s3.NewFromConfig(cfg),
-		bucket: os.Getenv("BUCKET"),
-		http: &auth.Client{
+		awsv2.AWSV2Instrumentor(&cfg.APIOptions)
+
+		h.http = &auth.Client{
 			//Client: xray.Client(&http.Client{Transport: &transport{RoundTripper: http.DefaultTransport}}),
 			Client: xray.Client(http.DefaultClient),
 			Cache:  auth.DefaultCache,
-		},
-		transport: xray.RoundTripper(http.DefaultTransport),
-		dynamodb:  dynamodb.NewFromConfig(cfg),
-		table:     os.Getenv("TABLE"),
-		sfn:       sfn.NewFromConfig(cfg),
-		machine:   os.Getenv("MACHINE"),
-		entropy:   ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0),
+		}
+		h.transport = xray.RoundTripper(http.DefaultTransport)
+		h.storage = storage.NewS3(s3.NewFromConfig(cfg), os.Getenv("BUCKET"))
+		h.backend = &awsBackend{
+			dynamodb: dynamodb.NewFromConfig(cfg),
+			table:    os.Getenv("TABLE"),
+			sfn:      sfn.NewFromConfig(cfg),
+			machine:  os.Getenv("MACHINE"),
+		}
+	}
+
+	h.advisories = &advisories{storage: h.storage, prefix: "advisories/"}
+	if dir := os.Getenv("ADVISORY_DIR"); dir != "" {
+		h.advisories = &advisories{storage: storage.NewLocal(dir)}
 	}
 
 	r := mux.NewRouter()
@@ -76,6 +101,16 @@ func main() {
 	r.HandleFunc("/api/file", h.handleFileContents)
 	r.HandleFunc("/api/info", h.handleInfo)
 	r.HandleFunc("/api/lookup", h.handleLookup)
+	r.HandleFunc("/api/diff", h.handleDiff)
+	r.HandleFunc("/api/search", h.handleSearch)
+	r.HandleFunc("/api/grep", h.handleGrep)
+	r.HandleFunc("/api/archive", h.handleArchive)
+	r.HandleFunc("/api/history", h.handleHistory)
+	r.HandleFunc("/api/sbom", h.handleSBOM)
+	r.HandleFunc("/api/vulns", h.handleVulns)
+	r.HandleFunc("/api/secrets", h.handleSecrets)
+	r.HandleFunc("/api/binary", h.handleBinary)
+	r.Use(validateDigests)
 
 	r.Use(func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -123,28 +158,32 @@ func main() {
 	})
 
 	if _, ok := os.LookupEnv("_HANDLER"); ok {
-		lambda.Start(handlehttp.WrapHandler(r))
+		lambda.Start(handlehttp.WrapStreamingHandler(r))
 	} else {
-		err = http.ListenAndServe(":8080", r)
+		addr := os.Getenv("LISTEN_ADDR")
+		if addr == "" {
+			addr = ":8080"
+		}
+		err := http.ListenAndServe(addr, r)
 		panic(err)
 	}
 }
 
 type handler struct {
-	s3        *s3.Client
-	bucket    string
+	backend   backend
+	storage   storage.Storage
 	http      *auth.Client
 	transport http.RoundTripper
-	dynamodb  *dynamodb.Client
-	table     string
-	sfn       *sfn.Client
-	machine   string
 	entropy   io.Reader
+	// advisories are the OSV dumps /api/vulns matches packages against
+	advisories *advisories
 }
 
 type lookupOutput struct {
 	Error   string        `json:",omitempty"`
 	Options []imageOption `json:",omitempty"`
+	// Index is the digest of the index the options are from, if any
+	Index *v1.Hash `json:",omitempty"`
 }
 
 type imageOption struct {
@@ -178,6 +217,7 @@ func (h *handler) handleLookup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	output := lookupOutput{}
 	opts := []imageOption{}
 
 	desc, err := remote.Get(ref, h.remoteOptions(ctx)...)
@@ -199,6 +239,7 @@ func (h *handler) handleLookup(w http.ResponseWriter, r *http.Request) {
 			writeOutput(lookupOutput{Error: err.Error()})
 			return
 		}
+		output.Index = &desc.Digest
 
 		for _, manifest := range im.Manifests {
 			opts = append(opts, imageOption{
@@ -228,7 +269,8 @@ func (h *handler) handleLookup(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	writeOutput(lookupOutput{Options: opts})
+	output.Options = opts
+	writeOutput(output)
 }
 
 type LayerProgress struct {
@@ -241,67 +283,100 @@ type LayerProgress struct {
 
 type HandleImageOutput struct {
 	Status          bitypes.ImageInfoStatus
-	Repo            string          `json:",omitempty"`
-	Digest          string          `json:",omitempty"`
-	ExecutionId     string          `json:",omitempty"`
-	Progresses      []LayerProgress `json:",omitempty"`
-	TotalSize       int64           `json:",omitempty"`
-	CompletedSize   int64           `json:",omitempty"`
-	EstimateSeconds int64           `json:",omitempty"`
-	DurationSeconds float64         `json:",omitempty"`
-	Retrieved       time.Time       `json:",omitempty"`
-	Config          json.RawMessage `json:",omitempty"`
-	Manifest        json.RawMessage `json:",omitempty"`
+	Repo            string              `json:",omitempty"`
+	Digest          string              `json:",omitempty"`
+	ExecutionId     string              `json:",omitempty"`
+	Progresses      []LayerProgress     `json:",omitempty"`
+	TotalSize       int64               `json:",omitempty"`
+	CompletedSize   int64               `json:",omitempty"`
+	EstimateSeconds int64               `json:",omitempty"`
+	DurationSeconds float64             `json:",omitempty"`
+	Retrieved       time.Time           `json:",omitempty"`
+	Config          json.RawMessage     `json:",omitempty"`
+	Manifest        json.RawMessage     `json:",omitempty"`
+	Efficiency      *bitypes.Efficiency `json:",omitempty"`
+	Secrets         *bitypes.Secrets    `json:",omitempty"`
+	Vulns           *bitypes.Vulns      `json:",omitempty"`
+	// Details decodes Config and Manifest
+	Details *imageDetails `json:",omitempty"`
+	// Index and Platform are set for images indexed as a platform of an
+	// index, and Platforms for the index itself
+	Index     string           `json:",omitempty"`
+	Platform  *v1.Platform     `json:",omitempty"`
+	Platforms []platformOutput `json:",omitempty"`
 }
 
 func estimateSeconds(totalSize int64) int64 {
 	return 2 + (totalSize / 25e6)
 }
 
-func (h *handler) handleStartExecution(key *bitypes.ImageInfoKey, w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// validateDigests turns away requests whose digest parameters aren't
+// digests. They end up in storage keys, where anything else could name some
+// other object.
+func validateDigests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		for _, param := range []string{"digest", "layer", "index"} {
+			if value := q.Get(param); value != "" {
+				_, err := v1.NewHash(value)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("invalid %s: %v", param, err), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
 
+// newImageInfoItem is a pending item for an image, with a new execution ID.
+func (h *handler) newImageInfoItem(key *bitypes.ImageInfoKey) *bitypes.ImageInfoItem {
 	executionId := fmt.Sprintf("BI%s10", ulid.MustNew(ulid.Timestamp(time.Now()), h.entropy))
 
-	item := &bitypes.ImageInfoItem{
+	return &bitypes.ImageInfoItem{
 		ImageInfoKey: *key,
 		ExecutionId:  executionId,
 		Status:       "PENDING",
 		Retrieved:    time.Now(),
+		Version:      bitypes.ImageInfoVersion,
 	}
+}
 
-	_, err := h.dynamodb.PutItem(ctx, &dynamodb.PutItemInput{
-		TableName:           &h.table,
-		Item:                item.DynamoItem(),
-		ConditionExpression: aws.String("attribute_not_exists(pk)"),
-	})
+// reindexOutdated is for when an image's indexes can't be read. If the image
+// was indexed with an older ImageInfoVersion, it is indexed again and the
+// client is told to come back once /api/info reports it done. It returns
+// false, without responding, for errors that have some other cause.
+func (h *handler) reindexOutdated(w http.ResponseWriter, r *http.Request, key *bitypes.ImageInfoKey) bool {
+	ctx := r.Context()
+
+	item, _, err := h.backend.imageInfo(ctx, key)
 	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+		slog.ErrorContext(ctx, "getting image info", "error", err)
+		return false
+	} else if item == nil || item.Version >= bitypes.ImageInfoVersion {
+		return false
 	}
 
-	sfnInput, _ := json.Marshal(key)
-
-	_, err = h.sfn.StartExecution(ctx, &sfn.StartExecutionInput{
-		StateMachineArn: &h.machine,
-		Name:            &executionId,
-		TraceHeader:     aws.String(os.Getenv("_X_AMZN_TRACE_ID")),
-		Input:           aws.String(string(sfnInput)),
-	})
-	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+	if item.Status == bitypes.ImageInfoStatusSucceeded || item.Status == bitypes.ImageInfoStatusFailed {
+		slog.InfoContext(ctx, "reindexing image with an outdated index", "version", item.Version)
+		// a concurrent request may have started it already
+		err = h.backend.startIndexing(ctx, h.newImageInfoItem(key))
+		if err != nil {
+			slog.WarnContext(ctx, "reindexing image", "error", err)
+		}
 	}
 
-	_, err = h.dynamodb.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		TableName:                &h.table,
-		Key:                      item.Key(),
-		UpdateExpression:         aws.String("SET #status = :status"),
-		ConditionExpression:      aws.String("ExecutionId = :executionId"),
-		ExpressionAttributeNames: map[string]string{"#status": "Status"},
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":executionId": &types.AttributeValueMemberS{Value: executionId},
-			":status":      &types.AttributeValueMemberS{Value: "RUNNING"},
-		},
-	})
+	w.Header().Set("Retry-After", "10")
+	http.Error(w, "image is being indexed again, see /api/info", http.StatusServiceUnavailable)
+	return true
+}
+
+func (h *handler) handleStartExecution(key *bitypes.ImageInfoKey, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	item := h.newImageInfoItem(key)
+	err := h.backend.startIndexing(ctx, item)
 	if err != nil {
 		panic(fmt.Sprintf("%+v", err))
 	}
@@ -321,60 +396,54 @@ func (h *handler) handleInfo(w http.ResponseWriter, r *http.Request) {
 	repo, _, _ := strings.Cut(image, ":") // drop tag (if any)
 	digest := q.Get("digest")
 
-	p := dynamodb.NewQueryPaginator(h.dynamodb, &dynamodb.QueryInput{
-		TableName:              &h.table,
-		ConsistentRead:         aws.Bool(true),
-		KeyConditionExpression: aws.String("pk = :pk and begins_with(sk, :sk)"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: fmt.Sprintf("image#%s", repo)},
-			":sk": &types.AttributeValueMemberS{Value: fmt.Sprintf("digest#%s", digest)},
-		},
-	})
+	key := &bitypes.ImageInfoKey{Repo: repo, Digest: digest}
+	imageInfo, layerProgresses, err := h.backend.imageInfo(ctx, key)
+	if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
 
-	imageInfo := bitypes.ImageInfoItem{}
-	progresses := []LayerProgress{}
-	var completedSize int64 = 0
+	// images indexed before the current index formats can't be read any
+	// more, so they are indexed again
+	if imageInfo != nil && imageInfo.Version < bitypes.ImageInfoVersion &&
+		(imageInfo.Status == bitypes.ImageInfoStatusSucceeded || imageInfo.Status == bitypes.ImageInfoStatusFailed) {
+		slog.InfoContext(ctx, "reindexing image with an outdated index", "version", imageInfo.Version)
+		imageInfo = nil
+	}
 
-	for p.HasMorePages() {
-		page, err := p.NextPage(ctx)
+	// image has not been indexed yet, or is an index of images. Indexes
+	// asked for before they were recognised failed to index as images, before
+	// their manifest was recorded (which local items store as null).
+	failedIndex := imageInfo != nil && imageInfo.Status == bitypes.ImageInfoStatusFailed &&
+		(len(imageInfo.Manifest) == 0 || string(imageInfo.Manifest) == "null")
+	if imageInfo == nil || failedIndex {
+		index, err := h.indexInfo(ctx, key)
 		if err != nil {
 			panic(fmt.Sprintf("%+v", err))
-		}
-
-		for _, item := range page.Items {
-			sk := strings.Split(item["sk"].(*types.AttributeValueMemberS).Value, "#")
-			if len(sk) == 2 {
-				err = attributevalue.UnmarshalMap(item, &imageInfo)
-				if err != nil {
-					panic(fmt.Sprintf("%+v", err))
-				}
-			} else if len(sk) == 4 {
-				lp := bitypes.LayerProgress{}
-				err = attributevalue.UnmarshalMap(item, &lp)
-				if err != nil {
-					panic(fmt.Sprintf("%+v", err))
-				}
-				progresses = append(progresses, LayerProgress{
-					Layer:          lp.LayerDigest,
-					TotalBytes:     lp.TotalBytes,
-					CompletedBytes: lp.CompletedBytes,
-					TotalFiles:     lp.TotalFiles,
-					CompletedFiles: lp.CompletedFiles,
-				})
-
-				completedSize += lp.CompletedBytes
-			} else {
-				panic(fmt.Sprintf("unexpected dynamo item: %+v", item))
-			}
+		} else if index != nil {
+			h.handleIndex(w, r, index)
+			return
 		}
 	}
 
-	// image was not in dynamodb
-	if imageInfo.Digest == "" {
-		h.handleStartExecution(&bitypes.ImageInfoKey{Repo: repo, Digest: digest}, w, r)
+	if imageInfo == nil {
+		h.handleStartExecution(key, w, r)
 		return
 	}
 
+	progresses := []LayerProgress{}
+	var completedSize int64 = 0
+	for _, lp := range layerProgresses {
+		progresses = append(progresses, LayerProgress{
+			Layer:          lp.LayerDigest,
+			TotalBytes:     lp.TotalBytes,
+			CompletedBytes: lp.CompletedBytes,
+			TotalFiles:     lp.TotalFiles,
+			CompletedFiles: lp.CompletedFiles,
+		})
+
+		completedSize += lp.CompletedBytes
+	}
+
 	maxAge := time.Second
 	if imageInfo.Status == bitypes.ImageInfoStatusSucceeded {
 		maxAge = time.Hour * 24
@@ -393,6 +462,19 @@ func (h *handler) handleInfo(w http.ResponseWriter, r *http.Request) {
 		Retrieved:       imageInfo.Retrieved,
 		Config:          imageInfo.RawConfig,
 		Manifest:        imageInfo.Manifest,
+		Efficiency:      imageInfo.Efficiency,
+		Secrets:         imageInfo.Secrets,
+		Vulns:           imageInfo.Vulns,
+		Index:           imageInfo.Index,
+		Platform:        imageInfo.Platform,
+	}
+
+	if len(imageInfo.Manifest) > 0 && len(imageInfo.RawConfig) > 0 {
+		output.Details, err = newImageDetails(imageInfo.Manifest, imageInfo.RawConfig, imageInfo.Efficiency)
+		if err != nil {
+			// the raw config and manifest are still there to look at
+			slog.WarnContext(ctx, "decoding image details", "error", err)
+		}
 	}
 
 	j, _ := json.Marshal(output)
@@ -410,12 +492,15 @@ func (h *handler) handleListDirectory(w http.ResponseWriter, r *http.Request) {
 	image := q.Get("image")
 	image, tag, _ := strings.Cut(image, ":") // drop tag (if any)
 	digest := q.Get("digest")
-	key := fmt.Sprintf("images/%s/%s/index.json.gz", image, digest)
+	key := storage.ImageIndexKey(image, digest)
+	infoKey := &bitypes.ImageInfoKey{Repo: image, Digest: digest}
 
 	path := q.Get("path")
 	if path == "" {
 		path = "/"
 	}
+	follow, _ := strconv.ParseBool(q.Get("follow"))
+	layer := q.Get("layer")
 
 	msi := emf.MSI{
 		"Image":      image,
@@ -426,15 +511,41 @@ func (h *handler) handleListDirectory(w http.ResponseWriter, r *http.Request) {
 	}
 	defer emf.Emit(msi)
 
-	escapedPath := strings.ReplaceAll(path, "'", "''")
-	query := fmt.Sprintf("SELECT * FROM s3object s WHERE s.Parent = '%s'", escapedPath)
-	entries, err := s3select.Select[layerreader.EntryWithLayer](ctx, h.s3, h.bucket, key, query)
+	if layer != "" {
+		h.handleListLayerDirectory(w, r, msi, infoKey, layer, path)
+		return
+	}
+
+	if follow && path != "/" {
+		// list where the path really leads, through any symlinked
+		// directories along the way
+		dir, err := layerreader.Resolve(ctx, layerreader.ImageIndexLookup(h.storage, key), path)
+		if errors.Is(err, layerreader.ErrLinkLoop) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			msi["StatusCode"] = emf.Dimension("400")
+			return
+		} else if err == nil && dir.Hdr.Typeflag == tar.TypeDir {
+			path = dir.Hdr.Name
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, storage.ErrNotFound) {
+			if h.reindexOutdated(w, r, infoKey) {
+				msi["StatusCode"] = emf.Dimension("503")
+				return
+			}
+			msi["StatusCode"] = emf.Dimension("500")
+			panic(fmt.Sprintf("%+v", err))
+		}
+		// otherwise the listing is empty, just as without following
+	}
+
+	entries, err := layerreader.ListImageIndex(ctx, h.storage, key, path)
 	if err != nil {
-		var ae smithy.APIError
-		if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchKey" {
+		if errors.Is(err, storage.ErrNotFound) {
 			http.NotFound(w, r)
 			msi["StatusCode"] = emf.Dimension("404")
 			return
+		} else if h.reindexOutdated(w, r, infoKey) {
+			msi["StatusCode"] = emf.Dimension("503")
+			return
 		}
 
 		msi["StatusCode"] = emf.Dimension("500")
@@ -454,113 +565,222 @@ func (h *handler) handleFileContents(w http.ResponseWriter, r *http.Request) {
 	image := q.Get("image")
 	image, _, _ = strings.Cut(image, ":") // drop tag (if any)
 	digest := q.Get("digest")
-	key := fmt.Sprintf("images/%s/%s/index.json.gz", image, digest)
-	//prefix := h.prefix(ctx, img, digest)
+	key := storage.ImageIndexKey(image, digest)
+	infoKey := &bitypes.ImageInfoKey{Repo: image, Digest: digest}
 
 	path := q.Get("path")
+	layer := q.Get("layer")
 
-	// Escape single quotes for S3 Select SQL (S3 Select uses '' escaping, not backslash)
-	escapedPath := strings.ReplaceAll(path, "'", "''")
-	query := fmt.Sprintf("SELECT * FROM s3object s WHERE s.Hdr.Name = '%s'", escapedPath)
-	entries, err := s3select.Select[layerreader.EntryWithLayer](ctx, h.s3, h.bucket, key, query)
-	if err != nil {
+	var resolved *layerreader.EntryWithLayer
+	var err error
+	if layer != "" {
+		var ok bool
+		ok, err = h.imageHasLayer(ctx, infoKey, layer)
+		if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		} else if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		// the file as that layer has it, where links can't be followed
+		resolved, err = h.layerEntry(ctx, layer, path)
+	} else {
+		// links are followed, so the body is always the file they point to
+		resolved, err = layerreader.Resolve(ctx, layerreader.ImageIndexLookup(h.storage, key), path)
+	}
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, layerreader.ErrLinkLoop) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		if h.reindexOutdated(w, r, infoKey) {
+			return
+		}
 		panic(fmt.Sprintf("%+v", err))
 	}
 
-	if len(entries) != 1 {
-		panic(fmt.Errorf("unexpected number of entries: %d", len(entries)))
+	if resolved.Hdr.Typeflag != tar.TypeReg {
+		http.Error(w, fmt.Sprintf("%s is not a regular file", resolved.Hdr.Name), http.StatusBadRequest)
+		return
 	}
-	entry := entries[0]
+	entry := *resolved
 
-	get, err := h.s3.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &h.bucket,
-		Key:    aws.String(fmt.Sprintf("layers/%s/index.gzi", entry.Layer)),
-	})
+	ref, err := name.ParseReference(image)
 	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+		panic(err)
 	}
 
-	indexFile, err := os.CreateTemp("", "gzi*")
+	repo := ref.Context()
+	slog.DebugContext(ctx, "parsed image reference",
+		"ref", ref.String(),
+		"context", repo.String(),
+		"registry", repo.Registry.String(),
+		"identifier", ref.Identifier(),
+		"repostr", repo.RepositoryStr(),
+	)
+
+	scope := ref.Scope("pull")
+	ctx = auth.WithScopes(ctx, scope)
+
+	indexes := h.newLayerIndexes()
+	defer indexes.Close()
+
+	fe, err := h.newFileExtractor(ctx, repo, entry, indexes)
 	if err != nil {
+		// such as targzi.ErrGztoolIndex
+		if h.reindexOutdated(w, r, infoKey) {
+			return
+		}
 		panic(fmt.Sprintf("%+v", err))
 	}
-	defer os.Remove(indexFile.Name())
 
-	defer get.Body.Close()
-	_, err = io.Copy(indexFile, get.Body)
-	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+	size := entry.Hdr.Size
+	ranges, err := parseRange(r.Header.Get("Range"), size)
+	if errors.Is(err, errUnsatisfiableRange) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	} else if err != nil || len(ranges) > maxRanges {
+		// RFC 9110 allows ignoring the header entirely, and every range
+		// costs a request to the registry
+		slog.DebugContext(ctx, "ignoring range header", "range", r.Header.Get("Range"), "error", err)
+		ranges = nil
 	}
 
-	err = indexFile.Close()
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	partial := len(ranges) > 0
+	if !partial {
+		ranges = []httpRange{{start: 0, length: size}}
+	}
+
+	first, err := fe.open(ranges[0])
 	if err != nil {
 		panic(fmt.Sprintf("%+v", err))
 	}
+	defer first.Close()
 
-	spans, err := targzi.Spans(indexFile.Name())
-	if err != nil {
-		panic(fmt.Errorf("reading spans: %w", err))
+	// DetectContentType never looks at more than the first 512 bytes
+	br := bufio.NewReaderSize(first, 512)
+	contentType := ""
+	if ranges[0].start == 0 {
+		sniff, err := br.Peek(512)
+		if err != nil && err != io.EOF {
+			panic(fmt.Sprintf("%+v", err))
+		}
+		contentType = http.DetectContentType(sniff)
+	} else {
+		contentType, err = fe.sniff()
+		if err != nil {
+			panic(fmt.Sprintf("%+v", err))
+		}
 	}
 
-	if len(entry.Spans) == 0 {
-		panic(fmt.Errorf("entry has no spans"))
+	if !partial {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+		fe.copy(w, br)
+		return
 	}
 
-	//span := IndexSpan{}
-	start := 0
-	end := 0
-	firstSpan := entry.Spans[0]
-	lastSpan := entry.Spans[len(entry.Spans)-1]
-	for idx, s := range spans {
-		if s.Number == firstSpan {
-			start = s.Compressed
-		}
-		if s.Number == lastSpan {
-			if idx+1 < len(spans) {
-				end = spans[idx+1].Compressed
+	if len(ranges) == 1 {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", ranges[0].length))
+		w.Header().Set("Content-Range", ranges[0].contentRange(size))
+		w.WriteHeader(http.StatusPartialContent)
+		fe.copy(w, br)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+
+	for idx, rng := range ranges {
+		var body io.Reader = br
+		if idx > 0 {
+			extracted, err := fe.open(rng)
+			if err != nil {
+				// headers are already on the wire, so all we can do is cut the body short
+				slog.ErrorContext(ctx, "extracting range", "error", err, "range", rng)
+				return
 			}
+			defer extracted.Close()
+			body = extracted
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {rng.contentRange(size)},
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "writing multipart header", "error", err)
+			return
 		}
-	}
 
-	rangeHdr := fmt.Sprintf("bytes=%d-", start-1)
-	if end > 0 {
-		rangeHdr += fmt.Sprintf("%d", end)
+		if !fe.copy(part, body) {
+			return
+		}
 	}
 
-	ref, err := name.ParseReference(image)
+	mw.Close()
+}
+
+// layerIndexes downloads the gzip index of each layer at most once, for
+// requests that read many files.
+type layerIndexes = extract.Indexes
+
+func (h *handler) newLayerIndexes() *layerIndexes {
+	return &layerIndexes{Storage: h.storage}
+}
+
+// newFileExtractor prepares to read entry from its layer blob in repo. ctx
+// must carry the pull scope for repo.
+func (h *handler) newFileExtractor(ctx context.Context, repo name.Repository, entry layerreader.EntryWithLayer, indexes *layerIndexes) (*fileExtractor, error) {
+	f, err := extract.NewFile(ctx, h.http, repo, entry, indexes)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return &fileExtractor{File: f, ctx: ctx, entry: entry}, nil
+}
 
-	repo := ref.Context()
-	slog.DebugContext(ctx, "parsed image reference",
-		"ref", ref.String(),
-		"context", repo.String(),
-		"registry", repo.Registry.String(),
-		"identifier", ref.Identifier(),
-		"repostr", repo.RepositoryStr(),
-	)
+// fileExtractor serves ranges of a single file from its layer blob.
+type fileExtractor struct {
+	*extract.File
+	ctx   context.Context
+	entry layerreader.EntryWithLayer
+}
 
-	scope := ref.Scope("pull")
-	ctx = auth.WithScopes(ctx, scope)
+func (fe *fileExtractor) open(rng httpRange) (*extract.Extraction, error) {
+	return fe.Open(rng.start, rng.length)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%s/v2/%s/blobs/%s", repo.RegistryStr(), repo.RepositoryStr(), entry.Layer), nil)
+// sniff detects the content type from the start of the file, for responses
+// that don't otherwise include it.
+func (fe *fileExtractor) sniff() (string, error) {
+	extracted, err := fe.open(httpRange{start: 0, length: min(512, fe.entry.Hdr.Size)})
 	if err != nil {
-		panic(fmt.Errorf("creating HTTP request: %w", err))
+		return "", err
 	}
-	req.Header.Set("Range", rangeHdr)
+	defer extracted.Close()
 
-	resp, err := h.http.Do(req)
+	head, err := io.ReadAll(extracted)
 	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+		return "", fmt.Errorf("reading start of file: %w", err)
 	}
 
-	defer resp.Body.Close()
-	extracted, err := targzi.Extract(ctx, resp.Body, indexFile.Name(), start, entry.Offset, int(entry.Hdr.Size))
+	return http.DetectContentType(head), nil
+}
+
+func (fe *fileExtractor) copy(w io.Writer, r io.Reader) bool {
+	_, err := io.Copy(w, r)
 	if err != nil {
-		panic(fmt.Sprintf("%+v", err))
+		// headers are already on the wire, so all we can do is cut the body short
+		slog.ErrorContext(fe.ctx, "streaming file contents", "error", err)
+		return false
 	}
-
-	w.Header().Set("Content-Type", http.DetectContentType(extracted))
-	w.Write(extracted)
+	return true
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDigests(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for query, code := range map[string]int{
+		"image=test/image":                                   http.StatusNoContent,
+		"image=test/image&digest=" + digest:                  http.StatusNoContent,
+		"digest=" + digest + "&layer=" + digest:              http.StatusNoContent,
+		"digest=../../..":                                    http.StatusBadRequest,
+		"digest=" + digest + "&layer=sha256:abc":             http.StatusBadRequest,
+		"index=sha256:" + strings.Repeat("a", 63) + "/":      http.StatusBadRequest,
+		"digest=" + digest + "&index=" + digest + "&path=..": http.StatusNoContent,
+	} {
+		w := httptest.NewRecorder()
+		validateDigests(next).ServeHTTP(w, httptest.NewRequest("GET", "/api/dir?"+query, nil))
+		require.Equal(t, code, w.Code, query)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"browseimage/bitypes"
+	"browseimage/layerreader"
+	"browseimage/osv"
+	"browseimage/sbom"
+	"browseimage/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// advisoryMaxAge is how long loaded advisories, and the matches made with
+// them, are used before the dumps are read again
+const advisoryMaxAge = 6 * time.Hour
+
+// advisories loads the OSV dumps of ecosystems as they're needed, from keys
+// laid out like the OSV bucket: <ecosystem>/all.zip under prefix.
+type advisories struct {
+	storage storage.Storage
+	prefix  string
+
+	mu    sync.Mutex
+	dumps map[string]*advisoryDump
+}
+
+type advisoryDump struct {
+	once    sync.Once
+	created time.Time
+	db      *osv.DB
+	err     error
+}
+
+// get returns the advisories of a dump, or nil if there's no such dump.
+func (a *advisories) get(ctx context.Context, dump string) (*osv.DB, error) {
+	a.mu.Lock()
+	if a.dumps == nil {
+		a.dumps = map[string]*advisoryDump{}
+	}
+	d := a.dumps[dump]
+	if d == nil || time.Since(d.created) > advisoryMaxAge {
+		d = &advisoryDump{created: time.Now()}
+		a.dumps[dump] = d
+	}
+	a.mu.Unlock()
+
+	d.once.Do(func() {
+		d.db, d.err = a.load(ctx, dump)
+	})
+
+	if errors.Is(d.err, storage.ErrNotFound) {
+		return nil, nil
+	} else if d.err != nil {
+		// try again next time, as it may just be this request that failed
+		a.mu.Lock()
+		if a.dumps[dump] == d {
+			delete(a.dumps, dump)
+		}
+		a.mu.Unlock()
+	}
+	return d.db, d.err
+}
+
+func (a *advisories) load(ctx context.Context, dump string) (*osv.DB, error) {
+	key := a.prefix + dump + "/all.zip"
+	start := time.Now()
+
+	body, err := a.storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("getting %s: %w", key, err)
+	}
+	defer body.Close()
+
+	// zips are read from the end, so it has to be downloaded first
+	f, err := os.CreateTemp("", "advisories*.zip")
+	if err != nil {
+		return nil, fmt.Errorf("creating advisories file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", key, err)
+	}
+
+	db, err := osv.Load(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", key, err)
+	}
+
+	slog.InfoContext(ctx, "loaded advisories", "key", key, "size", size, "duration", time.Since(start))
+	return db, nil
+}
+
+type vulnMatch struct {
+	osv.Finding
+	Package string
+	// Version is the installed version
+	Version string
+	Type    string
+	PURL    string
+	// Location is the file the package was found in, and Layer the layer
+	// that file is from
+	Location string
+	Layer    string `json:",omitempty"`
+}
+
+type vulnsOutput struct {
+	Generated time.Time
+	Distro    *sbom.Distro `json:",omitempty"`
+	// Checked is how many packages were matched against advisories, and
+	// Unsupported how many are of ecosystems there are no advisories for
+	Checked     int
+	Unsupported int
+	Matches     []vulnMatch
+}
+
+// severityRanks sorts the most severe matches first.
+var severityRanks = map[string]int{"CRITICAL": 0, "HIGH": 1, "MEDIUM": 2, "LOW": 3, "NONE": 4, "UNKNOWN": 5}
+
+// handleVulns matches the packages of an image against the advisories of
+// their ecosystems.
+func (h *handler) handleVulns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	q := r.URL.Query()
+	image, _, _ := strings.Cut(q.Get("image"), ":") // drop tag (if any)
+	digest := q.Get("digest")
+
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	output, err := h.vulns(ctx, ref.Context(), image, digest)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "finding packages took too long", http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		panic(fmt.Sprintf("%+v", err))
+	}
+
+	j, _ := json.Marshal(output)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+// vulns returns the advisories that affect an image's packages. The matches
+// are cached in storage and summarized on the image's item, and are made
+// again once they're older than the advisories.
+func (h *handler) vulns(ctx context.Context, repo name.Repository, image, digest string) (*vulnsOutput, error) {
+	key := storage.VulnsKey(image, digest)
+	infoKey := &bitypes.ImageInfoKey{Repo: image, Digest: digest}
+
+	item, _, err := h.backend.imageInfo(ctx, infoKey)
+	if err != nil {
+		return nil, err
+	} else if item == nil || item.Status != bitypes.ImageInfoStatusSucceeded {
+		return nil, storage.ErrNotFound
+	}
+
+	if item.Vulns != nil && time.Since(item.Vulns.Generated) < advisoryMaxAge {
+		output, err := h.cachedVulns(ctx, key)
+		if err == nil {
+			return output, nil
+		}
+		slog.WarnContext(ctx, "reading cached vulnerabilities, matching again", "key", key, "error", err)
+	}
+
+	catalog, err := h.catalog(ctx, repo, image, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &vulnsOutput{Generated: time.Now().UTC(), Distro: catalog.Distro, Matches: []vulnMatch{}}
+	index := storage.ImageIndexKey(image, digest)
+	layers := map[string]string{}
+	for _, p := range catalog.Packages {
+		query, ok := osv.QueryFor(p, catalog.Distro)
+		if !ok {
+			output.Unsupported++
+			continue
+		}
+
+		db, err := h.advisories.get(ctx, query.Dump)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s advisories: %w", query.Dump, err)
+		} else if db == nil {
+			output.Unsupported++
+			continue
+		}
+		output.Checked++
+
+		findings := db.Match(query.Ecosystem, query.Name, query.Version)
+		if len(findings) == 0 {
+			continue
+		}
+
+		// the evidence for a match is the file from the merged filesystem
+		layer, ok := layers[p.Location]
+		if !ok {
+			entries, err := layerreader.LookupImageIndex(ctx, h.storage, index, p.Location)
+			if err != nil {
+				return nil, fmt.Errorf("looking up %s: %w", p.Location, err)
+			}
+			if len(entries) > 0 {
+				layer = entries[0].Layer
+			}
+			layers[p.Location] = layer
+		}
+
+		for _, f := range findings {
+			output.Matches = append(output.Matches, vulnMatch{
+				Finding:  f,
+				Package:  p.Name,
+				Version:  p.Version,
+				Type:     p.Type,
+				PURL:     p.PURL,
+				Location: p.Location,
+				Layer:    layer,
+			})
+		}
+	}
+
+	sort.SliceStable(output.Matches, func(i, j int) bool {
+		a, b := output.Matches[i], output.Matches[j]
+		if ra, rb := severityRanks[a.Severity.Level], severityRanks[b.Severity.Level]; ra != rb {
+			return ra < rb
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.ID < b.ID
+	})
+
+	j, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling vulnerabilities: %w", err)
+	}
+	_, err = h.storage.Put(ctx, key, bytes.NewReader(j))
+	if err != nil {
+		return nil, fmt.Errorf("caching vulnerabilities: %w", err)
+	}
+
+	// the summary goes last, as it marks the cached matches as current
+	summary := &bitypes.Vulns{
+		Generated:   output.Generated,
+		Checked:     output.Checked,
+		Unsupported: output.Unsupported,
+		Matches:     len(output.Matches),
+		Severities:  map[string]int{},
+	}
+	for _, m := range output.Matches {
+		summary.Severities[m.Severity.Level]++
+	}
+	err = h.backend.putVulns(ctx, infoKey, summary)
+	if err != nil {
+		return nil, fmt.Errorf("saving vulnerability summary: %w", err)
+	}
+
+	return output, nil
+}
+
+func (h *handler) cachedVulns(ctx context.Context, key string) (*vulnsOutput, error) {
+	cached, err := h.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer cached.Close()
+
+	output := &vulnsOutput{}
+	err = json.NewDecoder(cached).Decode(output)
+	if err != nil {
+		return nil, fmt.Errorf("decoding cached vulnerabilities: %w", err)
+	}
+
+	return output, nil
+}